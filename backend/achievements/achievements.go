@@ -0,0 +1,233 @@
+package achievements
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"quiz-butterfly/backend/models"
+)
+
+// Trigger is the moment rules are evaluated
+type Trigger string
+
+const (
+	OnAnswer Trigger = "answer"
+	OnFinish Trigger = "finish"
+)
+
+// Rule awards a badge once a metric reaches a threshold. Adding a badge
+// only needs a new entry in Rules (and, for a new kind of progress, a new
+// metric); the handlers don't change.
+type Rule struct {
+	Code        string
+	Name        string
+	Description string
+	Metric      string
+	Threshold   int
+	Triggers    []Trigger
+}
+
+// Rules is the list of badges that can be earned
+var Rules = []Rule{
+	{
+		Code:        "first_perfect_score",
+		Name:        "Flawless",
+		Description: "Finish a quiz with every answer correct",
+		Metric:      "perfect_sessions",
+		Threshold:   1,
+		Triggers:    []Trigger{OnFinish},
+	},
+	{
+		Code:        "streak_7_days",
+		Name:        "Seven Day Streak",
+		Description: "Answer questions on 7 days in a row",
+		Metric:      "longest_streak_days",
+		Threshold:   7,
+		Triggers:    []Trigger{OnAnswer},
+	},
+	{
+		Code:        "all_difficulties",
+		Name:        "Well Rounded",
+		Description: "Finish a quiz on every difficulty",
+		Metric:      "difficulties_completed",
+		Threshold:   3,
+		Triggers:    []Trigger{OnFinish},
+	},
+	{
+		Code:        "questions_100",
+		Name:        "Centurion",
+		Description: "Answer 100 questions",
+		Metric:      "answers_total",
+		Threshold:   100,
+		Triggers:    []Trigger{OnAnswer},
+	},
+}
+
+// metric is a SQL query returning one integer for the user in $1. A zoned
+// metric counts calendar days, and takes the time zone they follow in $2.
+type metric struct {
+	query string
+	zoned bool
+}
+
+var metrics = map[string]metric{
+	"answers_total": {query: `
+		SELECT COUNT(*) FROM user_answers ua
+		JOIN quiz_sessions qs ON qs.id = ua.quiz_session_id
+		WHERE qs.user_id = $1`},
+
+	// A session is perfect when it went through every question of its set
+	// (or of its difficulty) without a wrong answer
+	"perfect_sessions": {query: `
+		SELECT COUNT(*) FROM quiz_sessions qs
+		WHERE qs.user_id = $1 AND qs.status = 'finished' AND qs.score > 0
+			AND qs.score >= COALESCE(array_length(qs.question_ids, 1),
+				(SELECT COUNT(*) FROM questions q WHERE q.difficulty = qs.difficulty))`},
+
+	"difficulties_completed": {query: `
+		SELECT COUNT(DISTINCT difficulty) FROM quiz_sessions
+		WHERE user_id = $1 AND status = 'finished' AND mode = 'standard'`},
+
+	// Gaps and islands: consecutive days share the same (day - row number)
+	"longest_streak_days": {query: `
+		SELECT COALESCE(MAX(streak), 0) FROM (
+			SELECT COUNT(*) AS streak FROM (
+				SELECT day, day - (ROW_NUMBER() OVER (ORDER BY day))::int AS grp
+				FROM (
+					SELECT DISTINCT (ua.answered_at AT TIME ZONE $2)::date AS day
+					FROM user_answers ua
+					JOIN quiz_sessions qs ON qs.id = ua.quiz_session_id
+					WHERE qs.user_id = $1
+				) days
+			) islands GROUP BY grp
+		) streaks`, zoned: true},
+}
+
+// Evaluate checks the rules for trigger and awards any the user newly
+// qualifies for. Streaks count days in loc, the daily challenge's time
+// zone. It returns only the new awards.
+func Evaluate(ctx context.Context, db *sql.DB, userID int, trigger Trigger, loc *time.Location) ([]models.Achievement, error) {
+	earned, err := earnedCodes(ctx, db, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	values := make(map[string]int)
+	var awarded []models.Achievement
+
+	for _, rule := range Rules {
+		if _, done := earned[rule.Code]; done || !rule.triggeredBy(trigger) {
+			continue
+		}
+
+		value, cached := values[rule.Metric]
+		if !cached {
+			m, ok := metrics[rule.Metric]
+			if !ok {
+				return awarded, fmt.Errorf("achievement %s: unknown metric %q", rule.Code, rule.Metric)
+			}
+			args := []any{userID}
+			if m.zoned {
+				args = append(args, loc.String())
+			}
+			if err := db.QueryRowContext(ctx, m.query, args...).Scan(&value); err != nil {
+				return awarded, fmt.Errorf("achievement %s: metric %s: %w", rule.Code, rule.Metric, err)
+			}
+			values[rule.Metric] = value
+		}
+
+		if value < rule.Threshold {
+			continue
+		}
+
+		var awardedAt time.Time
+		err := db.QueryRowContext(ctx, `
+			INSERT INTO user_achievements (user_id, code) VALUES ($1, $2)
+			ON CONFLICT (user_id, code) DO NOTHING
+			RETURNING awarded_at`, userID, rule.Code).Scan(&awardedAt)
+		if err == sql.ErrNoRows {
+			// Awarded concurrently by another request
+			continue
+		}
+		if err != nil {
+			return awarded, fmt.Errorf("achievement %s: award: %w", rule.Code, err)
+		}
+		awarded = append(awarded, rule.achievement(awardedAt))
+	}
+
+	return awarded, nil
+}
+
+// ForUser returns every achievement the user has earned
+func ForUser(ctx context.Context, db *sql.DB, userID int) ([]models.Achievement, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT code, awarded_at FROM user_achievements
+		WHERE user_id = $1 ORDER BY awarded_at`, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	achievements := []models.Achievement{}
+	for rows.Next() {
+		var code string
+		var awardedAt time.Time
+		if err := rows.Scan(&code, &awardedAt); err != nil {
+			return nil, err
+		}
+		rule, ok := ruleByCode(code)
+		if !ok {
+			// Badge was retired from Rules; keep the record but skip it
+			continue
+		}
+		achievements = append(achievements, rule.achievement(awardedAt))
+	}
+	return achievements, rows.Err()
+}
+
+func earnedCodes(ctx context.Context, db *sql.DB, userID int) (map[string]struct{}, error) {
+	rows, err := db.QueryContext(ctx, "SELECT code FROM user_achievements WHERE user_id = $1", userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	codes := make(map[string]struct{})
+	for rows.Next() {
+		var code string
+		if err := rows.Scan(&code); err != nil {
+			return nil, err
+		}
+		codes[code] = struct{}{}
+	}
+	return codes, rows.Err()
+}
+
+func ruleByCode(code string) (Rule, bool) {
+	for _, rule := range Rules {
+		if rule.Code == code {
+			return rule, true
+		}
+	}
+	return Rule{}, false
+}
+
+func (r Rule) triggeredBy(trigger Trigger) bool {
+	for _, t := range r.Triggers {
+		if t == trigger {
+			return true
+		}
+	}
+	return false
+}
+
+func (r Rule) achievement(awardedAt time.Time) models.Achievement {
+	return models.Achievement{
+		Code:        r.Code,
+		Name:        r.Name,
+		Description: r.Description,
+		AwardedAt:   awardedAt,
+	}
+}
@@ -0,0 +1,39 @@
+package achievements
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+func TestStreakDaysFollowLocation(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	jakarta, err := time.LoadLocation("Asia/Jakarta")
+	if err != nil {
+		t.Skip("time zone data not available:", err)
+	}
+
+	mock.ExpectQuery(`SELECT code FROM user_achievements`).
+		WillReturnRows(sqlmock.NewRows([]string{"code"}))
+	// OnAnswer rules, in the order of Rules: the streak, then answers
+	mock.ExpectQuery(`AT TIME ZONE \$2`).
+		WithArgs(7, "Asia/Jakarta").
+		WillReturnRows(sqlmock.NewRows([]string{"streak"}).AddRow(2))
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM user_answers`).
+		WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"count"}).AddRow(2))
+
+	if _, err := Evaluate(context.Background(), db, 7, OnAnswer, jakarta); err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,454 @@
+package apierror
+
+import (
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+
+	"quiz-butterfly/backend/locale"
+	"quiz-butterfly/backend/models"
+)
+
+// Code identifies an error for clients. Codes are stable; messages may be
+// reworded or translated at any time.
+type Code string
+
+// General errors
+const (
+	InternalError     Code = "internal_error"
+	InvalidRequest    Code = "invalid_request"
+	ConcurrentChange  Code = "concurrent_change"
+	ValidationFailed  Code = "validation_failed"
+	TooManyRequests   Code = "too_many_requests"
+	UnsupportedLocale Code = "unsupported_locale"
+	Timeout           Code = "timeout"
+	RequestCanceled   Code = "request_canceled"
+)
+
+// Authentication and access
+const (
+	AuthorizationRequired Code = "authorization_required"
+	InvalidAuthorization  Code = "invalid_authorization_header"
+	InvalidToken          Code = "invalid_token"
+	InvalidCredentials    Code = "invalid_credentials"
+	UsernameTaken         Code = "username_taken"
+	EmailTaken            Code = "email_taken"
+	AccountRequired       Code = "account_required"
+	TeacherRequired       Code = "teacher_required"
+	AdminRequired         Code = "admin_required"
+	InvalidResetToken     Code = "invalid_reset_token"
+	GuestNotFound         Code = "guest_not_found"
+	AlreadyRegistered     Code = "already_registered"
+	OIDCDisabled          Code = "oidc_disabled"
+	OIDCUnavailable       Code = "oidc_unavailable"
+	OIDCRejected          Code = "oidc_rejected"
+	OIDCStateExpired      Code = "oidc_state_expired"
+	OIDCInvalidState      Code = "oidc_invalid_state"
+	OIDCExchangeFailed    Code = "oidc_exchange_failed"
+	OIDCInvalidIDToken    Code = "oidc_invalid_id_token"
+	OIDCLinkRequired      Code = "oidc_link_required"
+)
+
+// Questions, quizzes and leaderboards
+const (
+	InvalidQuestionID   Code = "invalid_question_id"
+	QuestionNotFound    Code = "question_not_found"
+	DuplicateQuestion   Code = "duplicate_question"
+	SimilarQuestion     Code = "similar_question"
+	TranslationNotFound Code = "translation_not_found"
+	InvalidDifficulty   Code = "invalid_difficulty"
+	InvalidWindow       Code = "invalid_window"
+	InvalidDate         Code = "invalid_date"
+	InvalidTime         Code = "invalid_time"
+	InvalidLimit        Code = "invalid_limit"
+	InvalidCursor       Code = "invalid_cursor"
+	InvalidThreshold    Code = "invalid_threshold"
+	NoActiveSession     Code = "no_active_session"
+	NotCurrentQuestion  Code = "not_current_question"
+	QuizComplete        Code = "quiz_complete"
+	NoQuestions         Code = "no_questions"
+	DailyNoQuestions    Code = "daily_no_questions"
+	DailyStarted        Code = "daily_already_started"
+	DailyPlayed         Code = "daily_already_played"
+)
+
+// Attachments
+const (
+	InvalidAttachmentID   Code = "invalid_attachment_id"
+	AttachmentNotFound    Code = "attachment_not_found"
+	InvalidAttachmentLink Code = "invalid_attachment_link"
+	AttachmentMissing     Code = "attachment_missing"
+	AttachmentTooLarge    Code = "attachment_too_large"
+	AttachmentType        Code = "attachment_type_not_allowed"
+	AttachmentUnreadable  Code = "attachment_unreadable"
+)
+
+// Classes
+const (
+	InvalidUserID        Code = "invalid_user_id"
+	InvalidClassID       Code = "invalid_class_id"
+	UserNotFound         Code = "user_not_found"
+	ClassNotFound        Code = "class_not_found"
+	MemberNotFound       Code = "member_not_found"
+	InvalidJoinCode      Code = "invalid_join_code"
+	OwnClass             Code = "own_class"
+	ClassTeacherRequired Code = "class_teacher_required"
+	AssignmentTarget     Code = "assignment_target_required"
+	DueDateInPast        Code = "due_date_in_past"
+	UnknownQuestions     Code = "unknown_question_ids"
+)
+
+// Live rooms
+const (
+	RoomNotFound    Code = "room_not_found"
+	RoomStarted     Code = "room_started"
+	RoomFull        Code = "room_full"
+	LiveUnavailable Code = "live_unavailable"
+	// Sent over the room's WebSocket in an "error" event
+	LiveNotHost          Code = "live_not_host"
+	LiveNotAccepting     Code = "live_not_accepting_answers"
+	LiveAlreadyAnswered  Code = "live_already_answered"
+	LiveWrongQuestion    Code = "live_wrong_question"
+	LiveHostCannotAnswer Code = "live_host_cannot_answer"
+	LiveUnknownMessage   Code = "live_unknown_message"
+)
+
+// messages holds the text of each code per locale. Some take fmt
+// arguments, passed through New.
+var messages = map[Code]map[string]string{
+	InternalError: {
+		locale.English:    "Something went wrong, please try again later",
+		locale.Indonesian: "Terjadi kesalahan, silakan coba lagi nanti",
+	},
+	ConcurrentChange: {
+		locale.English:    "Someone else changed this at the same time, please reload and try again",
+		locale.Indonesian: "Data ini diubah orang lain pada saat yang sama, silakan muat ulang lalu coba lagi",
+	},
+	InvalidRequest: {
+		locale.English:    "The request body is not valid JSON",
+		locale.Indonesian: "Isi permintaan bukan JSON yang valid",
+	},
+	ValidationFailed: {
+		locale.English:    "Some fields are invalid",
+		locale.Indonesian: "Beberapa isian tidak valid",
+	},
+	TooManyRequests: {
+		locale.English:    "Too many requests, please try again later",
+		locale.Indonesian: "Terlalu banyak permintaan, silakan coba lagi nanti",
+	},
+	UnsupportedLocale: {
+		locale.English:    "Unsupported locale, expected one of %s",
+		locale.Indonesian: "Bahasa tidak didukung, gunakan salah satu dari %s",
+	},
+	Timeout: {
+		locale.English:    "The server took too long to respond, please try again",
+		locale.Indonesian: "Server terlalu lama merespons, silakan coba lagi",
+	},
+	RequestCanceled: {
+		locale.English:    "The request was cancelled before it finished",
+		locale.Indonesian: "Permintaan dibatalkan sebelum selesai",
+	},
+
+	AuthorizationRequired: {
+		locale.English:    "Authorization header required",
+		locale.Indonesian: "Header Authorization wajib diisi",
+	},
+	InvalidAuthorization: {
+		locale.English:    "Invalid authorization header format",
+		locale.Indonesian: "Format header Authorization tidak valid",
+	},
+	InvalidToken: {
+		locale.English:    "Invalid or expired token, please sign in again",
+		locale.Indonesian: "Token tidak valid atau kedaluwarsa, silakan masuk lagi",
+	},
+	InvalidCredentials: {
+		locale.English:    "Invalid username or password",
+		locale.Indonesian: "Username atau password salah",
+	},
+	UsernameTaken: {
+		locale.English:    "Username already exists",
+		locale.Indonesian: "Username sudah dipakai",
+	},
+	EmailTaken: {
+		locale.English:    "Email already registered",
+		locale.Indonesian: "Email sudah terdaftar",
+	},
+	AccountRequired: {
+		locale.English:    "Please create an account to use this feature",
+		locale.Indonesian: "Silakan buat akun untuk memakai fitur ini",
+	},
+	TeacherRequired: {
+		locale.English:    "Teacher access required",
+		locale.Indonesian: "Hanya guru yang dapat mengakses ini",
+	},
+	AdminRequired: {
+		locale.English:    "Admin access required",
+		locale.Indonesian: "Hanya admin yang dapat mengakses ini",
+	},
+	InvalidResetToken: {
+		locale.English:    "Invalid or expired reset token",
+		locale.Indonesian: "Token reset tidak valid atau kedaluwarsa",
+	},
+	GuestNotFound: {
+		locale.English:    "Guest account not found or already upgraded",
+		locale.Indonesian: "Akun guest tidak ditemukan atau sudah di-upgrade",
+	},
+	AlreadyRegistered: {
+		locale.English:    "Account is already registered",
+		locale.Indonesian: "Akun sudah terdaftar",
+	},
+	OIDCDisabled: {
+		locale.English:    "OIDC login is not enabled",
+		locale.Indonesian: "Login OIDC tidak diaktifkan",
+	},
+	OIDCUnavailable: {
+		locale.English:    "Identity provider unavailable",
+		locale.Indonesian: "Penyedia identitas tidak tersedia",
+	},
+	OIDCRejected: {
+		locale.English:    "Login rejected by identity provider: %s",
+		locale.Indonesian: "Login ditolak oleh penyedia identitas: %s",
+	},
+	OIDCStateExpired: {
+		locale.English:    "Login session expired, please try again",
+		locale.Indonesian: "Sesi login kedaluwarsa, silakan coba lagi",
+	},
+	OIDCInvalidState: {
+		locale.English:    "Invalid login state",
+		locale.Indonesian: "State login tidak valid",
+	},
+	OIDCExchangeFailed: {
+		locale.English:    "Failed to exchange authorization code",
+		locale.Indonesian: "Gagal menukar kode otorisasi",
+	},
+	OIDCInvalidIDToken: {
+		locale.English:    "Identity provider returned an invalid ID token",
+		locale.Indonesian: "Penyedia identitas mengirim ID token yang tidak valid",
+	},
+	OIDCLinkRequired: {
+		locale.English:    "An account with this email already exists; confirm the email by resetting its password, then sign in with the provider again",
+		locale.Indonesian: "Sudah ada akun dengan email ini; konfirmasi email dengan mereset password akun tersebut, lalu masuk lewat penyedia identitas lagi",
+	},
+
+	InvalidQuestionID: {
+		locale.English:    "Invalid question ID",
+		locale.Indonesian: "ID soal tidak valid",
+	},
+	QuestionNotFound: {
+		locale.English:    "Question not found",
+		locale.Indonesian: "Soal tidak ditemukan",
+	},
+	DuplicateQuestion: {
+		locale.English:    "An identical question already exists",
+		locale.Indonesian: "Soal yang sama persis sudah ada",
+	},
+	SimilarQuestion: {
+		locale.English:    "Similar questions already exist; resend with ?allow_similar=true to save anyway",
+		locale.Indonesian: "Sudah ada soal yang mirip; kirim ulang dengan ?allow_similar=true untuk tetap menyimpan",
+	},
+	TranslationNotFound: {
+		locale.English:    "Translation not found",
+		locale.Indonesian: "Terjemahan tidak ditemukan",
+	},
+	InvalidDifficulty: {
+		locale.English:    "Invalid difficulty level",
+		locale.Indonesian: "Tingkat kesulitan tidak valid",
+	},
+	InvalidWindow: {
+		locale.English:    "Invalid window, expected day, week, month or all",
+		locale.Indonesian: "Rentang tidak valid, gunakan day, week, month, atau all",
+	},
+	InvalidDate: {
+		locale.English:    "Invalid date, expected YYYY-MM-DD",
+		locale.Indonesian: "Tanggal tidak valid, gunakan format YYYY-MM-DD",
+	},
+	InvalidTime: {
+		locale.English:    "Invalid %s, expected RFC 3339 time or YYYY-MM-DD",
+		locale.Indonesian: "%s tidak valid, gunakan waktu RFC 3339 atau YYYY-MM-DD",
+	},
+	InvalidLimit: {
+		locale.English:    "Invalid limit, expected 1-%d",
+		locale.Indonesian: "limit tidak valid, gunakan 1-%d",
+	},
+	InvalidCursor: {
+		locale.English:    "Invalid cursor",
+		locale.Indonesian: "Cursor tidak valid",
+	},
+	InvalidThreshold: {
+		locale.English:    "Invalid threshold, expected a number from 0.3 to 1",
+		locale.Indonesian: "Threshold tidak valid, gunakan angka 0.3 sampai 1",
+	},
+	NoActiveSession: {
+		locale.English:    "No active quiz session",
+		locale.Indonesian: "Tidak ada sesi kuis yang aktif",
+	},
+	NotCurrentQuestion: {
+		locale.English:    "That is not the current question of your quiz",
+		locale.Indonesian: "Itu bukan soal yang sedang dikerjakan di kuismu",
+	},
+	QuizComplete: {
+		locale.English:    "Every question has been answered; finish the quiz",
+		locale.Indonesian: "Semua soal sudah dijawab; selesaikan kuisnya",
+	},
+	NoQuestions: {
+		locale.English:    "No questions available for this difficulty",
+		locale.Indonesian: "Belum ada soal untuk tingkat kesulitan ini",
+	},
+	DailyNoQuestions: {
+		locale.English:    "No questions available for the daily challenge",
+		locale.Indonesian: "Belum ada soal untuk daily challenge",
+	},
+	DailyStarted: {
+		locale.English:    "Daily challenge already started",
+		locale.Indonesian: "Daily challenge sudah dimulai",
+	},
+	DailyPlayed: {
+		locale.English:    "You have already played today's daily challenge",
+		locale.Indonesian: "Kamu sudah memainkan daily challenge hari ini",
+	},
+
+	InvalidAttachmentID: {
+		locale.English:    "Invalid attachment ID",
+		locale.Indonesian: "ID lampiran tidak valid",
+	},
+	AttachmentNotFound: {
+		locale.English:    "Attachment not found",
+		locale.Indonesian: "Lampiran tidak ditemukan",
+	},
+	InvalidAttachmentLink: {
+		locale.English:    "Invalid or expired attachment link",
+		locale.Indonesian: "Tautan lampiran tidak valid atau kedaluwarsa",
+	},
+	AttachmentMissing: {
+		locale.English:    "Missing file",
+		locale.Indonesian: "File belum dipilih",
+	},
+	AttachmentTooLarge: {
+		locale.English:    "Attachment exceeds %d bytes",
+		locale.Indonesian: "Lampiran melebihi %d byte",
+	},
+	AttachmentType: {
+		locale.English:    "Only PNG, JPEG, GIF and WebP images are allowed",
+		locale.Indonesian: "Hanya gambar PNG, JPEG, GIF, dan WebP yang diperbolehkan",
+	},
+	AttachmentUnreadable: {
+		locale.English:    "Failed to read file",
+		locale.Indonesian: "Gagal membaca file",
+	},
+
+	InvalidUserID: {
+		locale.English:    "Invalid user ID",
+		locale.Indonesian: "ID user tidak valid",
+	},
+	InvalidClassID: {
+		locale.English:    "Invalid class ID",
+		locale.Indonesian: "ID kelas tidak valid",
+	},
+	UserNotFound: {
+		locale.English:    "User not found",
+		locale.Indonesian: "User tidak ditemukan",
+	},
+	ClassNotFound: {
+		locale.English:    "Class not found",
+		locale.Indonesian: "Kelas tidak ditemukan",
+	},
+	MemberNotFound: {
+		locale.English:    "Member not found",
+		locale.Indonesian: "Anggota tidak ditemukan",
+	},
+	InvalidJoinCode: {
+		locale.English:    "Invalid join code",
+		locale.Indonesian: "Kode kelas tidak valid",
+	},
+	OwnClass: {
+		locale.English:    "You are the teacher of this class",
+		locale.Indonesian: "Kamu adalah guru di kelas ini",
+	},
+	ClassTeacherRequired: {
+		locale.English:    "Only the class teacher can do this",
+		locale.Indonesian: "Hanya guru kelas ini yang dapat melakukannya",
+	},
+	AssignmentTarget: {
+		locale.English:    "Provide either a difficulty or question IDs",
+		locale.Indonesian: "Isi salah satu: tingkat kesulitan atau daftar ID soal",
+	},
+	DueDateInPast: {
+		locale.English:    "Due date must be in the future",
+		locale.Indonesian: "Tenggat harus di masa depan",
+	},
+	UnknownQuestions: {
+		locale.English:    "Some question IDs do not exist",
+		locale.Indonesian: "Beberapa ID soal tidak ada",
+	},
+
+	RoomNotFound: {
+		locale.English:    "Room not found",
+		locale.Indonesian: "Room tidak ditemukan",
+	},
+	RoomStarted: {
+		locale.English:    "The game has already started",
+		locale.Indonesian: "Permainan sudah dimulai",
+	},
+	RoomFull: {
+		locale.English:    "Room is full",
+		locale.Indonesian: "Room sudah penuh",
+	},
+	LiveUnavailable: {
+		locale.English:    "Live quizzes are unavailable right now",
+		locale.Indonesian: "Live quiz sedang tidak tersedia",
+	},
+	LiveNotHost: {
+		locale.English:    "Only the host can do that",
+		locale.Indonesian: "Hanya host yang bisa melakukannya",
+	},
+	LiveNotAccepting: {
+		locale.English:    "Answers are not being accepted right now",
+		locale.Indonesian: "Jawaban sedang tidak diterima",
+	},
+	LiveAlreadyAnswered: {
+		locale.English:    "You already answered this question",
+		locale.Indonesian: "Kamu sudah menjawab soal ini",
+	},
+	LiveWrongQuestion: {
+		locale.English:    "That answer is for a different question",
+		locale.Indonesian: "Jawaban itu untuk soal yang lain",
+	},
+	LiveHostCannotAnswer: {
+		locale.English:    "The host cannot answer",
+		locale.Indonesian: "Host tidak bisa menjawab",
+	},
+	LiveUnknownMessage: {
+		locale.English:    "Unknown message type",
+		locale.Indonesian: "Jenis pesan tidak dikenal",
+	},
+}
+
+// Message returns the text for code in loc, falling back to English
+func Message(code Code, loc string, args ...interface{}) string {
+	texts, ok := messages[code]
+	if !ok {
+		return string(code)
+	}
+	text, ok := texts[loc]
+	if !ok {
+		text = texts[locale.Default]
+	}
+	if len(args) > 0 {
+		return fmt.Sprintf(text, args...)
+	}
+	return text
+}
+
+// RequestLocale picks the language for error messages from the
+// Accept-Language header
+func RequestLocale(c *gin.Context) string {
+	if l, ok := locale.FromAcceptLanguage(c.GetHeader("Accept-Language")); ok {
+		return l
+	}
+	return locale.Default
+}
+
+// New builds the response for code in the request's language
+func New(c *gin.Context, code Code, args ...interface{}) models.ErrorResponse {
+	return models.ErrorResponse{Code: string(code), Error: Message(code, RequestLocale(c), args...)}
+}
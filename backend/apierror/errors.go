@@ -0,0 +1,179 @@
+package apierror
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"quiz-butterfly/backend/locale"
+	"quiz-butterfly/backend/logging"
+	"quiz-butterfly/backend/models"
+)
+
+// Kind classifies an error by what went wrong, which decides its status
+type Kind int
+
+// Error kinds
+const (
+	KindInternal Kind = iota
+	KindValidation
+	KindUnauthorized
+	KindForbidden
+	KindNotFound
+	KindConflict
+	KindTooLarge
+	KindUnsupportedMedia
+	KindUnavailable
+	KindTimeout
+)
+
+// Status returns the HTTP status for errors of kind k
+func (k Kind) Status() int {
+	switch k {
+	case KindValidation:
+		return http.StatusBadRequest
+	case KindUnauthorized:
+		return http.StatusUnauthorized
+	case KindForbidden:
+		return http.StatusForbidden
+	case KindNotFound:
+		return http.StatusNotFound
+	case KindConflict:
+		return http.StatusConflict
+	case KindTooLarge:
+		return http.StatusRequestEntityTooLarge
+	case KindUnsupportedMedia:
+		return http.StatusUnsupportedMediaType
+	case KindUnavailable:
+		return http.StatusServiceUnavailable
+	case KindTimeout:
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusInternalServerError
+}
+
+// Error is an error a handler reports to the client. Handlers pass it to
+// c.Error and Middleware writes the response, so the status always follows
+// from the kind.
+type Error struct {
+	Kind    Kind
+	Code    Code
+	Args    []interface{}
+	Details []models.FieldError
+
+	// Op and Cause describe internal errors for the log. They are never
+	// sent to the client.
+	Op    string
+	Cause error
+}
+
+func (e *Error) Error() string {
+	msg := e.Op
+	if msg == "" {
+		msg = Message(e.Code, locale.Default, e.Args...)
+	}
+	if e.Cause != nil {
+		msg += ": " + e.Cause.Error()
+	}
+	return msg
+}
+
+func (e *Error) Unwrap() error {
+	return e.Cause
+}
+
+// BadRequest reports a request the handler can't act on, such as a
+// malformed ID or query parameter
+func BadRequest(code Code, args ...interface{}) *Error {
+	return &Error{Kind: KindValidation, Code: code, Args: args}
+}
+
+// Validation reports fields that failed validation
+func Validation(problems ...models.FieldError) *Error {
+	return &Error{Kind: KindValidation, Code: ValidationFailed, Details: problems}
+}
+
+// Binding reports a request body that ShouldBindJSON rejected, field by
+// field when the validator did
+func Binding(err error) *Error {
+	if problems := bindingProblems(err); len(problems) > 0 {
+		e := Validation(problems...)
+		e.Cause = err
+		return e
+	}
+	return &Error{Kind: KindValidation, Code: InvalidRequest, Cause: err}
+}
+
+// Unauthorized reports a missing or unusable identity
+func Unauthorized(code Code, args ...interface{}) *Error {
+	return &Error{Kind: KindUnauthorized, Code: code, Args: args}
+}
+
+// Forbidden reports a known user without access
+func Forbidden(code Code, args ...interface{}) *Error {
+	return &Error{Kind: KindForbidden, Code: code, Args: args}
+}
+
+// NotFound reports a missing resource
+func NotFound(code Code, args ...interface{}) *Error {
+	return &Error{Kind: KindNotFound, Code: code, Args: args}
+}
+
+// Conflict reports a request that clashes with the current state
+func Conflict(code Code, args ...interface{}) *Error {
+	return &Error{Kind: KindConflict, Code: code, Args: args}
+}
+
+// PayloadTooLarge reports a request body over its limit
+func PayloadTooLarge(code Code, args ...interface{}) *Error {
+	return &Error{Kind: KindTooLarge, Code: code, Args: args}
+}
+
+// UnsupportedMedia reports content of a type the endpoint doesn't accept
+func UnsupportedMedia(code Code, args ...interface{}) *Error {
+	return &Error{Kind: KindUnsupportedMedia, Code: code, Args: args}
+}
+
+// Unavailable reports a feature or dependency that is off or down
+func Unavailable(code Code, args ...interface{}) *Error {
+	return &Error{Kind: KindUnavailable, Code: code, Args: args}
+}
+
+// Internal reports a failure on our side. op says what was being done
+// and cause why it failed; both are logged, and the client only learns
+// that something went wrong. A cause that is a query running out of time
+// or a request cancelled mid-way is reported as such instead, with 504 or
+// 503.
+func Internal(cause error, op string) *Error {
+	switch {
+	case errors.Is(cause, context.DeadlineExceeded):
+		return &Error{Kind: KindTimeout, Code: Timeout, Op: op, Cause: cause}
+	case errors.Is(cause, context.Canceled):
+		return &Error{Kind: KindUnavailable, Code: RequestCanceled, Op: op, Cause: cause}
+	}
+	return &Error{Kind: KindInternal, Code: InternalError, Op: op, Cause: cause}
+}
+
+// As finds the *Error in err's chain. Any other error is treated as
+// internal, so an unexpected error never leaks its text to the client.
+func As(err error) *Error {
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+	return Internal(err, "Unexpected error")
+}
+
+// Response builds the body for e in the request's language
+func Response(c *gin.Context, e *Error) models.ErrorResponse {
+	if e.Code == ValidationFailed && len(e.Details) > 0 {
+		return InvalidFields(c, e.Details...)
+	}
+	resp := New(c, e.Code, e.Args...)
+	if e.Kind == KindInternal || e.Kind == KindTimeout {
+		resp.RequestID = c.GetString(logging.RequestIDKey)
+	}
+	return resp
+}
@@ -0,0 +1,51 @@
+package apierror
+
+import (
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/trace"
+
+	"quiz-butterfly/backend/logging"
+)
+
+// Middleware writes the response for the last error a handler added with
+// c.Error. Internal errors are logged with their cause and request ID, and
+// recorded on the request's span; the client gets a generic message and
+// the ID to quote. Timeouts and cancellations are logged as warnings.
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Next()
+
+		last := c.Errors.Last()
+		if last == nil {
+			return
+		}
+		e := As(last.Err)
+		switch {
+		case e.Kind == KindInternal:
+			logging.From(c).Error(e.Op, "error", e.Cause, "code", string(e.Code))
+			if e.Cause != nil {
+				trace.SpanFromContext(c.Request.Context()).RecordError(e.Cause)
+			}
+		case e.Op != "":
+			// An internal error that timed out or was cancelled
+			logging.From(c).Warn(e.Op, "error", e.Cause, "code", string(e.Code))
+			trace.SpanFromContext(c.Request.Context()).RecordError(e.Cause)
+		}
+		if c.Writer.Written() {
+			return
+		}
+		c.JSON(e.Kind.Status(), Response(c, e))
+	}
+}
+
+// Status returns the status of the response, counting an error Middleware
+// has yet to write. Middleware that runs inside it and looks at the status
+// after c.Next should use this rather than c.Writer.Status.
+func Status(c *gin.Context) int {
+	if !c.Writer.Written() {
+		if last := c.Errors.Last(); last != nil {
+			return As(last.Err).Kind.Status()
+		}
+	}
+	return c.Writer.Status()
+}
@@ -0,0 +1,304 @@
+package apierror
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+
+	"quiz-butterfly/backend/locale"
+	"quiz-butterfly/backend/models"
+)
+
+// Field error codes, shared by request binding and the validation done by
+// handlers, grading and the credential policies
+const (
+	Required      = "required"
+	TooShort      = "too_short"
+	TooLong       = "too_long"
+	TooLongBytes  = "too_long_bytes"
+	TooFew        = "too_few"
+	TooMany       = "too_many"
+	TooSmall      = "too_small"
+	TooLarge      = "too_large"
+	WrongLength   = "wrong_length"
+	WrongCount    = "wrong_count"
+	LengthBetween = "length_between"
+	InvalidEmail  = "invalid_email"
+	NotAllowed    = "not_allowed"
+	InvalidType   = "invalid_type"
+	Invalid       = "invalid"
+	OutOfRange    = "out_of_range"
+	NotUnique     = "not_unique"
+	Blank         = "blank"
+	NotApplicable = "not_applicable"
+	SameAsSource  = "same_as_source"
+
+	NeedsUpper       = "needs_uppercase"
+	NeedsLower       = "needs_lowercase"
+	NeedsDigit       = "needs_digit"
+	NeedsSymbol      = "needs_symbol"
+	ContainsUsername = "contains_username"
+	Breached         = "breached"
+	BadCharacters    = "invalid_characters"
+	Reserved         = "reserved"
+)
+
+// fieldMessages holds the text of each field error code per locale. The
+// first argument is the field name and the second the code's parameter.
+var fieldMessages = map[string]map[string]string{
+	Required: {
+		locale.English:    "%s is required",
+		locale.Indonesian: "%s wajib diisi",
+	},
+	TooShort: {
+		locale.English:    "%s must be at least %s characters",
+		locale.Indonesian: "%s minimal %s karakter",
+	},
+	TooLong: {
+		locale.English:    "%s must be at most %s characters",
+		locale.Indonesian: "%s maksimal %s karakter",
+	},
+	TooLongBytes: {
+		locale.English:    "%s is longer than %s bytes, only that many are used",
+		locale.Indonesian: "%s lebih dari %s byte, hanya sebanyak itu yang dipakai",
+	},
+	TooFew: {
+		locale.English:    "%s must have at least %s items",
+		locale.Indonesian: "%s minimal berisi %s item",
+	},
+	TooMany: {
+		locale.English:    "%s must have at most %s items",
+		locale.Indonesian: "%s maksimal berisi %s item",
+	},
+	TooSmall: {
+		locale.English:    "%s must be at least %s",
+		locale.Indonesian: "%s minimal %s",
+	},
+	TooLarge: {
+		locale.English:    "%s must be at most %s",
+		locale.Indonesian: "%s maksimal %s",
+	},
+	WrongLength: {
+		locale.English:    "%s must be exactly %s characters",
+		locale.Indonesian: "%s harus tepat %s karakter",
+	},
+	WrongCount: {
+		locale.English:    "%s must have exactly %s items",
+		locale.Indonesian: "%s harus berisi tepat %s item",
+	},
+	LengthBetween: {
+		locale.English:    "%s must be %s characters long",
+		locale.Indonesian: "%s harus %s karakter",
+	},
+	InvalidEmail: {
+		locale.English:    "%s must be a valid email address",
+		locale.Indonesian: "%s harus berupa alamat email yang valid",
+	},
+	NotAllowed: {
+		locale.English:    "%s must be one of: %s",
+		locale.Indonesian: "%s harus salah satu dari: %s",
+	},
+	InvalidType: {
+		locale.English:    "%s must be of type %s",
+		locale.Indonesian: "%s harus bertipe %s",
+	},
+	Invalid: {
+		locale.English:    "%s is invalid",
+		locale.Indonesian: "%s tidak valid",
+	},
+	OutOfRange: {
+		locale.English:    "%s is out of range",
+		locale.Indonesian: "%s di luar jangkauan",
+	},
+	NotUnique: {
+		locale.English:    "%s must not contain duplicates",
+		locale.Indonesian: "%s tidak boleh berisi duplikat",
+	},
+	Blank: {
+		locale.English:    "%s must not contain blank entries",
+		locale.Indonesian: "%s tidak boleh berisi isian kosong",
+	},
+	NotApplicable: {
+		locale.English:    "%s does not apply to this question type",
+		locale.Indonesian: "%s tidak berlaku untuk tipe soal ini",
+	},
+	SameAsSource: {
+		locale.English:    "%s must differ from the question's own language (%s)",
+		locale.Indonesian: "%s harus berbeda dari bahasa asli soal (%s)",
+	},
+
+	NeedsUpper: {
+		locale.English:    "%s must contain an uppercase letter",
+		locale.Indonesian: "%s harus mengandung huruf besar",
+	},
+	NeedsLower: {
+		locale.English:    "%s must contain a lowercase letter",
+		locale.Indonesian: "%s harus mengandung huruf kecil",
+	},
+	NeedsDigit: {
+		locale.English:    "%s must contain a digit",
+		locale.Indonesian: "%s harus mengandung angka",
+	},
+	NeedsSymbol: {
+		locale.English:    "%s must contain a symbol",
+		locale.Indonesian: "%s harus mengandung simbol",
+	},
+	ContainsUsername: {
+		locale.English:    "%s must not contain the username",
+		locale.Indonesian: "%s tidak boleh mengandung username",
+	},
+	Breached: {
+		locale.English:    "%s has appeared in a data breach, please choose another one",
+		locale.Indonesian: "%s pernah muncul dalam kebocoran data, silakan pilih yang lain",
+	},
+	BadCharacters: {
+		locale.English:    "%s may only contain letters, digits, '_', '.' and '-', and must start with a letter or digit",
+		locale.Indonesian: "%s hanya boleh berisi huruf, angka, '_', '.', dan '-', serta harus diawali huruf atau angka",
+	},
+	Reserved: {
+		locale.English:    "%s is reserved",
+		locale.Indonesian: "%s sudah dicadangkan",
+	},
+}
+
+// Field describes a problem with one field. Its message is filled in by
+// Localize.
+func Field(field, code, param string) models.FieldError {
+	return Localize(models.FieldError{Field: field, Code: code, Param: param}, locale.Default)
+}
+
+// Localize sets the message of fe in loc, falling back to English
+func Localize(fe models.FieldError, loc string) models.FieldError {
+	texts, ok := fieldMessages[fe.Code]
+	if !ok {
+		texts = fieldMessages[Invalid]
+	}
+	text, ok := texts[loc]
+	if !ok {
+		text = texts[locale.Default]
+	}
+	if strings.Count(text, "%s") > 1 {
+		fe.Message = fmt.Sprintf(text, fe.Field, fe.Param)
+	} else {
+		fe.Message = fmt.Sprintf(text, fe.Field)
+	}
+	return fe
+}
+
+// InvalidFields builds a validation_failed response listing problems in the
+// request's language. Its message repeats the first problem, so clients
+// that only show the top-level message still say something useful.
+func InvalidFields(c *gin.Context, problems ...models.FieldError) models.ErrorResponse {
+	loc := RequestLocale(c)
+	resp := models.ErrorResponse{Code: string(ValidationFailed), Error: Message(ValidationFailed, loc)}
+	for _, p := range problems {
+		resp.Details = append(resp.Details, Localize(p, loc))
+	}
+	if len(resp.Details) > 0 {
+		resp.Error = resp.Details[0].Message
+	}
+	return resp
+}
+
+// bindingProblems lists the field problems in an error from
+// ShouldBindJSON, under their JSON names. It returns nil for a body that
+// isn't JSON at all.
+func bindingProblems(err error) []models.FieldError {
+	var validationErrs validator.ValidationErrors
+	if errors.As(err, &validationErrs) {
+		problems := make([]models.FieldError, 0, len(validationErrs))
+		for _, fe := range validationErrs {
+			problems = append(problems, fieldError(fe))
+		}
+		return problems
+	}
+
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && typeErr.Field != "" {
+		return []models.FieldError{{Field: typeErr.Field, Code: InvalidType, Param: jsonType(typeErr.Type)}}
+	}
+	return nil
+}
+
+// fieldError maps a validator tag to a field error code. Length rules get
+// a code for the kind of value, since "min" means characters for text,
+// items for lists and the value itself for numbers.
+func fieldError(fe validator.FieldError) models.FieldError {
+	field := fe.Namespace()
+	// Drop the request struct's own name
+	if _, rest, found := strings.Cut(field, "."); found {
+		field = rest
+	}
+
+	problem := models.FieldError{Field: field, Code: Invalid, Param: fe.Param()}
+	kind := fe.Kind()
+	byKind := func(text, list, number string) string {
+		switch kind {
+		case reflect.String:
+			return text
+		case reflect.Slice, reflect.Array, reflect.Map:
+			return list
+		}
+		return number
+	}
+
+	switch fe.Tag() {
+	case "required", "required_without", "required_with", "required_if":
+		problem.Code, problem.Param = Required, ""
+	case "min", "gte":
+		problem.Code = byKind(TooShort, TooFew, TooSmall)
+	case "max", "lte":
+		problem.Code = byKind(TooLong, TooMany, TooLarge)
+	case "len":
+		problem.Code = byKind(WrongLength, WrongCount, NotAllowed)
+	case "email":
+		problem.Code, problem.Param = InvalidEmail, ""
+	case "oneof":
+		problem.Code = NotAllowed
+		problem.Param = strings.Join(strings.Fields(fe.Param()), ", ")
+	}
+	return problem
+}
+
+func jsonType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Slice, reflect.Array:
+		return "array"
+	case reflect.Map, reflect.Struct:
+		return "object"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "number"
+	}
+	return t.String()
+}
+
+// RegisterJSONFieldNames makes the validator report fields by their JSON
+// names, which are the ones clients know
+func RegisterJSONFieldNames() {
+	v, ok := binding.Validator.Engine().(*validator.Validate)
+	if !ok {
+		return
+	}
+	v.RegisterTagNameFunc(func(f reflect.StructField) string {
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		switch name {
+		case "-":
+			return ""
+		case "":
+			return f.Name
+		}
+		return name
+	})
+}
@@ -0,0 +1,234 @@
+// Package config loads the server's settings into a typed Config from, in
+// increasing order of precedence: built-in defaults, an optional YAML or
+// TOML file, a .env file and the environment.
+package config
+
+import (
+	"time"
+)
+
+// Config is every setting the server reads at startup. Each field has a
+// file key (`key`, nested under its section's key) and an environment
+// variable (`env`); fields tagged `secret` are redacted when printed.
+type Config struct {
+	Server        Server        `key:"server"`
+	Auth          Auth          `key:"auth"`
+	Database      Database      `key:"database"`
+	Log           Log           `key:"log"`
+	Tracing       Tracing       `key:"tracing"`
+	Metrics       Metrics       `key:"metrics"`
+	Mail          Mail          `key:"mail"`
+	PasswordReset PasswordReset `key:"password_reset"`
+	Password      Password      `key:"password"`
+	Usernames     Usernames     `key:"usernames"`
+	OIDC          OIDC          `key:"oidc"`
+	Attachments   Attachments   `key:"attachments"`
+	Daily         Daily         `key:"daily"`
+	RateLimit     RateLimit     `key:"rate_limit"`
+
+	// Files lists the config and .env files that were read
+	Files []string `key:"-"`
+
+	warnings []string
+}
+
+// Server configures the HTTP listener
+type Server struct {
+	Port int `key:"port" env:"PORT"`
+	// Mode is gin's mode: debug, release or test
+	Mode string `key:"mode" env:"GIN_MODE"`
+	// ShutdownTimeout is how long SIGTERM waits for in-flight requests
+	ShutdownTimeout time.Duration `key:"shutdown_timeout" env:"SHUTDOWN_TIMEOUT"`
+	// CORSOrigins are the browser origins allowed to call the API and
+	// open live room sockets; "*" allows any
+	CORSOrigins []string `key:"cors_origins" env:"CORS_ORIGINS"`
+	// TrustedProxies are the addresses or CIDR ranges of reverse proxies
+	// whose X-Forwarded-For is believed. Empty means the client IP is the
+	// connection's address, so clients can't pick their own.
+	TrustedProxies []string `key:"trusted_proxies" env:"TRUSTED_PROXIES"`
+}
+
+// Auth configures token signing
+type Auth struct {
+	// JWTSecret signs access tokens. When empty outside release mode a
+	// random one is made at startup, so tokens don't survive a restart.
+	JWTSecret string `key:"jwt_secret" env:"JWT_SECRET" secret:"true"`
+}
+
+// Database configures the Postgres connection and its pool
+type Database struct {
+	URL            string        `key:"url" env:"DATABASE_URL" secret:"url"`
+	QueryTimeout   time.Duration `key:"query_timeout" env:"DB_QUERY_TIMEOUT"`
+	ConnectTimeout time.Duration `key:"connect_timeout" env:"DB_CONNECT_TIMEOUT"`
+	// Zero means no limit, except for MaxIdleConns where it keeps no idle
+	// connections at all
+	MaxOpenConns    int           `key:"max_open_conns" env:"DB_MAX_OPEN_CONNS"`
+	MaxIdleConns    int           `key:"max_idle_conns" env:"DB_MAX_IDLE_CONNS"`
+	ConnMaxLifetime time.Duration `key:"conn_max_lifetime" env:"DB_CONN_MAX_LIFETIME"`
+	ConnMaxIdleTime time.Duration `key:"conn_max_idle_time" env:"DB_CONN_MAX_IDLE_TIME"`
+}
+
+// Log configures slog
+type Log struct {
+	Level  string `key:"level" env:"LOG_LEVEL"`
+	Format string `key:"format" env:"LOG_FORMAT"`
+}
+
+// Tracing picks where spans go. The exporter itself is configured by the
+// standard OTEL_EXPORTER_OTLP_* variables.
+type Tracing struct {
+	Exporter string `key:"exporter" env:"OTEL_TRACES_EXPORTER"`
+}
+
+// Metrics configures the Prometheus endpoint
+type Metrics struct {
+	// Addr serves /metrics on a port of its own when set
+	Addr  string `key:"addr" env:"METRICS_ADDR"`
+	Token string `key:"token" env:"METRICS_TOKEN" secret:"true"`
+}
+
+// Mail configures how emails are delivered
+type Mail struct {
+	// Driver is log, file or smtp
+	Driver       string `key:"driver" env:"MAIL_DRIVER"`
+	From         string `key:"from" env:"MAIL_FROM"`
+	Dir          string `key:"dir" env:"MAIL_DIR"`
+	SMTPHost     string `key:"smtp_host" env:"SMTP_HOST"`
+	SMTPPort     int    `key:"smtp_port" env:"SMTP_PORT"`
+	SMTPUsername string `key:"smtp_username" env:"SMTP_USERNAME"`
+	SMTPPassword string `key:"smtp_password" env:"SMTP_PASSWORD" secret:"true"`
+	// LogBody makes the log driver print message bodies, reset links
+	// included; for local development only
+	LogBody bool `key:"log_body" env:"MAIL_LOG_BODY"`
+}
+
+// PasswordReset configures reset emails
+type PasswordReset struct {
+	// URL is the frontend page the emailed link points to
+	URL string        `key:"url" env:"PASSWORD_RESET_URL"`
+	TTL time.Duration `key:"ttl" env:"PASSWORD_RESET_TTL"`
+}
+
+// Password configures the rules for new passwords
+type Password struct {
+	MinLength     int  `key:"min_length" env:"PASSWORD_MIN_LENGTH"`
+	RequireUpper  bool `key:"require_upper" env:"PASSWORD_REQUIRE_UPPER"`
+	RequireLower  bool `key:"require_lower" env:"PASSWORD_REQUIRE_LOWER"`
+	RequireDigit  bool `key:"require_digit" env:"PASSWORD_REQUIRE_DIGIT"`
+	RequireSymbol bool `key:"require_symbol" env:"PASSWORD_REQUIRE_SYMBOL"`
+	// BreachedFile replaces the bundled breached password list
+	BreachedFile string `key:"breached_file" env:"BREACHED_PASSWORDS_FILE"`
+}
+
+// Usernames configures the rules for new usernames
+type Usernames struct {
+	// Reserved are kept from registration on top of the built-in names
+	Reserved []string `key:"reserved" env:"RESERVED_USERNAMES"`
+}
+
+// OIDC configures login through the school's OpenID Connect provider. It
+// is off while Issuer is empty.
+type OIDC struct {
+	Issuer       string   `key:"issuer" env:"OIDC_ISSUER"`
+	ClientID     string   `key:"client_id" env:"OIDC_CLIENT_ID"`
+	ClientSecret string   `key:"client_secret" env:"OIDC_CLIENT_SECRET" secret:"true"`
+	RedirectURL  string   `key:"redirect_url" env:"OIDC_REDIRECT_URL"`
+	Scopes       []string `key:"scopes" env:"OIDC_SCOPES"`
+	FrontendURL  string   `key:"frontend_url" env:"OIDC_FRONTEND_URL"`
+}
+
+// Attachments configures question image storage
+type Attachments struct {
+	Dir      string `key:"dir" env:"ATTACHMENTS_DIR"`
+	MaxBytes int64  `key:"max_bytes" env:"ATTACHMENT_MAX_BYTES"`
+	// URLTTL is how long the signed download links in question responses
+	// keep working
+	URLTTL time.Duration `key:"url_ttl" env:"ATTACHMENT_URL_TTL"`
+}
+
+// Daily configures the daily challenge
+type Daily struct {
+	// Timezone decides when a new day, and its challenge, starts
+	Timezone string `key:"timezone" env:"DAILY_CHALLENGE_TZ"`
+}
+
+// Location returns the time zone named by Timezone
+func (d Daily) Location() (*time.Location, error) {
+	return time.LoadLocation(d.Timezone)
+}
+
+// RateLimit configures where rate limit counters live
+type RateLimit struct {
+	// Store is memory, or postgres to share limits between instances
+	Store string `key:"store" env:"RATE_LIMIT_STORE"`
+}
+
+// Default returns the settings used for anything not configured
+func Default() *Config {
+	return &Config{
+		Server: Server{
+			Port:            8080,
+			Mode:            "debug",
+			ShutdownTimeout: 30 * time.Second,
+			CORSOrigins:     []string{"*"},
+		},
+		Database: Database{
+			QueryTimeout:    10 * time.Second,
+			ConnectTimeout:  time.Minute,
+			MaxOpenConns:    25,
+			MaxIdleConns:    10,
+			ConnMaxLifetime: 30 * time.Minute,
+			ConnMaxIdleTime: 5 * time.Minute,
+		},
+		Log: Log{
+			Level:  "info",
+			Format: "json",
+		},
+		Tracing: Tracing{
+			Exporter: "none",
+		},
+		Mail: Mail{
+			Driver:   "log",
+			From:     "no-reply@quiz-butterfly.local",
+			Dir:      "mail",
+			SMTPPort: 25,
+		},
+		PasswordReset: PasswordReset{
+			URL: "http://localhost:5173/reset-password",
+			TTL: time.Hour,
+		},
+		Password: Password{
+			MinLength: 8,
+		},
+		OIDC: OIDC{
+			Scopes: []string{"profile", "email"},
+		},
+		Attachments: Attachments{
+			Dir:      "uploads",
+			MaxBytes: 5 << 20,
+			URLTTL:   time.Hour,
+		},
+		Daily: Daily{
+			Timezone: "UTC",
+		},
+		RateLimit: RateLimit{
+			Store: "memory",
+		},
+	}
+}
+
+// Warnings lists settings that are allowed but probably unintended, for
+// the caller to log once logging is set up
+func (c *Config) Warnings() []string {
+	return c.warnings
+}
+
+// AllowsOrigin reports whether browsers on origin may call the API
+func (s Server) AllowsOrigin(origin string) bool {
+	for _, allowed := range s.CORSOrigins {
+		if allowed == "*" || allowed == origin {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,198 @@
+package config
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// isolate runs the test in an empty directory, so no .env is found, with
+// every setting's variable unset until the test ends
+func isolate(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	t.Chdir(dir)
+	unset := func(name string) {
+		// Setenv restores the old value afterwards
+		t.Setenv(name, "")
+		os.Unsetenv(name)
+	}
+	unset("CONFIG_FILE")
+	for _, s := range Default().settings() {
+		unset(s.env)
+	}
+	return dir
+}
+
+func writeFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadLayersFileAndEnvironment(t *testing.T) {
+	dir := isolate(t)
+	path := writeFile(t, dir, "config.yaml", `
+server:
+  port: 9000
+  cors_origins: [https://quiz.example.com, https://admin.example.com]
+database:
+  url: postgres://quiz:secret@db/quiz
+  query_timeout: 5s
+daily:
+  timezone: Asia/Jakarta
+`)
+	// The environment wins over the file
+	t.Setenv("PORT", "9100")
+	t.Setenv("RESERVED_USERNAMES", "teacher, principal")
+
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Server.Port != 9100 {
+		t.Errorf("port = %d, want 9100 from PORT", cfg.Server.Port)
+	}
+	if got := strings.Join(cfg.Server.CORSOrigins, " "); got != "https://quiz.example.com https://admin.example.com" {
+		t.Errorf("cors origins = %q", got)
+	}
+	if cfg.Database.QueryTimeout != 5*time.Second {
+		t.Errorf("query timeout = %v, want 5s", cfg.Database.QueryTimeout)
+	}
+	if got := strings.Join(cfg.Usernames.Reserved, " "); got != "teacher principal" {
+		t.Errorf("reserved = %q", got)
+	}
+	if cfg.Database.ConnectTimeout != time.Minute {
+		t.Errorf("connect timeout = %v, want the default", cfg.Database.ConnectTimeout)
+	}
+	if len(cfg.Files) != 1 || cfg.Files[0] != path {
+		t.Errorf("files = %v, want %s", cfg.Files, path)
+	}
+}
+
+func TestLoadTOMLNamedByDotEnv(t *testing.T) {
+	dir := isolate(t)
+	writeFile(t, dir, ".env", "CONFIG_FILE=quiz.toml\nPASSWORD_MIN_LENGTH=14\n")
+	writeFile(t, dir, "quiz.toml", `
+[database]
+url = "postgres://db/quiz"
+
+[password]
+min_length = 12
+require_digit = true
+`)
+	// The real environment wins over .env
+	t.Setenv("PASSWORD_MIN_LENGTH", "10")
+
+	cfg, err := Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Password.MinLength != 10 || !cfg.Password.RequireDigit {
+		t.Errorf("password = %+v, want min length 10 and a digit", cfg.Password)
+	}
+	if len(cfg.Files) != 2 || cfg.Files[0] != ".env" || cfg.Files[1] != "quiz.toml" {
+		t.Errorf("files = %v", cfg.Files)
+	}
+}
+
+func TestLoadReportsEveryProblem(t *testing.T) {
+	dir := isolate(t)
+	path := writeFile(t, dir, "config.yaml", `
+server:
+  port: 70000
+  prot: 8080
+databse:
+  url: postgres://db/quiz
+`)
+	t.Setenv("DB_QUERY_TIMEOUT", "soon")
+	t.Setenv("MAIL_DRIVER", "pigeon")
+	t.Setenv("DAILY_CHALLENGE_TZ", "Local")
+	t.Setenv("TRUSTED_PROXIES", "10.0.0.0/8,proxy.internal")
+
+	_, err := Load(path)
+	if err == nil {
+		t.Fatal("Load accepted an invalid configuration")
+	}
+	for _, want := range []string{
+		`unknown setting server.prot`,
+		`unknown section "databse"`,
+		`DB_QUERY_TIMEOUT: invalid duration "soon"`,
+		`server.port (PORT): 70000 is not a port`,
+		`database.url (DATABASE_URL): is required`,
+		`mail.driver (MAIL_DRIVER): "pigeon" is not one of`,
+		`daily.timezone (DAILY_CHALLENGE_TZ): name the zone`,
+		`server.trusted_proxies (TRUSTED_PROXIES): "proxy.internal" is not an IP address or CIDR range`,
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error does not mention %q:\n%v", want, err)
+		}
+	}
+}
+
+func TestValidateRelease(t *testing.T) {
+	cfg := Default()
+	cfg.Server.Mode = "release"
+	cfg.Database.URL = "postgres://db/quiz"
+	err := cfg.Validate()
+	if err == nil || !strings.Contains(err.Error(), "auth.jwt_secret (JWT_SECRET): is required in release mode") {
+		t.Errorf("Validate = %v, want a missing JWT secret", err)
+	}
+
+	cfg.Auth.JWTSecret = strings.Repeat("s", 32)
+	if err := cfg.Validate(); err != nil {
+		t.Errorf("Validate = %v", err)
+	}
+	if len(cfg.Warnings()) != 1 || !strings.Contains(cfg.Warnings()[0], "CORS_ORIGINS") {
+		t.Errorf("warnings = %v, want one about CORS_ORIGINS", cfg.Warnings())
+	}
+}
+
+func TestLoadGeneratesJWTSecretOutsideRelease(t *testing.T) {
+	isolate(t)
+	t.Setenv("DATABASE_URL", "postgres://db/quiz")
+
+	cfg, err := Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Auth.JWTSecret) < 32 {
+		t.Errorf("generated secret %q is too short", cfg.Auth.JWTSecret)
+	}
+	if len(cfg.Warnings()) == 0 || !strings.Contains(cfg.Warnings()[0], "JWT_SECRET") {
+		t.Errorf("warnings = %v, want one about JWT_SECRET", cfg.Warnings())
+	}
+}
+
+func TestPrintingRedactsSecrets(t *testing.T) {
+	cfg := Default()
+	cfg.Auth.JWTSecret = "jwt-signing-secret"
+	cfg.Database.URL = "postgres://quiz:db-password@db/quiz"
+	cfg.Mail.SMTPPassword = "smtp-password"
+	cfg.OIDC.ClientSecret = "oidc-secret"
+	cfg.Metrics.Token = "metrics-token"
+
+	out, err := cfg.YAML()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var logged strings.Builder
+	slog.New(slog.NewTextHandler(&logged, nil)).Info("config", "config", cfg)
+
+	for name, text := range map[string]string{"YAML": string(out), "log": logged.String()} {
+		for _, secret := range []string{"jwt-signing-secret", "db-password", "smtp-password", "oidc-secret", "metrics-token"} {
+			if strings.Contains(text, secret) {
+				t.Errorf("%s output contains %q", name, secret)
+			}
+		}
+		if !strings.Contains(text, "postgres://quiz:xxxxx@db/quiz") {
+			t.Errorf("%s output lost the rest of the database URL:\n%s", name, text)
+		}
+	}
+}
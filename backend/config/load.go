@@ -0,0 +1,253 @@
+package config
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/goccy/go-yaml"
+	"github.com/joho/godotenv"
+	"github.com/pelletier/go-toml/v2"
+)
+
+// Load builds the configuration. .env is read first so it can name the
+// config file; path, or CONFIG_FILE when path is empty, is then read if
+// set, and the environment overrides both. The result is validated, and
+// every problem found, in the file, the environment or the values, is
+// reported in the returned error.
+func Load(path string) (*Config, error) {
+	cfg := Default()
+
+	// godotenv leaves variables that are already set alone, so the real
+	// environment wins over .env
+	if err := godotenv.Load(); err == nil {
+		cfg.Files = append(cfg.Files, ".env")
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf(".env: %w", err)
+	}
+
+	if path == "" {
+		path = os.Getenv("CONFIG_FILE")
+	}
+	var fileErr error
+	if path != "" {
+		fileErr = cfg.loadFile(path)
+		cfg.Files = append(cfg.Files, path)
+	}
+
+	// Settings that didn't parse keep their default, so validation can
+	// still report everything else in the same run
+	if err := errors.Join(fileErr, cfg.loadEnv(), cfg.Validate()); err != nil {
+		return nil, fmt.Errorf("invalid configuration:\n%w", err)
+	}
+
+	if cfg.Auth.JWTSecret == "" {
+		secret := make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, fmt.Errorf("generate JWT secret: %w", err)
+		}
+		cfg.Auth.JWTSecret = hex.EncodeToString(secret)
+		cfg.warnings = append(cfg.warnings, "JWT_SECRET is not set; using a random secret, so tokens stop working on restart")
+	}
+	return cfg, nil
+}
+
+// setting is one field of Config, with where it can be set from
+type setting struct {
+	section string
+	key     string
+	env     string
+	secret  string
+	value   reflect.Value
+}
+
+// name is the setting's dotted file key, e.g. server.port
+func (s setting) name() string {
+	return s.section + "." + s.key
+}
+
+// settings lists every field of c in declaration order. The values are
+// addressable, so setting them changes c.
+func (c *Config) settings() []setting {
+	var all []setting
+	root := reflect.ValueOf(c).Elem()
+	for i := 0; i < root.NumField(); i++ {
+		sectionField := root.Type().Field(i)
+		section := sectionField.Tag.Get("key")
+		if section == "" || section == "-" {
+			continue
+		}
+		sectionValue := root.Field(i)
+		for j := 0; j < sectionValue.NumField(); j++ {
+			f := sectionValue.Type().Field(j)
+			all = append(all, setting{
+				section: section,
+				key:     f.Tag.Get("key"),
+				env:     f.Tag.Get("env"),
+				secret:  f.Tag.Get("secret"),
+				value:   sectionValue.Field(j),
+			})
+		}
+	}
+	return all
+}
+
+// loadEnv sets every field whose variable is set and not empty
+func (c *Config) loadEnv() error {
+	var problems []error
+	for _, s := range c.settings() {
+		raw := os.Getenv(s.env)
+		if raw == "" {
+			continue
+		}
+		if err := parseInto(s.value, raw); err != nil {
+			problems = append(problems, fmt.Errorf("%s: %w", s.env, err))
+		}
+	}
+	return errors.Join(problems...)
+}
+
+// loadFile reads a YAML (.yaml, .yml) or TOML (.toml) file whose tables
+// are the sections of Config. Unknown sections and keys are errors, so a
+// typo doesn't silently leave a default in place.
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	problem := func(format string, args ...any) error {
+		return fmt.Errorf("%s: %s", path, fmt.Sprintf(format, args...))
+	}
+
+	var doc map[string]any
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &doc)
+	case ".toml":
+		err = toml.Unmarshal(data, &doc)
+	default:
+		return problem("unknown config format %q, want .yaml, .yml or .toml", filepath.Ext(path))
+	}
+	if err != nil {
+		return problem("%v", err)
+	}
+
+	known := make(map[string]setting)
+	sections := make(map[string]bool)
+	for _, s := range c.settings() {
+		known[s.name()] = s
+		sections[s.section] = true
+	}
+
+	var problems []error
+	for _, section := range sortedKeys(doc) {
+		if !sections[section] {
+			problems = append(problems, problem("unknown section %q", section))
+			continue
+		}
+		table, ok := doc[section].(map[string]any)
+		if !ok {
+			problems = append(problems, problem("%s: want a table of settings", section))
+			continue
+		}
+		for _, key := range sortedKeys(table) {
+			s, ok := known[section+"."+key]
+			if !ok {
+				problems = append(problems, problem("unknown setting %s.%s", section, key))
+				continue
+			}
+			raw, err := fileValue(table[key])
+			if err == nil {
+				err = parseInto(s.value, raw)
+			}
+			if err != nil {
+				problems = append(problems, problem("%s: %v", s.name(), err))
+			}
+		}
+	}
+	return errors.Join(problems...)
+}
+
+// fileValue turns a decoded YAML or TOML value into the text form the
+// environment would give, so both go through parseInto
+func fileValue(v any) (string, error) {
+	switch v := v.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case bool, int, int64, uint64, float64:
+		return fmt.Sprint(v), nil
+	case []any:
+		items := make([]string, len(v))
+		for i, item := range v {
+			s, err := fileValue(item)
+			if err != nil {
+				return "", err
+			}
+			items[i] = s
+		}
+		return strings.Join(items, ","), nil
+	}
+	return "", fmt.Errorf("unsupported value %v", v)
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// parseInto sets v from its text form. Lists are separated by commas or
+// whitespace.
+func parseInto(v reflect.Value, raw string) error {
+	raw = strings.TrimSpace(raw)
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return fmt.Errorf("invalid duration %q, want e.g. 30s or 5m", raw)
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(raw)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, v.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("invalid number %q", raw)
+		}
+		v.SetInt(n)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q, want true or false", raw)
+		}
+		v.SetBool(b)
+	case reflect.Slice:
+		items := strings.FieldsFunc(raw, func(r rune) bool {
+			return r == ',' || unicode.IsSpace(r)
+		})
+		v.Set(reflect.ValueOf(items))
+	default:
+		return fmt.Errorf("unsupported setting type %s", v.Type())
+	}
+	return nil
+}
+
+func sortedKeys(m map[string]any) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
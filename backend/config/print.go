@@ -0,0 +1,76 @@
+package config
+
+import (
+	"log/slog"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/goccy/go-yaml"
+)
+
+const redacted = "[REDACTED]"
+
+// display returns the setting's value as shown to people: durations in
+// their text form, and secrets replaced. A database URL keeps everything
+// but its password.
+func (s setting) display() any {
+	switch v := s.value.Interface().(type) {
+	case string:
+		if v == "" || s.secret == "" {
+			return v
+		}
+		if s.secret == "url" {
+			if u, err := url.Parse(v); err == nil {
+				return u.Redacted()
+			}
+		}
+		return redacted
+	case time.Duration:
+		return v.String()
+	case []string:
+		return strings.Join(v, ",")
+	default:
+		return v
+	}
+}
+
+// LogValue logs the effective configuration, grouped by section, with
+// secrets redacted
+func (c *Config) LogValue() slog.Value {
+	var sections []slog.Attr
+	var current string
+	var attrs []slog.Attr
+	flush := func() {
+		if current != "" {
+			sections = append(sections, slog.Attr{Key: current, Value: slog.GroupValue(attrs...)})
+		}
+	}
+	for _, s := range c.settings() {
+		if s.section != current {
+			flush()
+			current, attrs = s.section, nil
+		}
+		attrs = append(attrs, slog.Any(s.key, s.display()))
+	}
+	flush()
+	return slog.GroupValue(sections...)
+}
+
+// YAML renders the effective configuration as a config file, with secrets
+// redacted
+func (c *Config) YAML() ([]byte, error) {
+	var doc yaml.MapSlice
+	for _, s := range c.settings() {
+		if len(doc) == 0 || doc[len(doc)-1].Key != s.section {
+			doc = append(doc, yaml.MapItem{Key: s.section, Value: yaml.MapSlice{}})
+		}
+		value := s.display()
+		if list, ok := s.value.Interface().([]string); ok {
+			value = list
+		}
+		section := &doc[len(doc)-1]
+		section.Value = append(section.Value.(yaml.MapSlice), yaml.MapItem{Key: s.key, Value: value})
+	}
+	return yaml.Marshal(doc)
+}
@@ -0,0 +1,108 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// Validate checks every setting and returns all problems at once, each
+// naming the file key and environment variable to fix. Warnings from an
+// earlier call are replaced.
+func (c *Config) Validate() error {
+	c.warnings = nil
+	env := make(map[string]string)
+	for _, s := range c.settings() {
+		env[s.name()] = s.env
+	}
+	var problems []error
+	check := func(ok bool, name, format string, args ...any) {
+		if !ok {
+			problems = append(problems, fmt.Errorf("%s (%s): %s", name, env[name], fmt.Sprintf(format, args...)))
+		}
+	}
+	oneOf := func(name, value string, allowed ...string) {
+		check(slices.Contains(allowed, strings.ToLower(value)), name, "%q is not one of %s", value, strings.Join(allowed, ", "))
+	}
+	nonNegative := func(name string, n int64) {
+		check(n >= 0, name, "must not be negative")
+	}
+	absoluteURL := func(name, value string) {
+		u, err := url.Parse(value)
+		check(err == nil && u.Scheme != "" && u.Host != "", name, "%q is not an absolute URL", value)
+	}
+
+	release := strings.EqualFold(c.Server.Mode, "release")
+
+	check(c.Server.Port > 0 && c.Server.Port <= 65535, "server.port", "%d is not a port between 1 and 65535", c.Server.Port)
+	oneOf("server.mode", c.Server.Mode, "debug", "release", "test")
+	check(c.Server.ShutdownTimeout > 0, "server.shutdown_timeout", "must be positive")
+	check(len(c.Server.CORSOrigins) > 0, "server.cors_origins", "at least one origin, or *, is required")
+	for _, origin := range c.Server.CORSOrigins {
+		if origin != "*" {
+			u, err := url.Parse(origin)
+			check(err == nil && u.Scheme != "" && u.Host != "" && u.Path == "", "server.cors_origins",
+				"%q is not an origin like https://quiz.example.com", origin)
+		}
+	}
+	for _, proxy := range c.Server.TrustedProxies {
+		_, _, cidrErr := net.ParseCIDR(proxy)
+		check(cidrErr == nil || net.ParseIP(proxy) != nil, "server.trusted_proxies", "%q is not an IP address or CIDR range", proxy)
+	}
+	if release && slices.Contains(c.Server.CORSOrigins, "*") {
+		c.warnings = append(c.warnings, "CORS_ORIGINS allows any origin in release mode")
+	}
+
+	check(!release || c.Auth.JWTSecret != "", "auth.jwt_secret", "is required in release mode")
+	check(c.Auth.JWTSecret == "" || len(c.Auth.JWTSecret) >= 32, "auth.jwt_secret", "must be at least 32 characters")
+
+	check(c.Database.URL != "", "database.url", "is required")
+	if c.Database.URL != "" {
+		u, err := url.Parse(c.Database.URL)
+		check(err == nil && (u.Scheme == "postgres" || u.Scheme == "postgresql"), "database.url", "want a postgres:// URL")
+	}
+	nonNegative("database.query_timeout", int64(c.Database.QueryTimeout))
+	check(c.Database.ConnectTimeout > 0, "database.connect_timeout", "must be positive")
+	nonNegative("database.max_open_conns", int64(c.Database.MaxOpenConns))
+	nonNegative("database.max_idle_conns", int64(c.Database.MaxIdleConns))
+	nonNegative("database.conn_max_lifetime", int64(c.Database.ConnMaxLifetime))
+	nonNegative("database.conn_max_idle_time", int64(c.Database.ConnMaxIdleTime))
+
+	oneOf("log.level", c.Log.Level, "debug", "info", "warn", "warning", "error")
+	oneOf("log.format", c.Log.Format, "json", "text")
+	oneOf("tracing.exporter", c.Tracing.Exporter, "none", "otlp", "stdout")
+
+	oneOf("mail.driver", c.Mail.Driver, "log", "file", "smtp")
+	if strings.EqualFold(c.Mail.Driver, "smtp") {
+		check(c.Mail.SMTPHost != "", "mail.smtp_host", "is required for the smtp driver")
+		check(c.Mail.SMTPPort > 0 && c.Mail.SMTPPort <= 65535, "mail.smtp_port", "%d is not a port between 1 and 65535", c.Mail.SMTPPort)
+	}
+	check(c.Mail.From != "", "mail.from", "is required")
+
+	absoluteURL("password_reset.url", c.PasswordReset.URL)
+	check(c.PasswordReset.TTL > 0, "password_reset.ttl", "must be positive")
+
+	check(c.Password.MinLength > 0, "password.min_length", "must be positive")
+
+	if c.OIDC.Issuer != "" {
+		absoluteURL("oidc.issuer", c.OIDC.Issuer)
+		check(c.OIDC.ClientID != "", "oidc.client_id", "is required when oidc.issuer is set")
+		check(c.OIDC.RedirectURL != "", "oidc.redirect_url", "is required when oidc.issuer is set")
+	}
+
+	check(c.Attachments.Dir != "", "attachments.dir", "is required")
+	check(c.Attachments.MaxBytes > 0, "attachments.max_bytes", "must be positive")
+	check(c.Attachments.URLTTL > 0, "attachments.url_ttl", "must be positive")
+
+	_, err := c.Daily.Location()
+	check(err == nil, "daily.timezone", "unknown time zone %q", c.Daily.Timezone)
+	// Postgres counts streak days in the zone too, and has no "Local"
+	check(c.Daily.Timezone != "Local", "daily.timezone", "name the zone, e.g. Asia/Jakarta, instead of Local")
+
+	oneOf("rate_limit.store", c.RateLimit.Store, "memory", "postgres")
+
+	return errors.Join(problems...)
+}
@@ -1,41 +1,73 @@
 package database
 
 import (
+	"context"
 	"database/sql"
-	"fmt"
-	"log"
-	"os"
+	"log/slog"
+	"time"
 
-	"github.com/joho/godotenv"
-	_ "github.com/lib/pq"
+	"github.com/XSAM/otelsql"
+	"github.com/lib/pq"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+
+	"quiz-butterfly/backend/config"
+	"quiz-butterfly/backend/logging"
 )
 
 var db *sql.DB
 
 // InitDB initializes the database connection
-func InitDB() {
-	// Load .env file
-	if err := godotenv.Load(); err != nil {
-		log.Println("No .env file found, using default or environment variables")
+func InitDB(cfg config.Database) {
+	connector, err := pq.NewConnector(cfg.URL)
+	if err != nil {
+		logging.Fatal("Invalid DATABASE_URL", "error", err)
 	}
 
-	var err error
-	dbURL := os.Getenv("DATABASE_URL")
-	if dbURL == "" {
-		log.Fatal("DATABASE_URL is not set. Please set it in .env or environment")
-	}
+	// Queries are cancelled on Postgres when they run past the query
+	// timeout (zero turns the limit off) or the request ends, and every
+	// query gets a span under the request that made it
+	db = otelsql.OpenDB(&timeoutConnector{Connector: connector, timeout: cfg.QueryTimeout},
+		otelsql.WithAttributes(semconv.DBSystemNamePostgreSQL),
+		otelsql.WithSpanOptions(otelsql.SpanOptions{
+			DisableErrSkip:       true,
+			OmitConnResetSession: true,
+			OmitRows:             true,
+		}))
 
-	db, err = sql.Open("postgres", dbURL)
-	if err != nil {
-		log.Fatal("Failed to connect to database:", err)
-	}
+	db.SetMaxOpenConns(cfg.MaxOpenConns)
+	db.SetMaxIdleConns(cfg.MaxIdleConns)
+	db.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
 
-	// Test the connection
-	if err = db.Ping(); err != nil {
-		log.Fatal("Failed to ping database:", err)
+	// Postgres may still be starting, as when both come up together in
+	// compose, so keep trying for the connect timeout before giving up
+	if err = connect(cfg.ConnectTimeout); err != nil {
+		logging.Fatal("Failed to ping database", "error", err, "waited", cfg.ConnectTimeout)
 	}
 
-	fmt.Println("Database connected successfully")
+	slog.Info("Database connected successfully")
+}
+
+// connect pings the database until it answers, backing off from half a
+// second up to 30 seconds between attempts, and returns the last error
+// once timeout has passed
+func connect(timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	backoff := 500 * time.Millisecond
+	for attempt := 1; ; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		err := db.PingContext(ctx)
+		cancel()
+		if err == nil {
+			return nil
+		}
+		if time.Now().Add(backoff).After(deadline) {
+			return err
+		}
+		slog.Warn("Database not reachable, retrying", "attempt", attempt, "retry_in", backoff, "error", err)
+		time.Sleep(backoff)
+		backoff = min(backoff*2, 30*time.Second)
+	}
 }
 
 // GetDB returns the database instance
@@ -47,6 +79,6 @@ func GetDB() *sql.DB {
 func CloseDB() {
 	if db != nil {
 		db.Close()
-		fmt.Println("Database connection closed")
+		slog.Info("Database connection closed")
 	}
 }
@@ -0,0 +1,157 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// requiredTables lists every table in schema.sql. The server has no
+// migration runner; a table missing here means schema.sql, or
+// schema_upgrade.sql on an older database, has not been applied yet.
+var requiredTables = []string{
+	"users",
+	"high_scores",
+	"questions",
+	"question_attachments",
+	"question_translations",
+	"quiz_sessions",
+	"best_scores_by_day",
+	"daily_challenges",
+	"user_answers",
+	"password_reset_tokens",
+	"user_achievements",
+	"classes",
+	"class_members",
+	"class_assignments",
+	"user_identities",
+	"rate_limit_counters",
+	"auth_lockouts",
+}
+
+// requiredColumns lists the columns schema.sql added to, or changed in,
+// tables that already existed before, with the type information_schema
+// reports for them. A database set up from an older schema.sql has every
+// table but not these until schema_upgrade.sql runs, so it is caught here
+// rather than on the first query that needs them.
+var requiredColumns = []struct {
+	table, column, dataType string
+}{
+	{"users", "email", "character varying"},
+	{"users", "is_guest", "boolean"},
+	{"users", "role", "character varying"},
+	{"users", "locale", "character varying"},
+	{"users", "email_verified_at", "timestamp with time zone"},
+	{"high_scores", "score", "numeric"},
+	{"questions", "question_type", "character varying"},
+	{"questions", "correct_answer_indices", "ARRAY"},
+	{"questions", "numeric_answer", "double precision"},
+	{"questions", "numeric_tolerance", "double precision"},
+	{"questions", "accepted_answers", "ARRAY"},
+	{"questions", "explanation", "text"},
+	{"questions", "locale", "character varying"},
+	{"questions", "search_vector", "tsvector"},
+	{"questions", "normalized_text", "text"},
+	{"quiz_sessions", "mode", "character varying"},
+	{"quiz_sessions", "challenge_date", "date"},
+	{"quiz_sessions", "question_ids", "ARRAY"},
+	{"quiz_sessions", "score", "numeric"},
+	{"user_answers", "credit", "numeric"},
+}
+
+// requiredStatuses are the quiz_sessions.status values its check
+// constraint must allow
+var requiredStatuses = []string{"playing", "finished", "abandoned"}
+
+// Ping checks that the database answers
+func Ping(ctx context.Context) error {
+	return db.PingContext(ctx)
+}
+
+// MissingTables returns the tables from schema.sql the database doesn't
+// have, in schema order
+func MissingTables(ctx context.Context) ([]string, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT name FROM unnest($1::text[]) WITH ORDINALITY AS t(name, pos)
+		WHERE to_regclass(name) IS NULL
+		ORDER BY pos`, pq.Array(requiredTables))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var missing []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		missing = append(missing, name)
+	}
+	return missing, rows.Err()
+}
+
+// OutdatedColumns describes each column of requiredColumns that is missing
+// or has another type, and a quiz_sessions.status check that rejects a
+// status the server writes. Tables that don't exist at all are left to
+// MissingTables.
+func OutdatedColumns(ctx context.Context) ([]string, error) {
+	tables := make([]string, len(requiredColumns))
+	columns := make([]string, len(requiredColumns))
+	types := make([]string, len(requiredColumns))
+	for i, c := range requiredColumns {
+		tables[i], columns[i], types[i] = c.table, c.column, c.dataType
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT r.tbl, r.col, r.want, c.data_type
+		FROM unnest($1::text[], $2::text[], $3::text[]) WITH ORDINALITY AS r(tbl, col, want, pos)
+		LEFT JOIN information_schema.columns c
+			ON c.table_schema = current_schema() AND c.table_name = r.tbl AND c.column_name = r.col
+		WHERE to_regclass(r.tbl) IS NOT NULL AND c.data_type IS DISTINCT FROM r.want
+		ORDER BY r.pos`, pq.Array(tables), pq.Array(columns), pq.Array(types))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var outdated []string
+	for rows.Next() {
+		var table, column, want string
+		var got sql.NullString
+		if err := rows.Scan(&table, &column, &want, &got); err != nil {
+			return nil, err
+		}
+		if got.Valid {
+			outdated = append(outdated, fmt.Sprintf("%s.%s is %s, want %s", table, column, got.String, want))
+		} else {
+			outdated = append(outdated, fmt.Sprintf("%s.%s is missing", table, column))
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// information_schema doesn't show check constraints by column, so look
+	// for each status, quoted, in the definitions of the table's checks
+	var rejected []string
+	err = db.QueryRowContext(ctx, `
+		SELECT COALESCE(array_agg(s ORDER BY pos), '{}')
+		FROM unnest($1::text[]) WITH ORDINALITY AS t(s, pos)
+		WHERE to_regclass('quiz_sessions') IS NOT NULL AND NOT EXISTS (
+			SELECT 1 FROM pg_constraint
+			WHERE conrelid = to_regclass('quiz_sessions') AND contype = 'c'
+				AND pg_get_constraintdef(oid) LIKE '%status%'
+				AND strpos(pg_get_constraintdef(oid), quote_literal(s)) > 0)`,
+		pq.Array(requiredStatuses)).Scan(pq.Array(&rejected))
+	if err != nil {
+		return nil, err
+	}
+	if len(rejected) > 0 {
+		outdated = append(outdated, "quiz_sessions.status doesn't allow "+strings.Join(rejected, ", "))
+	}
+	return outdated, nil
+}
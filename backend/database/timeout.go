@@ -0,0 +1,148 @@
+package database
+
+import (
+	"context"
+	"database/sql/driver"
+	"io"
+	"time"
+)
+
+// timeoutConnector gives every query its own deadline on
+// top of the caller's context. lib/pq sends Postgres a cancel request when
+// the context ends, so a slow query stops on the server as well instead of
+// holding the connection.
+type timeoutConnector struct {
+	driver.Connector
+	timeout time.Duration
+}
+
+func (c *timeoutConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.Connector.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &timeoutConn{Conn: conn, timeout: c.timeout}, nil
+}
+
+// timeoutConn forwards to the driver's connection, bounding QueryContext
+// and ExecContext. Transactions are left to the caller's context; the
+// queries inside them are bounded one by one.
+type timeoutConn struct {
+	driver.Conn
+	timeout time.Duration
+}
+
+// withTimeout derives the context for a single query
+func (c *timeoutConn) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if c.timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, c.timeout)
+}
+
+func (c *timeoutConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	queryer, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	ctx, cancel := c.withTimeout(ctx)
+	rows, err := queryer.QueryContext(ctx, query, args)
+	if err != nil {
+		cancel()
+		return nil, contextError(ctx, err)
+	}
+	return &timeoutRows{Rows: rows, ctx: ctx, cancel: cancel}, nil
+}
+
+func (c *timeoutConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	execer, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	ctx, cancel := c.withTimeout(ctx)
+	defer cancel()
+	result, err := execer.ExecContext(ctx, query, args)
+	return result, contextError(ctx, err)
+}
+
+func (c *timeoutConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if preparer, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		return preparer.PrepareContext(ctx, query)
+	}
+	return c.Conn.Prepare(query)
+}
+
+func (c *timeoutConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if beginner, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return beginner.BeginTx(ctx, opts)
+	}
+	return c.Conn.Begin()
+}
+
+func (c *timeoutConn) Ping(ctx context.Context) error {
+	if pinger, ok := c.Conn.(driver.Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *timeoutConn) ResetSession(ctx context.Context) error {
+	if resetter, ok := c.Conn.(driver.SessionResetter); ok {
+		return resetter.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *timeoutConn) IsValid() bool {
+	if validator, ok := c.Conn.(driver.Validator); ok {
+		return validator.IsValid()
+	}
+	return true
+}
+
+// timeoutRows keeps the query's deadline running until the rows are
+// closed, so reading a slow result is bounded too
+type timeoutRows struct {
+	driver.Rows
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func (r *timeoutRows) Next(dest []driver.Value) error {
+	err := r.Rows.Next(dest)
+	if err == io.EOF {
+		return err
+	}
+	return contextError(r.ctx, err)
+}
+
+// Close closes the rows before cancelling, otherwise lib/pq would treat
+// the finished query as cancelled and drop the connection
+func (r *timeoutRows) Close() error {
+	err := r.Rows.Close()
+	r.cancel()
+	return err
+}
+
+// contextError ties a driver error to the context that caused it, so
+// callers can tell a timeout or a gone client from a failed query with
+// errors.Is(err, context.DeadlineExceeded) or context.Canceled
+func contextError(ctx context.Context, err error) error {
+	if err == nil || ctx.Err() == nil {
+		return err
+	}
+	return &canceledError{err: err, ctxErr: context.Cause(ctx)}
+}
+
+type canceledError struct {
+	err    error
+	ctxErr error
+}
+
+func (e *canceledError) Error() string {
+	return e.err.Error()
+}
+
+func (e *canceledError) Unwrap() []error {
+	return []error{e.err, e.ctxErr}
+}
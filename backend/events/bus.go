@@ -0,0 +1,116 @@
+package events
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Event types published by the handlers
+const (
+	HighScoreUpdated   = "high_score_updated"
+	SessionFinished    = "session_finished"
+	QuestionCreated    = "question_created"
+	QuestionUpdated    = "question_updated"
+	QuestionDeleted    = "question_deleted"
+	AchievementAwarded = "achievement_awarded"
+)
+
+// subscriberBuffer is how many events a subscriber may fall behind before
+// it is dropped
+const subscriberBuffer = 32
+
+// Event is a change notification
+type Event struct {
+	ID   uint64 `json:"id"`
+	Type string `json:"type"`
+	// UserID limits delivery to one user; zero means everyone
+	UserID int         `json:"-"`
+	Data   interface{} `json:"data"`
+	Time   time.Time   `json:"time"`
+}
+
+// Subscription receives events for one user. C is closed when the bus
+// closes or the subscriber can't keep up, so the client should reconnect
+// and refetch.
+type Subscription struct {
+	C      <-chan Event
+	ch     chan Event
+	userID int
+}
+
+// Bus fans events out to subscribers in process
+type Bus struct {
+	mu     sync.Mutex
+	subs   map[*Subscription]struct{}
+	nextID atomic.Uint64
+	closed bool
+}
+
+// NewBus creates an empty Bus
+func NewBus() *Bus {
+	return &Bus{subs: make(map[*Subscription]struct{})}
+}
+
+// Publish delivers ev to every matching subscriber without blocking
+func (b *Bus) Publish(ev Event) {
+	ev.ID = b.nextID.Add(1)
+	if ev.Time.IsZero() {
+		ev.Time = time.Now()
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for sub := range b.subs {
+		if ev.UserID != 0 && ev.UserID != sub.userID {
+			continue
+		}
+		select {
+		case sub.ch <- ev:
+		default:
+			delete(b.subs, sub)
+			close(sub.ch)
+		}
+	}
+}
+
+// Subscribe registers a subscriber for userID. It receives broadcast events
+// and events addressed to that user.
+func (b *Bus) Subscribe(userID int) *Subscription {
+	ch := make(chan Event, subscriberBuffer)
+	sub := &Subscription{C: ch, ch: ch, userID: userID}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		close(ch)
+		return sub
+	}
+	b.subs[sub] = struct{}{}
+	return sub
+}
+
+// Unsubscribe removes sub and closes its channel
+func (b *Bus) Unsubscribe(sub *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.subs[sub]; ok {
+		delete(b.subs, sub)
+		close(sub.ch)
+	}
+}
+
+// Close disconnects every subscriber; later subscriptions are closed at once
+func (b *Bus) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	for sub := range b.subs {
+		delete(b.subs, sub)
+		close(sub.ch)
+	}
+}
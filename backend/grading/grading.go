@@ -0,0 +1,335 @@
+package grading
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"quiz-butterfly/backend/models"
+)
+
+// Question types
+const (
+	SingleChoice   = "single_choice"
+	MultipleSelect = "multiple_select"
+	TrueFalse      = "true_false"
+	Numeric        = "numeric"
+	ShortText      = "short_text"
+)
+
+// Rules a question can break. They are named like the field error codes
+// clients receive, so handlers can pass them on unchanged.
+const (
+	Required   = "required"
+	OutOfRange = "out_of_range"
+	WrongCount = "wrong_count"
+	TooFew     = "too_few"
+	NotUnique  = "not_unique"
+	TooSmall   = "too_small"
+	Blank      = "blank"
+	NotAllowed = "not_allowed"
+)
+
+// Problem is one rule a question field broke. Param is the rule's limit,
+// if any.
+type Problem struct {
+	Field string
+	Rule  string
+	Param string
+}
+
+// trueFalseOptions are used when a true/false question omits its options
+var trueFalseOptions = []string{"True", "False"}
+
+// Answer is what a player submits. Multiple-select questions read Choices;
+// every other type reads Text.
+type Answer struct {
+	Text    string
+	Choices []string
+}
+
+// String joins the answer for storage and display
+func (a Answer) String() string {
+	if len(a.Choices) > 0 {
+		return strings.Join(a.Choices, ", ")
+	}
+	return a.Text
+}
+
+// Result is the outcome of grading one answer
+type Result struct {
+	// Credit is the share of the question's point earned, from 0 to 1
+	Credit  float64
+	Correct bool
+}
+
+// Validate checks q for its type and fills in defaults (the type itself,
+// and the options of a true/false question). It returns the first
+// problem found, or nil if q is valid.
+func Validate(q *models.Question) *Problem {
+	if q.Type == "" {
+		q.Type = SingleChoice
+	}
+
+	switch q.Type {
+	case SingleChoice:
+		if len(q.Options) == 0 {
+			return problem("options", Required, "")
+		}
+		if q.CorrectAnswerIndex < 0 || q.CorrectAnswerIndex >= len(q.Options) {
+			return problem("correct_answer_index", OutOfRange, "")
+		}
+
+	case TrueFalse:
+		if len(q.Options) == 0 {
+			q.Options = append(q.Options[:0], trueFalseOptions...)
+		}
+		if len(q.Options) != 2 {
+			return problem("options", WrongCount, "2")
+		}
+		if q.CorrectAnswerIndex < 0 || q.CorrectAnswerIndex > 1 {
+			return problem("correct_answer_index", OutOfRange, "")
+		}
+
+	case MultipleSelect:
+		if len(q.Options) < 2 {
+			return problem("options", TooFew, "2")
+		}
+		if len(q.CorrectAnswerIndices) == 0 {
+			return problem("correct_answer_indices", Required, "")
+		}
+		seen := make(map[int64]bool)
+		for _, i := range q.CorrectAnswerIndices {
+			if i < 0 || int(i) >= len(q.Options) {
+				return problem("correct_answer_indices", OutOfRange, "")
+			}
+			if seen[i] {
+				return problem("correct_answer_indices", NotUnique, "")
+			}
+			seen[i] = true
+		}
+
+	case Numeric:
+		if q.NumericAnswer == nil || math.IsNaN(*q.NumericAnswer) || math.IsInf(*q.NumericAnswer, 0) {
+			return problem("numeric_answer", Required, "")
+		}
+		if q.NumericTolerance != nil && (*q.NumericTolerance < 0 || math.IsNaN(*q.NumericTolerance)) {
+			return problem("numeric_tolerance", TooSmall, "0")
+		}
+
+	case ShortText:
+		if len(q.AcceptedAnswers) == 0 {
+			return problem("accepted_answers", Required, "")
+		}
+		for _, a := range q.AcceptedAnswers {
+			if normalizeText(a) == "" {
+				return problem("accepted_answers", Blank, "")
+			}
+		}
+
+	default:
+		return problem("type", NotAllowed, strings.Join(Types(), ", "))
+	}
+
+	clearUnused(q)
+	return nil
+}
+
+// Types lists every question type
+func Types() []string {
+	return []string{SingleChoice, MultipleSelect, TrueFalse, Numeric, ShortText}
+}
+
+func problem(field, rule, param string) *Problem {
+	return &Problem{Field: field, Rule: rule, Param: param}
+}
+
+// clearUnused drops the answer fields of other types, so a question that
+// changed type doesn't keep a stale answer
+func clearUnused(q *models.Question) {
+	if q.Type != MultipleSelect {
+		q.CorrectAnswerIndices = nil
+	}
+	if q.Type != Numeric {
+		q.NumericAnswer, q.NumericTolerance = nil, nil
+	}
+	if q.Type != ShortText {
+		q.AcceptedAnswers = nil
+	}
+	switch q.Type {
+	case Numeric, ShortText:
+		q.Options = nil
+		q.CorrectAnswerIndex = 0
+	case MultipleSelect:
+		q.CorrectAnswerIndex = 0
+	}
+}
+
+// Grade scores answer against q. Answers that can't be read for the
+// question's type, such as text for a numeric question, earn no credit.
+func Grade(q models.Question, answer Answer) Result {
+	switch q.Type {
+	case "", SingleChoice:
+		return all(q.CorrectAnswerIndex < len(q.Options) && answer.Text == q.Options[q.CorrectAnswerIndex])
+
+	case TrueFalse:
+		return all(q.CorrectAnswerIndex < len(q.Options) &&
+			strings.EqualFold(strings.TrimSpace(answer.Text), q.Options[q.CorrectAnswerIndex]))
+
+	case MultipleSelect:
+		return gradeMultipleSelect(q, answer.Choices)
+
+	case Numeric:
+		value, ok := parseNumber(answer.Text)
+		if !ok || q.NumericAnswer == nil {
+			return Result{}
+		}
+		tolerance := 0.0
+		if q.NumericTolerance != nil {
+			tolerance = *q.NumericTolerance
+		}
+		// Allow for binary rounding, so 0.1 + 0.2 still matches 0.3
+		return all(math.Abs(value-*q.NumericAnswer) <= tolerance+1e-9)
+
+	case ShortText:
+		given := normalizeText(answer.Text)
+		for _, accepted := range q.AcceptedAnswers {
+			if given != "" && given == normalizeText(accepted) {
+				return all(true)
+			}
+		}
+		return Result{}
+	}
+	return Result{}
+}
+
+// CorrectAnswer describes the expected answer for feedback
+func CorrectAnswer(q models.Question) string {
+	switch q.Type {
+	case MultipleSelect:
+		correct := make([]string, 0, len(q.CorrectAnswerIndices))
+		for _, i := range q.CorrectAnswerIndices {
+			if int(i) < len(q.Options) {
+				correct = append(correct, q.Options[i])
+			}
+		}
+		return strings.Join(correct, ", ")
+
+	case Numeric:
+		if q.NumericAnswer == nil {
+			return ""
+		}
+		s := strconv.FormatFloat(*q.NumericAnswer, 'f', -1, 64)
+		if q.NumericTolerance != nil && *q.NumericTolerance > 0 {
+			s += " ± " + strconv.FormatFloat(*q.NumericTolerance, 'f', -1, 64)
+		}
+		return s
+
+	case ShortText:
+		if len(q.AcceptedAnswers) == 0 {
+			return ""
+		}
+		return q.AcceptedAnswers[0]
+	}
+
+	if q.CorrectAnswerIndex >= 0 && q.CorrectAnswerIndex < len(q.Options) {
+		return q.Options[q.CorrectAnswerIndex]
+	}
+	return ""
+}
+
+// gradeMultipleSelect gives a share of the credit for each correct option
+// picked and takes one share away for each wrong one, so picking every
+// option doesn't pay
+func gradeMultipleSelect(q models.Question, choices []string) Result {
+	correct := make(map[string]bool, len(q.CorrectAnswerIndices))
+	for _, i := range q.CorrectAnswerIndices {
+		if int(i) < len(q.Options) {
+			correct[q.Options[i]] = true
+		}
+	}
+	if len(correct) == 0 {
+		return Result{}
+	}
+
+	hits, misses := 0, 0
+	picked := make(map[string]bool, len(choices))
+	for _, choice := range choices {
+		if picked[choice] {
+			continue
+		}
+		picked[choice] = true
+		if correct[choice] {
+			hits++
+		} else {
+			misses++
+		}
+	}
+
+	credit := float64(hits-misses) / float64(len(correct))
+	if credit <= 0 {
+		return Result{}
+	}
+	// Two decimals keep session scores readable
+	credit = math.Round(credit*100) / 100
+	return Result{Credit: credit, Correct: hits == len(correct) && misses == 0}
+}
+
+func all(correct bool) Result {
+	if correct {
+		return Result{Credit: 1, Correct: true}
+	}
+	return Result{}
+}
+
+// parseNumber reads a number. Commas that group thousands ("1,000" or
+// "12,345.6") are dropped; otherwise a single comma with no decimal point
+// is a decimal comma ("3,5") as written in Indonesian.
+func parseNumber(s string) (float64, bool) {
+	s = strings.TrimSpace(s)
+	whole, fraction, hasPoint := strings.Cut(s, ".")
+	switch {
+	case groupsThousands(whole):
+		whole = strings.ReplaceAll(whole, ",", "")
+	case !hasPoint && strings.Count(whole, ",") == 1:
+		whole = strings.Replace(whole, ",", ".", 1)
+	}
+	if hasPoint {
+		whole += "." + fraction
+	}
+	v, err := strconv.ParseFloat(whole, 64)
+	if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+		return 0, false
+	}
+	return v, true
+}
+
+// groupsThousands reports whether the commas in s split its digits into
+// groups of three after a leading group of one to three
+func groupsThousands(s string) bool {
+	groups := strings.Split(strings.TrimLeft(s, "+-"), ",")
+	if len(groups) < 2 || len(groups[0]) == 0 || len(groups[0]) > 3 {
+		return false
+	}
+	for i, g := range groups {
+		if i > 0 && len(g) != 3 {
+			return false
+		}
+		for _, r := range g {
+			if r < '0' || r > '9' {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// normalizeText lowercases s and reduces it to words separated by single
+// spaces, so case, punctuation and spacing don't matter
+func normalizeText(s string) string {
+	words := strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	return strings.Join(words, " ")
+}
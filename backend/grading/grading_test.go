@@ -0,0 +1,135 @@
+package grading
+
+import (
+	"testing"
+
+	"quiz-butterfly/backend/models"
+)
+
+func float(v float64) *float64 { return &v }
+
+func TestGrade(t *testing.T) {
+	single := models.Question{Type: SingleChoice, Options: []string{"3", "4"}, CorrectAnswerIndex: 1}
+	trueFalse := models.Question{Type: TrueFalse, Options: []string{"True", "False"}, CorrectAnswerIndex: 0}
+	multi := models.Question{Type: MultipleSelect, Options: []string{"2", "3", "4", "5"}, CorrectAnswerIndices: []int64{0, 1, 3}}
+	numeric := models.Question{Type: Numeric, NumericAnswer: float(9.81), NumericTolerance: float(0.01)}
+	exact := models.Question{Type: Numeric, NumericAnswer: float(0.3)}
+	thousand := models.Question{Type: Numeric, NumericAnswer: float(1000)}
+	big := models.Question{Type: Numeric, NumericAnswer: float(1234567.5)}
+	half := models.Question{Type: Numeric, NumericAnswer: float(1.5)}
+	short := models.Question{Type: ShortText, AcceptedAnswers: []string{"Jakarta", "DKI Jakarta"}}
+
+	tests := []struct {
+		name   string
+		q      models.Question
+		answer Answer
+		want   Result
+	}{
+		{"single choice right", single, Answer{Text: "4"}, Result{Credit: 1, Correct: true}},
+		{"single choice wrong", single, Answer{Text: "3"}, Result{}},
+		{"untyped question is single choice", models.Question{Options: []string{"a", "b"}}, Answer{Text: "a"}, Result{Credit: 1, Correct: true}},
+		{"true/false ignores case and spaces", trueFalse, Answer{Text: " true "}, Result{Credit: 1, Correct: true}},
+		{"true/false wrong", trueFalse, Answer{Text: "False"}, Result{}},
+		{"multiple select all correct", multi, Answer{Choices: []string{"5", "2", "3"}}, Result{Credit: 1, Correct: true}},
+		{"multiple select partly", multi, Answer{Choices: []string{"2", "3"}}, Result{Credit: 0.67}},
+		{"multiple select wrong pick costs a share", multi, Answer{Choices: []string{"2", "3", "4"}}, Result{Credit: 0.33}},
+		{"multiple select every option", multi, Answer{Choices: []string{"2", "3", "4", "5"}}, Result{Credit: 0.67}},
+		{"multiple select repeats count once", multi, Answer{Choices: []string{"2", "2", "2"}}, Result{Credit: 0.33}},
+		{"multiple select more wrong than right", multi, Answer{Choices: []string{"4", "7"}}, Result{}},
+		{"numeric within tolerance", numeric, Answer{Text: "9.8"}, Result{Credit: 1, Correct: true}},
+		{"numeric decimal comma", numeric, Answer{Text: "9,82"}, Result{Credit: 1, Correct: true}},
+		{"numeric thousands comma", thousand, Answer{Text: "1,000"}, Result{Credit: 1, Correct: true}},
+		{"numeric thousands comma is not a decimal", numeric, Answer{Text: "9,810"}, Result{}},
+		{"numeric thousands commas with decimal point", big, Answer{Text: "1,234,567.5"}, Result{Credit: 1, Correct: true}},
+		{"numeric decimal comma with one digit", half, Answer{Text: "1,5"}, Result{Credit: 1, Correct: true}},
+		{"numeric outside tolerance", numeric, Answer{Text: "9.7"}, Result{}},
+		{"numeric binary rounding", exact, Answer{Text: "0.30000000000000004"}, Result{Credit: 1, Correct: true}},
+		{"numeric not a number", numeric, Answer{Text: "nine"}, Result{}},
+		{"numeric NaN", numeric, Answer{Text: "NaN"}, Result{}},
+		{"short text ignores case and punctuation", short, Answer{Text: "  dki-JAKARTA! "}, Result{Credit: 1, Correct: true}},
+		{"short text wrong", short, Answer{Text: "Bandung"}, Result{}},
+		{"short text blank", short, Answer{Text: "?!"}, Result{}},
+		{"unknown type", models.Question{Type: "essay"}, Answer{Text: "anything"}, Result{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Grade(tt.q, tt.answer); got != tt.want {
+				t.Errorf("Grade = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCorrectAnswer(t *testing.T) {
+	tests := []struct {
+		name string
+		q    models.Question
+		want string
+	}{
+		{"single choice", models.Question{Type: SingleChoice, Options: []string{"3", "4"}, CorrectAnswerIndex: 1}, "4"},
+		{"multiple select", models.Question{Type: MultipleSelect, Options: []string{"2", "3", "4"}, CorrectAnswerIndices: []int64{0, 1}}, "2, 3"},
+		{"numeric with tolerance", models.Question{Type: Numeric, NumericAnswer: float(9.81), NumericTolerance: float(0.01)}, "9.81 ± 0.01"},
+		{"numeric exact", models.Question{Type: Numeric, NumericAnswer: float(42)}, "42"},
+		{"short text", models.Question{Type: ShortText, AcceptedAnswers: []string{"Jakarta", "DKI Jakarta"}}, "Jakarta"},
+		{"no options", models.Question{Type: SingleChoice}, ""},
+		{"numeric without answer", models.Question{Type: Numeric}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := CorrectAnswer(tt.q); got != tt.want {
+				t.Errorf("CorrectAnswer = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name  string
+		q     models.Question
+		field string
+		rule  string
+	}{
+		{"single choice without options", models.Question{}, "options", Required},
+		{"single choice index out of range", models.Question{Options: []string{"a"}, CorrectAnswerIndex: 1}, "correct_answer_index", OutOfRange},
+		{"true/false with three options", models.Question{Type: TrueFalse, Options: []string{"a", "b", "c"}}, "options", WrongCount},
+		{"multiple select with one option", models.Question{Type: MultipleSelect, Options: []string{"a"}}, "options", TooFew},
+		{"multiple select without answers", models.Question{Type: MultipleSelect, Options: []string{"a", "b"}}, "correct_answer_indices", Required},
+		{"multiple select duplicate answers", models.Question{Type: MultipleSelect, Options: []string{"a", "b"}, CorrectAnswerIndices: []int64{1, 1}}, "correct_answer_indices", NotUnique},
+		{"multiple select answer out of range", models.Question{Type: MultipleSelect, Options: []string{"a", "b"}, CorrectAnswerIndices: []int64{2}}, "correct_answer_indices", OutOfRange},
+		{"numeric without answer", models.Question{Type: Numeric}, "numeric_answer", Required},
+		{"numeric negative tolerance", models.Question{Type: Numeric, NumericAnswer: float(1), NumericTolerance: float(-1)}, "numeric_tolerance", TooSmall},
+		{"short text without answers", models.Question{Type: ShortText}, "accepted_answers", Required},
+		{"short text blank answer", models.Question{Type: ShortText, AcceptedAnswers: []string{"Paris", " - "}}, "accepted_answers", Blank},
+		{"unknown type", models.Question{Type: "essay"}, "type", NotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Validate(&tt.q)
+			if got == nil || got.Field != tt.field || got.Rule != tt.rule {
+				t.Errorf("Validate = %+v, want %s on %s", got, tt.rule, tt.field)
+			}
+		})
+	}
+}
+
+func TestValidateFillsDefaultsAndClearsOtherTypes(t *testing.T) {
+	q := models.Question{Type: TrueFalse, CorrectAnswerIndex: 1, NumericAnswer: float(3), AcceptedAnswers: []string{"x"}}
+	if fe := Validate(&q); fe != nil {
+		t.Fatalf("Validate = %+v", fe)
+	}
+	if len(q.Options) != 2 || q.Options[0] != "True" || q.Options[1] != "False" {
+		t.Errorf("options = %q, want True and False", q.Options)
+	}
+	if q.NumericAnswer != nil || q.AcceptedAnswers != nil {
+		t.Errorf("answers of other types kept: %+v", q)
+	}
+
+	q = models.Question{Type: ShortText, Options: []string{"a"}, CorrectAnswerIndex: 3, AcceptedAnswers: []string{"Paris"}}
+	if fe := Validate(&q); fe != nil {
+		t.Fatalf("Validate = %+v", fe)
+	}
+	if q.Options != nil || q.CorrectAnswerIndex != 0 {
+		t.Errorf("choice fields kept on a short text question: %+v", q)
+	}
+}
@@ -0,0 +1,294 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+
+	"quiz-butterfly/backend/apierror"
+	"quiz-butterfly/backend/logging"
+	"quiz-butterfly/backend/models"
+	"quiz-butterfly/backend/storage"
+)
+
+// attachmentTypes maps the image types accepted for upload to the file
+// extension their blobs are stored with
+var attachmentTypes = map[string]string{
+	"image/png":  ".png",
+	"image/jpeg": ".jpg",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+// UploadAttachmentHandler attaches an image to a question (admin only). The
+// image is sent as the multipart form field "file"; its type is detected
+// from the content rather than trusted from the client.
+func (h *Handler) UploadAttachmentHandler(c *gin.Context) {
+	db := h.db
+	ctx := c.Request.Context()
+
+	questionID := 0
+	if _, err := fmt.Sscanf(c.Param("id"), "%d", &questionID); err != nil {
+		c.Error(apierror.BadRequest(apierror.InvalidQuestionID))
+		return
+	}
+
+	var exists bool
+	if err := db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM questions WHERE id = $1)", questionID).Scan(&exists); err != nil {
+		c.Error(apierror.Internal(err, "Failed to get question"))
+		return
+	}
+	if !exists {
+		c.Error(apierror.NotFound(apierror.QuestionNotFound))
+		return
+	}
+
+	// Leave room for the multipart framing around the file
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, h.maxAttachmentBytes+64<<10)
+	header, err := c.FormFile("file")
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			c.Error(apierror.PayloadTooLarge(apierror.AttachmentTooLarge, h.maxAttachmentBytes))
+			return
+		}
+		c.Error(apierror.BadRequest(apierror.AttachmentMissing))
+		return
+	}
+	if header.Size > h.maxAttachmentBytes {
+		c.Error(apierror.PayloadTooLarge(apierror.AttachmentTooLarge, h.maxAttachmentBytes))
+		return
+	}
+
+	file, err := header.Open()
+	if err != nil {
+		c.Error(apierror.BadRequest(apierror.AttachmentUnreadable))
+		return
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(io.LimitReader(file, h.maxAttachmentBytes+1))
+	if err != nil {
+		c.Error(apierror.BadRequest(apierror.AttachmentUnreadable))
+		return
+	}
+	if int64(len(data)) > h.maxAttachmentBytes {
+		c.Error(apierror.PayloadTooLarge(apierror.AttachmentTooLarge, h.maxAttachmentBytes))
+		return
+	}
+
+	contentType := http.DetectContentType(data)
+	ext, ok := attachmentTypes[contentType]
+	if !ok {
+		c.Error(apierror.UnsupportedMedia(apierror.AttachmentType))
+		return
+	}
+
+	key := randomString(16) + ext
+	if err := h.blobs.Put(c.Request.Context(), key, bytes.NewReader(data)); err != nil {
+		c.Error(apierror.Internal(err, "Failed to store attachment"))
+		return
+	}
+
+	attachment := models.QuestionAttachment{
+		QuestionID:  questionID,
+		BlobKey:     key,
+		Filename:    attachmentFilename(header.Filename, ext),
+		ContentType: contentType,
+		SizeBytes:   int64(len(data)),
+	}
+	err = db.QueryRowContext(ctx, `
+		INSERT INTO question_attachments (question_id, blob_key, filename, content_type, size_bytes)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, created_at`,
+		questionID, key, attachment.Filename, contentType, attachment.SizeBytes).Scan(&attachment.ID, &attachment.CreatedAt)
+	if err != nil {
+		// Don't leave an unreferenced blob behind
+		h.blobs.Delete(c.Request.Context(), key)
+		c.Error(dbError(err, "Failed to save attachment"))
+		return
+	}
+	attachment.URL = h.attachmentURL(attachment.ID)
+
+	c.JSON(http.StatusCreated, attachment)
+}
+
+// DownloadAttachmentHandler serves an attachment's image. It takes no
+// session token: the signed link from the question's attachment URL is
+// the permission, so the URL can go straight into an <img src>.
+func (h *Handler) DownloadAttachmentHandler(c *gin.Context) {
+	db := h.db
+	ctx := c.Request.Context()
+
+	attachmentID := 0
+	if _, err := fmt.Sscanf(c.Param("id"), "%d", &attachmentID); err != nil {
+		c.Error(apierror.BadRequest(apierror.InvalidAttachmentID))
+		return
+	}
+	if !h.validAttachmentLink(c, attachmentID) {
+		c.Error(apierror.Forbidden(apierror.InvalidAttachmentLink))
+		return
+	}
+
+	var a models.QuestionAttachment
+	err := db.QueryRowContext(ctx, `
+		SELECT blob_key, filename, content_type, size_bytes
+		FROM question_attachments WHERE id = $1`, attachmentID).Scan(&a.BlobKey, &a.Filename, &a.ContentType, &a.SizeBytes)
+	if err == sql.ErrNoRows {
+		c.Error(apierror.NotFound(apierror.AttachmentNotFound))
+		return
+	}
+	if err != nil {
+		c.Error(apierror.Internal(err, "Failed to get attachment"))
+		return
+	}
+
+	blob, err := h.blobs.Get(c.Request.Context(), a.BlobKey)
+	if errors.Is(err, storage.ErrNotFound) {
+		c.Error(apierror.NotFound(apierror.AttachmentNotFound))
+		return
+	}
+	if err != nil {
+		c.Error(apierror.Internal(err, "Failed to read attachment"))
+		return
+	}
+	defer blob.Close()
+
+	c.Header("X-Content-Type-Options", "nosniff")
+	c.Header("Cache-Control", "private, max-age=86400")
+	c.DataFromReader(http.StatusOK, a.SizeBytes, a.ContentType, blob, map[string]string{
+		"Content-Disposition": fmt.Sprintf("inline; filename=%q", a.Filename),
+	})
+}
+
+// DeleteAttachmentHandler removes an attachment and its blob (admin only)
+func (h *Handler) DeleteAttachmentHandler(c *gin.Context) {
+	db := h.db
+	ctx := c.Request.Context()
+
+	attachmentID := 0
+	if _, err := fmt.Sscanf(c.Param("id"), "%d", &attachmentID); err != nil {
+		c.Error(apierror.BadRequest(apierror.InvalidAttachmentID))
+		return
+	}
+
+	var key string
+	err := db.QueryRowContext(ctx, "DELETE FROM question_attachments WHERE id = $1 RETURNING blob_key", attachmentID).Scan(&key)
+	if err == sql.ErrNoRows {
+		c.Error(apierror.NotFound(apierror.AttachmentNotFound))
+		return
+	}
+	if err != nil {
+		c.Error(apierror.Internal(err, "Failed to delete attachment"))
+		return
+	}
+	h.deleteBlobs(c, key)
+
+	c.JSON(http.StatusOK, gin.H{"message": "Attachment deleted successfully"})
+}
+
+// loadAttachments fills in the Attachments of each question with one query
+func (h *Handler) loadAttachments(ctx context.Context, db queryer, questions ...*models.Question) error {
+	if len(questions) == 0 {
+		return nil
+	}
+	byID := make(map[int]*models.Question, len(questions))
+	ids := make(pq.Int64Array, 0, len(questions))
+	for _, q := range questions {
+		byID[q.ID] = q
+		ids = append(ids, int64(q.ID))
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT id, question_id, blob_key, filename, content_type, size_bytes, created_at
+		FROM question_attachments WHERE question_id = ANY($1) ORDER BY id`, ids)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var a models.QuestionAttachment
+		if err := rows.Scan(&a.ID, &a.QuestionID, &a.BlobKey, &a.Filename, &a.ContentType, &a.SizeBytes, &a.CreatedAt); err != nil {
+			return err
+		}
+		a.URL = h.attachmentURL(a.ID)
+		q := byID[a.QuestionID]
+		q.Attachments = append(q.Attachments, a)
+	}
+	return rows.Err()
+}
+
+// deleteBlobs removes stored blobs once their rows are gone. A failure only
+// leaves an orphaned file, so it is logged rather than reported.
+func (h *Handler) deleteBlobs(c *gin.Context, keys ...string) {
+	for _, key := range keys {
+		if err := h.blobs.Delete(c.Request.Context(), key); err != nil {
+			logging.From(c).Error("Failed to delete attachment blob", "key", key, "error", err)
+		}
+	}
+}
+
+// attachmentURL links to attachment id for h.attachmentURLTTL
+func (h *Handler) attachmentURL(id int) string {
+	expires := time.Now().Add(h.attachmentURLTTL).Unix()
+	return fmt.Sprintf("/api/attachments/%d?expires=%d&signature=%s", id, expires, h.attachmentSignature(id, expires))
+}
+
+// validAttachmentLink reports whether the request carries an unexpired
+// signature for attachment id
+func (h *Handler) validAttachmentLink(c *gin.Context, id int) bool {
+	expires, err := strconv.ParseInt(c.Query("expires"), 10, 64)
+	if err != nil || time.Now().Unix() > expires {
+		return false
+	}
+	return hmac.Equal([]byte(c.Query("signature")), []byte(h.attachmentSignature(id, expires)))
+}
+
+func (h *Handler) attachmentSignature(id int, expires int64) string {
+	mac := hmac.New(sha256.New, h.attachmentKey)
+	fmt.Fprintf(mac, "%d:%d", id, expires)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// attachmentKey derives the key attachment links are signed with from the
+// JWT secret, so a link signature can't pass for anything else signed
+// with that secret
+func attachmentKey(jwtSecret []byte) []byte {
+	mac := hmac.New(sha256.New, jwtSecret)
+	mac.Write([]byte("attachment links"))
+	return mac.Sum(nil)
+}
+
+// attachmentFilename keeps the base of the uploaded name for downloads,
+// with the extension matching the detected type
+func attachmentFilename(name, ext string) string {
+	base := strings.TrimSuffix(filepath.Base(name), filepath.Ext(name))
+	base = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == '"' || r == '\\' || r == '/' || r == 0x7f {
+			return -1
+		}
+		return r
+	}, base)
+	if base == "" || base == "." {
+		base = "image"
+	}
+	if len(base) > 100 {
+		base = strings.ToValidUTF8(base[:100], "")
+	}
+	return base + ext
+}
@@ -1,6 +1,8 @@
 package handlers
 
 import (
+	"context"
+	"database/sql"
 	"net/http"
 	"strings"
 	"time"
@@ -9,128 +11,194 @@ import (
 	"github.com/golang-jwt/jwt/v5"
 	"golang.org/x/crypto/bcrypt"
 
-	"quiz-butterfly/backend/database"
+	"quiz-butterfly/backend/apierror"
+	"quiz-butterfly/backend/logging"
+	"quiz-butterfly/backend/metrics"
 	"quiz-butterfly/backend/models"
+	"quiz-butterfly/backend/policy"
 )
 
-var jwtSecret = []byte("your-secret-key-change-in-production")
+// hashPassword hashes a new password. bcrypt only uses the first
+// policy.BcryptMaxBytes bytes, so longer passwords are cut there, both
+// here and in checkPassword, and the user is warned about it.
+func hashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword(bcryptInput(password), bcrypt.DefaultCost)
+	return string(hash), err
+}
+
+// checkPassword reports whether password matches hash
+func checkPassword(hash, password string) error {
+	return bcrypt.CompareHashAndPassword([]byte(hash), bcryptInput(password))
+}
+
+func bcryptInput(password string) []byte {
+	b := []byte(password)
+	if len(b) > policy.BcryptMaxBytes {
+		b = b[:policy.BcryptMaxBytes]
+	}
+	return b
+}
 
-func RegisterHandler(c *gin.Context) {
-	db := database.GetDB()
+// passwordWarnings lists what the password policy accepted but the user
+// should know about, in the request's language
+func (h *Handler) passwordWarnings(c *gin.Context, password string) []models.FieldError {
+	var warnings []models.FieldError
+	loc := apierror.RequestLocale(c)
+	for _, p := range h.passwords.Warnings(password) {
+		warnings = append(warnings, apierror.Localize(fieldError(p), loc))
+	}
+	return warnings
+}
+
+func (h *Handler) RegisterHandler(c *gin.Context) {
+	db := h.db
+	ctx := c.Request.Context()
 
 	var req models.RegisterRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		c.Error(apierror.Binding(err))
+		return
+	}
+
+	if err := h.usernames.Validate(req.Username); err != nil {
+		c.Error(policyError(err))
+		return
+	}
+
+	if err := h.passwords.Validate(req.Password, req.Username); err != nil {
+		c.Error(policyError(err))
 		return
 	}
 
 	var existingUser models.User
-	err := db.QueryRow("SELECT id FROM users WHERE username = $1", strings.ToLower(req.Username)).Scan(&existingUser.ID)
+	err := db.QueryRowContext(ctx, "SELECT id FROM users WHERE username = $1", strings.ToLower(req.Username)).Scan(&existingUser.ID)
 	if err == nil {
-		c.JSON(http.StatusConflict, models.ErrorResponse{Error: "Username already exists"})
+		c.Error(apierror.Conflict(apierror.UsernameTaken))
 		return
 	}
 
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.Password), bcrypt.DefaultCost)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: "Failed to hash password"})
-		return
+	var email *string
+	if req.Email != "" {
+		normalized := strings.ToLower(strings.TrimSpace(req.Email))
+		email = &normalized
+
+		err = db.QueryRowContext(ctx, "SELECT id FROM users WHERE email = $1", normalized).Scan(&existingUser.ID)
+		if err == nil {
+			c.Error(apierror.Conflict(apierror.EmailTaken))
+			return
+		}
 	}
 
-	var user models.User
-	err = db.QueryRow(`
-        INSERT INTO users (username, password_hash, created_at, updated_at)
-        VALUES ($1, $2, now(), now())
-        RETURNING id, username, created_at, updated_at`,
-		strings.ToLower(req.Username), string(hashedPassword)).Scan(&user.ID, &user.Username, &user.CreatedAt, &user.UpdatedAt)
+	hashedPassword, err := hashPassword(req.Password)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: "Failed to create user"})
+		c.Error(apierror.Internal(err, "Failed to hash password"))
 		return
 	}
 
-	// initial high scores
-	_, err = db.Exec(`
-        INSERT INTO high_scores (user_id, difficulty, score)
-        VALUES ($1, 'easy', 0), ($1, 'medium', 0), ($1, 'advance', 0)`,
-		user.ID)
+	user, err := createUser(ctx, db, strings.ToLower(req.Username), email, hashedPassword, false)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: "Failed to create high scores"})
+		c.Error(dbError(err, "Failed to create user"))
 		return
 	}
 
-	token, err := generateToken(user.ID, user.Username)
+	token, err := h.generateToken(user.ID, user.Username)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: "Failed to generate token"})
+		c.Error(apierror.Internal(err, "Failed to generate token"))
 		return
 	}
 
-	c.JSON(http.StatusCreated, models.AuthResponse{User: user, Token: token})
+	c.JSON(http.StatusCreated, models.AuthResponse{User: user, Token: token, Warnings: h.passwordWarnings(c, req.Password)})
 }
 
-func LoginHandler(c *gin.Context) {
-	db := database.GetDB()
+func (h *Handler) LoginHandler(c *gin.Context) {
+	db := h.db
+	ctx := c.Request.Context()
 
 	var req models.LoginRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, models.ErrorResponse{Error: err.Error()})
+		c.Error(apierror.Binding(err))
 		return
 	}
 
 	var user models.User
-	err := db.QueryRow(`
-        SELECT id, username, password_hash, created_at, updated_at
+	err := db.QueryRowContext(ctx, `
+        SELECT id, username, email, password_hash, is_guest, role, created_at, updated_at
         FROM users WHERE username = $1`,
-		strings.ToLower(req.Username)).Scan(&user.ID, &user.Username, &user.PasswordHash, &user.CreatedAt, &user.UpdatedAt)
+		strings.ToLower(req.Username)).Scan(&user.ID, &user.Username, &user.Email, &user.PasswordHash, &user.IsGuest, &user.Role, &user.CreatedAt, &user.UpdatedAt)
+	if err == sql.ErrNoRows {
+		metrics.Login("password", false)
+		c.Error(apierror.Unauthorized(apierror.InvalidCredentials))
+		return
+	}
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, models.ErrorResponse{Error: "Invalid credentials"})
+		c.Error(apierror.Internal(err, "Failed to get user"))
 		return
 	}
 
-	err = bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password))
+	err = checkPassword(user.PasswordHash, req.Password)
 	if err != nil {
-		c.JSON(http.StatusUnauthorized, models.ErrorResponse{Error: "Invalid credentials"})
+		metrics.Login("password", false)
+		c.Error(apierror.Unauthorized(apierror.InvalidCredentials))
 		return
 	}
 
-	token, err := generateToken(user.ID, user.Username)
+	token, err := h.generateToken(user.ID, user.Username)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.ErrorResponse{Error: "Failed to generate token"})
+		c.Error(apierror.Internal(err, "Failed to generate token"))
 		return
 	}
 
+	metrics.Login("password", true)
 	c.JSON(http.StatusOK, models.AuthResponse{User: user, Token: token})
 }
 
-func AuthMiddleware() gin.HandlerFunc {
+// QueryTokenMiddleware lets a request without an Authorization header pass
+// its token as ?token=. Browsers can't set headers on WebSocket or
+// EventSource requests, so this is only meant for the live room socket and
+// the event stream; anything else keeps the session token out of URLs.
+func (h *Handler) QueryTokenMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if token := c.Query("token"); token != "" && c.GetHeader("Authorization") == "" {
+			c.Request.Header.Set("Authorization", "Bearer "+token)
+		}
+		c.Next()
+	}
+}
+
+func (h *Handler) AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		authHeader := c.GetHeader("Authorization")
 		if authHeader == "" {
-			c.JSON(http.StatusUnauthorized, models.ErrorResponse{Error: "Authorization header required"})
+			c.Error(apierror.Unauthorized(apierror.AuthorizationRequired))
 			c.Abort()
 			return
 		}
 
 		tokenString := strings.Replace(authHeader, "Bearer ", "", 1)
 		if tokenString == authHeader {
-			c.JSON(http.StatusUnauthorized, models.ErrorResponse{Error: "Invalid authorization header format"})
+			c.Error(apierror.Unauthorized(apierror.InvalidAuthorization))
 			c.Abort()
 			return
 		}
 
 		token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-			return jwtSecret, nil
+			return h.jwtSecret, nil
 		})
 		if err != nil || !token.Valid {
-			c.JSON(http.StatusUnauthorized, models.ErrorResponse{Error: "Invalid token"})
+			c.Error(apierror.Unauthorized(apierror.InvalidToken))
 			c.Abort()
 			return
 		}
 
 		if claims, ok := token.Claims.(jwt.MapClaims); ok {
-			c.Set("user_id", int(claims["user_id"].(float64)))
+			userID := int(claims["user_id"].(float64))
+			c.Set("user_id", userID)
+			logging.With(c, "user_id", userID)
 			c.Set("username", claims["username"].(string))
+			isGuest, _ := claims["guest"].(bool)
+			c.Set("is_guest", isGuest)
 		} else {
-			c.JSON(http.StatusUnauthorized, models.ErrorResponse{Error: "Invalid token claims"})
+			c.Error(apierror.Unauthorized(apierror.InvalidToken))
 			c.Abort()
 			return
 		}
@@ -139,21 +207,99 @@ func AuthMiddleware() gin.HandlerFunc {
 	}
 }
 
-func generateToken(userID int, username string) (string, error) {
+// createUser inserts a user together with its initial high score rows
+func createUser(ctx context.Context, db *sql.DB, username string, email *string, passwordHash string, guest bool) (models.User, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return models.User{}, err
+	}
+	defer tx.Rollback()
+
+	user, err := insertUser(ctx, tx, username, email, passwordHash, guest)
+	if err != nil {
+		return user, err
+	}
+	return user, tx.Commit()
+}
+
+// insertUser is createUser inside the caller's transaction
+func insertUser(ctx context.Context, tx *sql.Tx, username string, email *string, passwordHash string, guest bool) (models.User, error) {
+	var user models.User
+	err := tx.QueryRowContext(ctx, `
+        INSERT INTO users (username, email, password_hash, is_guest, created_at, updated_at)
+        VALUES ($1, $2, $3, $4, now(), now())
+        RETURNING id, username, email, is_guest, role, created_at, updated_at`,
+		username, email, passwordHash, guest).Scan(&user.ID, &user.Username, &user.Email, &user.IsGuest, &user.Role, &user.CreatedAt, &user.UpdatedAt)
+	if err != nil {
+		return user, err
+	}
+
+	// initial high scores
+	_, err = tx.ExecContext(ctx, `
+        INSERT INTO high_scores (user_id, difficulty, score)
+        VALUES ($1, 'easy', 0), ($1, 'medium', 0), ($1, 'advance', 0)`,
+		user.ID)
+	return user, err
+}
+
+func (h *Handler) generateToken(userID int, username string) (string, error) {
 	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
 		"user_id":  userID,
 		"username": username,
 		"exp":      time.Now().Add(time.Hour * 24).Unix(),
 		"iat":      time.Now().Unix(),
 	})
-	return token.SignedString(jwtSecret)
+	return token.SignedString(h.jwtSecret)
+}
+
+// generateGuestToken issues a shorter-lived token flagged as a guest
+func (h *Handler) generateGuestToken(userID int, username string) (string, error) {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"user_id":  userID,
+		"username": username,
+		"guest":    true,
+		"exp":      time.Now().Add(guestTokenTTL).Unix(),
+		"iat":      time.Now().Unix(),
+	})
+	return token.SignedString(h.jwtSecret)
+}
+
+// RegisteredUserMiddleware rejects guest tokens
+func (h *Handler) RegisteredUserMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetBool("is_guest") {
+			c.Error(apierror.Forbidden(apierror.AccountRequired))
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
+}
+
+// TeacherMiddleware allows only users with the teacher role (and the admin)
+func (h *Handler) TeacherMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.GetString("username") == "admin" {
+			c.Next()
+			return
+		}
+
+		var role string
+		err := h.db.QueryRowContext(c.Request.Context(), "SELECT role FROM users WHERE id = $1", c.GetInt("user_id")).Scan(&role)
+		if err != nil || role != "teacher" {
+			c.Error(apierror.Forbidden(apierror.TeacherRequired))
+			c.Abort()
+			return
+		}
+		c.Next()
+	}
 }
 
-func AdminMiddleware() gin.HandlerFunc {
+func (h *Handler) AdminMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		username, exists := c.Get("username")
 		if !exists || username.(string) != "admin" {
-			c.JSON(http.StatusForbidden, models.ErrorResponse{Error: "Admin access required"})
+			c.Error(apierror.Forbidden(apierror.AdminRequired))
 			c.Abort()
 			return
 		}
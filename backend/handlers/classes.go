@@ -0,0 +1,520 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+
+	"quiz-butterfly/backend/apierror"
+	"quiz-butterfly/backend/models"
+)
+
+// joinCodeAlphabet leaves out characters that are easy to confuse (0/O, 1/I)
+const joinCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// UpdateUserRoleHandler makes a user a teacher or a student (admin only)
+func (h *Handler) UpdateUserRoleHandler(c *gin.Context) {
+	db := h.db
+	ctx := c.Request.Context()
+
+	userID := 0
+	if _, err := fmt.Sscanf(c.Param("id"), "%d", &userID); err != nil {
+		c.Error(apierror.BadRequest(apierror.InvalidUserID))
+		return
+	}
+
+	var req models.UserRoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(apierror.Binding(err))
+		return
+	}
+
+	result, err := db.ExecContext(ctx, "UPDATE users SET role = $1 WHERE id = $2 AND NOT is_guest", req.Role, userID)
+	if err != nil {
+		c.Error(apierror.Internal(err, "Failed to update role"))
+		return
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil || rowsAffected == 0 {
+		c.Error(apierror.NotFound(apierror.UserNotFound))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Role updated successfully", "role": req.Role})
+}
+
+// CreateClassHandler creates a class owned by the calling teacher
+func (h *Handler) CreateClassHandler(c *gin.Context) {
+	db := h.db
+	ctx := c.Request.Context()
+	userID := c.GetInt("user_id")
+
+	var req models.ClassCreateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(apierror.Binding(err))
+		return
+	}
+
+	var class models.Class
+	var err error
+	// Join codes are random, so retry the rare collision on the unique index
+	for attempt := 0; attempt < 5; attempt++ {
+		err = db.QueryRowContext(ctx, `
+			INSERT INTO classes (teacher_id, name, join_code)
+			VALUES ($1, $2, $3)
+			RETURNING id, teacher_id, name, join_code, created_at`,
+			userID, strings.TrimSpace(req.Name), generateJoinCode()).Scan(
+			&class.ID, &class.TeacherID, &class.Name, &class.JoinCode, &class.CreatedAt)
+		if !isUniqueViolation(err) {
+			break
+		}
+	}
+	if err != nil {
+		c.Error(apierror.Internal(err, "Failed to create class"))
+		return
+	}
+
+	c.JSON(http.StatusCreated, class)
+}
+
+// ListClassesHandler returns the classes the user teaches or belongs to.
+// Join codes are only included for the teacher.
+func (h *Handler) ListClassesHandler(c *gin.Context) {
+	db := h.db
+	ctx := c.Request.Context()
+	userID := c.GetInt("user_id")
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT c.id, c.teacher_id, c.name,
+			CASE WHEN c.teacher_id = $1 THEN c.join_code ELSE '' END,
+			(SELECT COUNT(*) FROM class_members m WHERE m.class_id = c.id),
+			c.created_at
+		FROM classes c
+		WHERE c.teacher_id = $1
+			OR EXISTS (SELECT 1 FROM class_members m WHERE m.class_id = c.id AND m.user_id = $1)
+		ORDER BY c.created_at DESC`, userID)
+	if err != nil {
+		c.Error(apierror.Internal(err, "Failed to get classes"))
+		return
+	}
+	defer rows.Close()
+
+	classes := []models.Class{}
+	for rows.Next() {
+		var class models.Class
+		if err := rows.Scan(&class.ID, &class.TeacherID, &class.Name, &class.JoinCode, &class.MemberCount, &class.CreatedAt); err != nil {
+			c.Error(apierror.Internal(err, "Failed to get classes"))
+			return
+		}
+		classes = append(classes, class)
+	}
+	if err := rows.Err(); err != nil {
+		c.Error(apierror.Internal(err, "Failed to get classes"))
+		return
+	}
+
+	c.JSON(http.StatusOK, classes)
+}
+
+// JoinClassHandler adds the user to the class with the given join code
+func (h *Handler) JoinClassHandler(c *gin.Context) {
+	db := h.db
+	ctx := c.Request.Context()
+	userID := c.GetInt("user_id")
+
+	var req models.ClassJoinRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(apierror.Binding(err))
+		return
+	}
+
+	var class models.Class
+	err := db.QueryRowContext(ctx, `
+		SELECT id, teacher_id, name, created_at FROM classes WHERE join_code = $1`,
+		strings.ToUpper(strings.TrimSpace(req.JoinCode))).Scan(&class.ID, &class.TeacherID, &class.Name, &class.CreatedAt)
+	if err == sql.ErrNoRows {
+		c.Error(apierror.NotFound(apierror.InvalidJoinCode))
+		return
+	}
+	if err != nil {
+		c.Error(dbError(err, "Failed to join class"))
+		return
+	}
+
+	if class.TeacherID == userID {
+		c.Error(apierror.Conflict(apierror.OwnClass))
+		return
+	}
+
+	_, err = db.ExecContext(ctx, `
+		INSERT INTO class_members (class_id, user_id) VALUES ($1, $2)
+		ON CONFLICT (class_id, user_id) DO NOTHING`, class.ID, userID)
+	if err != nil {
+		c.Error(dbError(err, "Failed to join class"))
+		return
+	}
+
+	c.JSON(http.StatusOK, class)
+}
+
+// GetClassHandler returns a class with its assignments. The teacher also
+// gets the member list and join code.
+func (h *Handler) GetClassHandler(c *gin.Context) {
+	db := h.db
+	ctx := c.Request.Context()
+	userID := c.GetInt("user_id")
+
+	class, isTeacher, ok := h.loadClassForUser(c, userID)
+	if !ok {
+		return
+	}
+
+	detail := models.ClassDetail{Class: class}
+	if !isTeacher {
+		detail.Class.JoinCode = ""
+	}
+
+	if isTeacher {
+		members, err := classMembers(ctx, db, class.ID)
+		if err != nil {
+			c.Error(apierror.Internal(err, "Failed to get class members"))
+			return
+		}
+		detail.Members = members
+	}
+
+	assignments, err := classAssignments(ctx, db, class.ID)
+	if err != nil {
+		c.Error(apierror.Internal(err, "Failed to get assignments"))
+		return
+	}
+	detail.Assignments = assignments
+
+	c.JSON(http.StatusOK, detail)
+}
+
+// RemoveClassMemberHandler removes a student from a class. Teachers can
+// remove anyone from their class; students can only remove themselves.
+func (h *Handler) RemoveClassMemberHandler(c *gin.Context) {
+	db := h.db
+	ctx := c.Request.Context()
+	userID := c.GetInt("user_id")
+
+	class, isTeacher, ok := h.loadClassForUser(c, userID)
+	if !ok {
+		return
+	}
+
+	memberID := 0
+	if _, err := fmt.Sscanf(c.Param("user_id"), "%d", &memberID); err != nil {
+		c.Error(apierror.BadRequest(apierror.InvalidUserID))
+		return
+	}
+
+	if !isTeacher && memberID != userID {
+		c.Error(apierror.Forbidden(apierror.ClassTeacherRequired))
+		return
+	}
+
+	result, err := db.ExecContext(ctx, "DELETE FROM class_members WHERE class_id = $1 AND user_id = $2", class.ID, memberID)
+	if err != nil {
+		c.Error(apierror.Internal(err, "Failed to remove member"))
+		return
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil || rowsAffected == 0 {
+		c.Error(apierror.NotFound(apierror.MemberNotFound))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Member removed successfully"})
+}
+
+// CreateAssignmentHandler assigns a difficulty or a question set to a class
+// (class teacher only)
+func (h *Handler) CreateAssignmentHandler(c *gin.Context) {
+	db := h.db
+	ctx := c.Request.Context()
+	userID := c.GetInt("user_id")
+
+	class, isTeacher, ok := h.loadClassForUser(c, userID)
+	if !ok {
+		return
+	}
+	if !isTeacher {
+		c.Error(apierror.Forbidden(apierror.ClassTeacherRequired))
+		return
+	}
+
+	var req models.AssignmentCreateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(apierror.Binding(err))
+		return
+	}
+
+	if (req.Difficulty == "") == (len(req.QuestionIDs) == 0) {
+		c.Error(apierror.BadRequest(apierror.AssignmentTarget))
+		return
+	}
+
+	if !req.DueAt.After(time.Now()) {
+		c.Error(apierror.BadRequest(apierror.DueDateInPast))
+		return
+	}
+
+	var difficulty *string
+	var questionIDs pq.Int64Array
+	if req.Difficulty != "" {
+		difficulty = &req.Difficulty
+	} else {
+		questionIDs = pq.Int64Array(req.QuestionIDs)
+
+		var found int
+		err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM questions WHERE id = ANY($1)", questionIDs).Scan(&found)
+		if err != nil {
+			c.Error(apierror.Internal(err, "Failed to check questions"))
+			return
+		}
+		if found != len(uniqueIDs(req.QuestionIDs)) {
+			c.Error(apierror.BadRequest(apierror.UnknownQuestions))
+			return
+		}
+	}
+
+	var assignment models.ClassAssignment
+	err := db.QueryRowContext(ctx, `
+		INSERT INTO class_assignments (class_id, title, difficulty, question_ids, due_at)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id, class_id, title, difficulty, question_ids, due_at, created_at`,
+		class.ID, req.Title, difficulty, questionIDs, req.DueAt).Scan(
+		&assignment.ID, &assignment.ClassID, &assignment.Title, &assignment.Difficulty,
+		&assignment.QuestionIDs, &assignment.DueAt, &assignment.CreatedAt)
+	if err != nil {
+		c.Error(dbError(err, "Failed to create assignment"))
+		return
+	}
+
+	c.JSON(http.StatusCreated, assignment)
+}
+
+// GetClassResultsHandler returns every student's progress on every
+// assignment of a class (class teacher only). Difficulty assignments count
+// quizzes finished between assignment creation and the due date; question
+// set assignments count distinct questions answered in that window.
+func (h *Handler) GetClassResultsHandler(c *gin.Context) {
+	db := h.db
+	ctx := c.Request.Context()
+	userID := c.GetInt("user_id")
+
+	class, isTeacher, ok := h.loadClassForUser(c, userID)
+	if !ok {
+		return
+	}
+	if !isTeacher {
+		c.Error(apierror.Forbidden(apierror.ClassTeacherRequired))
+		return
+	}
+
+	assignments, err := classAssignments(ctx, db, class.ID)
+	if err != nil {
+		c.Error(apierror.Internal(err, "Failed to get assignments"))
+		return
+	}
+
+	dashboard := models.ClassDashboard{Class: class, Assignments: []models.AssignmentResults{}}
+	byID := make(map[int]int, len(assignments))
+	for i, a := range assignments {
+		byID[a.ID] = i
+		dashboard.Assignments = append(dashboard.Assignments, models.AssignmentResults{
+			Assignment: a,
+			Students:   []models.StudentAssignmentResult{},
+		})
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT a.id, u.id, u.username,
+			s.attempts, s.best_score, s.finished_at,
+			q.answered, q.correct, q.last_answered_at
+		FROM class_assignments a
+		JOIN class_members m ON m.class_id = a.class_id
+		JOIN users u ON u.id = m.user_id
+		LEFT JOIN LATERAL (
+			SELECT COUNT(*) AS attempts, MAX(qs.score) AS best_score, MIN(qs.finished_at) AS finished_at
+			FROM quiz_sessions qs
+			WHERE a.difficulty IS NOT NULL AND qs.user_id = m.user_id
+				AND qs.status = 'finished' AND qs.difficulty = a.difficulty
+				AND qs.finished_at BETWEEN a.created_at AND a.due_at
+		) s ON TRUE
+		LEFT JOIN LATERAL (
+			SELECT COUNT(DISTINCT ua.question_id) AS answered,
+				COUNT(DISTINCT ua.question_id) FILTER (WHERE ua.is_correct) AS correct,
+				MAX(ua.answered_at) AS last_answered_at
+			FROM user_answers ua
+			JOIN quiz_sessions qs ON qs.id = ua.quiz_session_id
+			WHERE a.question_ids IS NOT NULL AND qs.user_id = m.user_id
+				AND ua.question_id = ANY(a.question_ids)
+				AND ua.answered_at BETWEEN a.created_at AND a.due_at
+		) q ON TRUE
+		WHERE a.class_id = $1
+		ORDER BY a.due_at, u.username`, class.ID)
+	if err != nil {
+		c.Error(apierror.Internal(err, "Failed to get results"))
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var assignmentID int
+		var r models.StudentAssignmentResult
+		var bestScore sql.NullFloat64
+		var finishedAt, lastAnsweredAt *time.Time
+		if err := rows.Scan(&assignmentID, &r.UserID, &r.Username,
+			&r.Attempts, &bestScore, &finishedAt,
+			&r.Answered, &r.Correct, &lastAnsweredAt); err != nil {
+			c.Error(apierror.Internal(err, "Failed to get results"))
+			return
+		}
+
+		i, found := byID[assignmentID]
+		if !found {
+			continue
+		}
+		results := &dashboard.Assignments[i]
+
+		if results.Assignment.Difficulty != nil {
+			if bestScore.Valid {
+				score := bestScore.Float64
+				r.BestScore = &score
+			}
+			r.Completed = r.Attempts > 0
+			r.CompletedAt = finishedAt
+		} else {
+			r.Completed = r.Answered >= len(uniqueIDs(results.Assignment.QuestionIDs))
+			if r.Completed {
+				r.CompletedAt = lastAnsweredAt
+			}
+		}
+
+		if r.Completed {
+			results.CompletedCount++
+		}
+		results.Students = append(results.Students, r)
+	}
+	if err := rows.Err(); err != nil {
+		c.Error(apierror.Internal(err, "Failed to get results"))
+		return
+	}
+
+	c.JSON(http.StatusOK, dashboard)
+}
+
+// loadClassForUser loads the class named by the :id param and checks the
+// user teaches or belongs to it. On failure it writes the error response
+// and returns ok == false.
+func (h *Handler) loadClassForUser(c *gin.Context, userID int) (class models.Class, isTeacher bool, ok bool) {
+	db := h.db
+	ctx := c.Request.Context()
+
+	classID := 0
+	if _, err := fmt.Sscanf(c.Param("id"), "%d", &classID); err != nil {
+		c.Error(apierror.BadRequest(apierror.InvalidClassID))
+		return class, false, false
+	}
+
+	var isMember bool
+	err := db.QueryRowContext(ctx, `
+		SELECT c.id, c.teacher_id, c.name, c.join_code, c.created_at,
+			(SELECT COUNT(*) FROM class_members m WHERE m.class_id = c.id),
+			EXISTS (SELECT 1 FROM class_members m WHERE m.class_id = c.id AND m.user_id = $2)
+		FROM classes c WHERE c.id = $1`, classID, userID).Scan(
+		&class.ID, &class.TeacherID, &class.Name, &class.JoinCode, &class.CreatedAt, &class.MemberCount, &isMember)
+	if err == sql.ErrNoRows {
+		c.Error(apierror.NotFound(apierror.ClassNotFound))
+		return class, false, false
+	}
+	if err != nil {
+		c.Error(apierror.Internal(err, "Failed to get class"))
+		return class, false, false
+	}
+
+	isTeacher = class.TeacherID == userID
+	if !isTeacher && !isMember {
+		// Same answer as a missing class so IDs can't be probed
+		c.Error(apierror.NotFound(apierror.ClassNotFound))
+		return class, false, false
+	}
+
+	return class, isTeacher, true
+}
+
+func classMembers(ctx context.Context, db *sql.DB, classID int) ([]models.ClassMember, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT u.id, u.username, m.joined_at
+		FROM class_members m JOIN users u ON u.id = m.user_id
+		WHERE m.class_id = $1 ORDER BY u.username`, classID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	members := []models.ClassMember{}
+	for rows.Next() {
+		var m models.ClassMember
+		if err := rows.Scan(&m.UserID, &m.Username, &m.JoinedAt); err != nil {
+			return nil, err
+		}
+		members = append(members, m)
+	}
+	return members, rows.Err()
+}
+
+func classAssignments(ctx context.Context, db *sql.DB, classID int) ([]models.ClassAssignment, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT id, class_id, title, difficulty, question_ids, due_at, created_at
+		FROM class_assignments WHERE class_id = $1 ORDER BY due_at`, classID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	assignments := []models.ClassAssignment{}
+	for rows.Next() {
+		var a models.ClassAssignment
+		if err := rows.Scan(&a.ID, &a.ClassID, &a.Title, &a.Difficulty, &a.QuestionIDs, &a.DueAt, &a.CreatedAt); err != nil {
+			return nil, err
+		}
+		assignments = append(assignments, a)
+	}
+	return assignments, rows.Err()
+}
+
+func generateJoinCode() string {
+	code := make([]byte, 8)
+	max := big.NewInt(int64(len(joinCodeAlphabet)))
+	for i := range code {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			panic(err)
+		}
+		code[i] = joinCodeAlphabet[n.Int64()]
+	}
+	return string(code)
+}
+
+func uniqueIDs(ids []int64) map[int64]struct{} {
+	set := make(map[int64]struct{}, len(ids))
+	for _, id := range ids {
+		set[id] = struct{}{}
+	}
+	return set
+}
@@ -0,0 +1,290 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+
+	"quiz-butterfly/backend/apierror"
+	"quiz-butterfly/backend/logging"
+	"quiz-butterfly/backend/metrics"
+	"quiz-butterfly/backend/models"
+)
+
+const (
+	modeStandard = "standard"
+
+	dailyDateLayout = "2006-01-02"
+	// Each daily challenge takes this many questions from every difficulty
+	dailyQuestionsPerDifficulty = 3
+)
+
+func (h *Handler) dailyToday() time.Time {
+	now := time.Now().In(h.daily)
+	return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// GetDailyChallengeHandler returns today's challenge and the caller's
+// progress and streak
+func (h *Handler) GetDailyChallengeHandler(c *gin.Context) {
+	db := h.db
+	ctx := c.Request.Context()
+	userID := c.GetInt("user_id")
+	today := h.dailyToday()
+
+	questionIDs, err := dailyChallengeQuestions(ctx, db, today)
+	if err != nil {
+		c.Error(apierror.Internal(err, "Failed to get daily challenge"))
+		return
+	}
+
+	status := models.DailyChallengeStatus{
+		Date:           today.Format(dailyDateLayout),
+		TotalQuestions: len(questionIDs),
+		Status:         "not_started",
+	}
+
+	var sessionStatus string
+	var score float64
+	err = db.QueryRowContext(ctx, `
+		SELECT status, score FROM quiz_sessions
+		WHERE user_id = $1 AND mode = 'daily' AND challenge_date = $2`,
+		userID, today.Format(dailyDateLayout)).Scan(&sessionStatus, &score)
+	if err != nil && err != sql.ErrNoRows {
+		c.Error(apierror.Internal(err, "Failed to get daily challenge"))
+		return
+	}
+	if err == nil {
+		status.Status = sessionStatus
+		status.Score = &score
+	}
+
+	status.Streak, err = dailyStreak(ctx, db, userID, today)
+	if err != nil {
+		c.Error(apierror.Internal(err, "Failed to get daily streak"))
+		return
+	}
+
+	c.JSON(http.StatusOK, status)
+}
+
+// StartDailyChallengeHandler starts today's challenge, or resumes it if the
+// caller already started. Each user gets one attempt per day; answers and
+// finishing go through the regular quiz endpoints.
+func (h *Handler) StartDailyChallengeHandler(c *gin.Context) {
+	db := h.db
+	ctx := c.Request.Context()
+	userID := c.GetInt("user_id")
+	today := h.dailyToday()
+
+	questionIDs, err := dailyChallengeQuestions(ctx, db, today)
+	if err != nil {
+		c.Error(apierror.Internal(err, "Failed to get daily challenge"))
+		return
+	}
+	if len(questionIDs) == 0 {
+		c.Error(apierror.Unavailable(apierror.DailyNoQuestions))
+		return
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		c.Error(apierror.Internal(err, "Failed to start daily challenge"))
+		return
+	}
+	defer tx.Rollback()
+
+	var session models.QuizSession
+	started := false
+	err = tx.QueryRowContext(ctx, `
+		SELECT id, difficulty, mode, question_ids, current_question_index, score, status
+		FROM quiz_sessions WHERE user_id = $1 AND mode = 'daily' AND challenge_date = $2
+		FOR UPDATE`, userID, today.Format(dailyDateLayout)).Scan(&session.ID, &session.Difficulty, &session.Mode,
+		&session.QuestionIDs, &session.CurrentQuestionIndex, &session.Score, &session.Status)
+	switch {
+	case err == sql.ErrNoRows:
+		if err := closePlayingSessions(ctx, tx, userID); err != nil {
+			c.Error(apierror.Internal(err, "Failed to start daily challenge"))
+			return
+		}
+		err = tx.QueryRowContext(ctx, `
+			INSERT INTO quiz_sessions (user_id, difficulty, mode, challenge_date, question_ids, current_question_index, score, status)
+			VALUES ($1, 'mixed', 'daily', $2, $3, 0, 0, 'playing')
+			ON CONFLICT (user_id, challenge_date) WHERE mode = 'daily' DO NOTHING
+			RETURNING id, difficulty, mode, question_ids, current_question_index, score, status`,
+			userID, today.Format(dailyDateLayout), questionIDs).Scan(&session.ID, &session.Difficulty, &session.Mode,
+			&session.QuestionIDs, &session.CurrentQuestionIndex, &session.Score, &session.Status)
+		if err == sql.ErrNoRows {
+			// Another request from the same user started it first
+			c.Error(apierror.Conflict(apierror.DailyStarted))
+			return
+		}
+		if err != nil {
+			c.Error(apierror.Internal(err, "Failed to start daily challenge"))
+			return
+		}
+		started = true
+	case err != nil:
+		c.Error(apierror.Internal(err, "Failed to start daily challenge"))
+		return
+	case session.Status != "playing":
+		// Leaving the challenge for another quiz uses up the day's attempt
+		c.Error(apierror.Conflict(apierror.DailyPlayed))
+		return
+	}
+	logging.With(c, "session_id", session.ID)
+
+	question, err := h.sessionQuestion(ctx, tx, &session, session.CurrentQuestionIndex, requestLocale(c, tx))
+	if err != nil {
+		c.Error(apierror.Internal(err, "Failed to get question"))
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.Error(apierror.Internal(err, "Failed to start daily challenge"))
+		return
+	}
+	if started {
+		metrics.QuizStarted(session.Difficulty, session.Mode)
+	}
+
+	c.JSON(http.StatusOK, models.QuizProgress{
+		SessionID:            session.ID,
+		Mode:                 session.Mode,
+		TotalQuestions:       len(session.QuestionIDs),
+		CurrentQuestionIndex: session.CurrentQuestionIndex,
+		Score:                session.Score,
+		Status:               session.Status,
+		CurrentQuestion:      question.ForPlayer(),
+	})
+}
+
+// GetDailyLeaderboardHandler returns the top finished attempts for a day,
+// today unless ?date=YYYY-MM-DD is given. Ties go to the faster player.
+func (h *Handler) GetDailyLeaderboardHandler(c *gin.Context) {
+	db := h.db
+	ctx := c.Request.Context()
+
+	date := h.dailyToday()
+	if raw := c.Query("date"); raw != "" {
+		parsed, err := time.Parse(dailyDateLayout, raw)
+		if err != nil {
+			c.Error(apierror.BadRequest(apierror.InvalidDate))
+			return
+		}
+		date = parsed
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT u.id, u.username, qs.score,
+			EXTRACT(EPOCH FROM qs.finished_at - qs.started_at)::int AS duration
+		FROM quiz_sessions qs
+		JOIN users u ON u.id = qs.user_id
+		WHERE qs.mode = 'daily' AND qs.challenge_date = $1 AND qs.status = 'finished' AND NOT u.is_guest
+		ORDER BY qs.score DESC, duration ASC, qs.finished_at ASC
+		LIMIT 10`, date.Format(dailyDateLayout))
+	if err != nil {
+		c.Error(apierror.Internal(err, "Failed to get leaderboard"))
+		return
+	}
+	defer rows.Close()
+
+	entries := []models.DailyLeaderboardEntry{}
+	for rows.Next() {
+		var e models.DailyLeaderboardEntry
+		if err := rows.Scan(&e.UserID, &e.Username, &e.Score, &e.DurationSeconds); err != nil {
+			c.Error(apierror.Internal(err, "Failed to get leaderboard"))
+			return
+		}
+		e.Rank = len(entries) + 1
+		entries = append(entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		c.Error(apierror.Internal(err, "Failed to get leaderboard"))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"date":    date.Format(dailyDateLayout),
+		"entries": entries,
+	})
+}
+
+// dailyChallengeQuestions returns the question set for date, choosing it on
+// first use. The pick is seeded by the date, and saving it keeps the set
+// fixed for the rest of the day even if questions are added or removed.
+func dailyChallengeQuestions(ctx context.Context, db *sql.DB, date time.Time) (pq.Int64Array, error) {
+	day := date.Format(dailyDateLayout)
+
+	_, err := db.ExecContext(ctx, `
+		INSERT INTO daily_challenges (challenge_date, question_ids)
+		SELECT $1::date, array_agg(id ORDER BY
+			CASE difficulty WHEN 'easy' THEN 1 WHEN 'medium' THEN 2 ELSE 3 END, pick)
+		FROM (
+			SELECT id, difficulty,
+				ROW_NUMBER() OVER (PARTITION BY difficulty ORDER BY md5($3::text || ':' || id), id) AS pick
+			FROM questions
+		) ranked
+		WHERE pick <= $2
+		HAVING COUNT(*) > 0
+		ON CONFLICT (challenge_date) DO NOTHING`, day, dailyQuestionsPerDifficulty, day)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids pq.Int64Array
+	err = db.QueryRowContext(ctx, "SELECT question_ids FROM daily_challenges WHERE challenge_date = $1", day).Scan(&ids)
+	if err == sql.ErrNoRows {
+		// No questions exist yet, so nothing was saved
+		return nil, nil
+	}
+	return ids, err
+}
+
+// dailyStreak counts consecutive days with a finished daily challenge. The
+// current streak stays alive until a whole day is missed, so it still
+// counts yesterday's run before today's challenge is played.
+func dailyStreak(ctx context.Context, db *sql.DB, userID int, today time.Time) (models.DailyStreak, error) {
+	var streak models.DailyStreak
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT challenge_date FROM quiz_sessions
+		WHERE user_id = $1 AND mode = 'daily' AND status = 'finished'
+		ORDER BY challenge_date DESC`, userID)
+	if err != nil {
+		return streak, err
+	}
+	defer rows.Close()
+
+	run := 0
+	current := false
+	var previous time.Time
+	for rows.Next() {
+		var day time.Time
+		if err := rows.Scan(&day); err != nil {
+			return streak, err
+		}
+		day = time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, time.UTC)
+
+		if run > 0 && previous.AddDate(0, 0, -1).Equal(day) {
+			run++
+		} else {
+			// Only the most recent run can be current, and only if it
+			// reaches today or yesterday
+			current = run == 0 && !day.Before(today.AddDate(0, 0, -1))
+			run = 1
+		}
+		if current {
+			streak.Current = run
+		}
+		if run > streak.Longest {
+			streak.Longest = run
+		}
+		previous = day
+	}
+	return streak, rows.Err()
+}
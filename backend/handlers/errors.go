@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"errors"
+
+	"github.com/lib/pq"
+
+	"quiz-butterfly/backend/apierror"
+	"quiz-butterfly/backend/models"
+	"quiz-butterfly/backend/policy"
+)
+
+// policyError reports a username or password the credential policies
+// rejected, with every rule it broke
+func policyError(err error) error {
+	var policyErr *policy.ValidationError
+	if errors.As(err, &policyErr) {
+		problems := make([]models.FieldError, len(policyErr.Problems))
+		for i, p := range policyErr.Problems {
+			problems[i] = fieldError(p)
+		}
+		return apierror.Validation(problems...)
+	}
+	return apierror.Internal(err, "check credentials")
+}
+
+// fieldError reports a broken policy rule as a field error. Rules are
+// named like the field error codes, so they pass through unchanged.
+func fieldError(p policy.Problem) models.FieldError {
+	return apierror.Field(p.Field, p.Rule, p.Param)
+}
+
+// dbError classifies an error from a write. A unique or foreign key
+// violation means another request changed the same rows first, which the
+// client can retry; anything else is internal.
+func dbError(err error, op string) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code.Name() {
+		case "unique_violation", "foreign_key_violation":
+			e := apierror.Conflict(apierror.ConcurrentChange)
+			e.Cause = err
+			return e
+		}
+	}
+	return apierror.Internal(err, op)
+}
+
+// isUniqueViolation reports whether err is Postgres rejecting a duplicate
+// on a unique index
+func isUniqueViolation(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code.Name() == "unique_violation"
+}
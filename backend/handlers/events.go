@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+)
+
+const eventsHeartbeat = 25 * time.Second
+
+// EventsHandler streams change notifications as Server-Sent Events. The
+// client receives broadcast events (leaderboard and question changes) and
+// its own session events.
+func (h *Handler) EventsHandler(c *gin.Context) {
+	sub := h.events.Subscribe(c.GetInt("user_id"))
+	defer h.events.Unsubscribe(sub)
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	// Stop nginx and similar proxies from buffering the stream
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(eventsHeartbeat)
+	defer heartbeat.Stop()
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case ev, ok := <-sub.C:
+			if !ok {
+				return false
+			}
+			c.Render(-1, sse.Event{
+				Id:    strconv.FormatUint(ev.ID, 10),
+				Event: ev.Type,
+				Data:  ev,
+			})
+			return true
+		case <-heartbeat.C:
+			// Comment lines keep idle connections from being closed
+			_, err := io.WriteString(w, ": ping\n\n")
+			return err == nil
+		case <-c.Request.Context().Done():
+			return false
+		}
+	})
+}
@@ -0,0 +1,131 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"quiz-butterfly/backend/apierror"
+	"quiz-butterfly/backend/metrics"
+	"quiz-butterfly/backend/models"
+)
+
+// guestTokenTTL is how long a guest token stays valid. Guests that never
+// upgrade are removed by CleanupGuests.
+const guestTokenTTL = 12 * time.Hour
+
+// GuestLoginHandler creates an ephemeral guest user and returns a guest token
+func (h *Handler) GuestLoginHandler(c *gin.Context) {
+	db := h.db
+	ctx := c.Request.Context()
+
+	// Guests have no password; an empty hash never matches in bcrypt
+	user, err := createUser(ctx, db, "guest-"+randomString(6), nil, "", true)
+	if err != nil {
+		c.Error(apierror.Internal(err, "Failed to create guest"))
+		return
+	}
+
+	token, err := h.generateGuestToken(user.ID, user.Username)
+	if err != nil {
+		c.Error(apierror.Internal(err, "Failed to generate token"))
+		return
+	}
+
+	metrics.Login("guest", true)
+	c.JSON(http.StatusCreated, models.AuthResponse{User: user, Token: token})
+}
+
+// UpgradeGuestHandler turns the calling guest into a full account. The user
+// row is updated in place, so quiz sessions and high scores are kept.
+func (h *Handler) UpgradeGuestHandler(c *gin.Context) {
+	db := h.db
+	ctx := c.Request.Context()
+	userID := c.GetInt("user_id")
+
+	if !c.GetBool("is_guest") {
+		c.Error(apierror.Conflict(apierror.AlreadyRegistered))
+		return
+	}
+
+	var req models.GuestUpgradeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(apierror.Binding(err))
+		return
+	}
+
+	if err := h.usernames.Validate(req.Username); err != nil {
+		c.Error(policyError(err))
+		return
+	}
+
+	if err := h.passwords.Validate(req.Password, req.Username); err != nil {
+		c.Error(policyError(err))
+		return
+	}
+
+	username := strings.ToLower(req.Username)
+	var existingID int
+	err := db.QueryRowContext(ctx, "SELECT id FROM users WHERE username = $1", username).Scan(&existingID)
+	if err == nil {
+		c.Error(apierror.Conflict(apierror.UsernameTaken))
+		return
+	}
+
+	var email *string
+	if req.Email != "" {
+		normalized := strings.ToLower(strings.TrimSpace(req.Email))
+		email = &normalized
+
+		err = db.QueryRowContext(ctx, "SELECT id FROM users WHERE email = $1", normalized).Scan(&existingID)
+		if err == nil {
+			c.Error(apierror.Conflict(apierror.EmailTaken))
+			return
+		}
+	}
+
+	hashedPassword, err := hashPassword(req.Password)
+	if err != nil {
+		c.Error(apierror.Internal(err, "Failed to hash password"))
+		return
+	}
+
+	var user models.User
+	err = db.QueryRowContext(ctx, `
+		UPDATE users SET username = $1, email = $2, password_hash = $3, is_guest = FALSE
+		WHERE id = $4 AND is_guest
+		RETURNING id, username, email, is_guest, role, created_at, updated_at`,
+		username, email, hashedPassword, userID).Scan(
+		&user.ID, &user.Username, &user.Email, &user.IsGuest, &user.Role, &user.CreatedAt, &user.UpdatedAt)
+	if err == sql.ErrNoRows {
+		c.Error(apierror.Conflict(apierror.GuestNotFound))
+		return
+	}
+	if err != nil {
+		c.Error(dbError(err, "Failed to upgrade guest"))
+		return
+	}
+
+	token, err := h.generateToken(user.ID, user.Username)
+	if err != nil {
+		c.Error(apierror.Internal(err, "Failed to generate token"))
+		return
+	}
+
+	c.JSON(http.StatusOK, models.AuthResponse{User: user, Token: token, Warnings: h.passwordWarnings(c, req.Password)})
+}
+
+// CleanupGuests deletes guest users created before olderThan ago together
+// with their sessions and scores, and returns how many were removed
+func CleanupGuests(ctx context.Context, db *sql.DB, olderThan time.Duration) (int64, error) {
+	result, err := db.ExecContext(ctx, `
+		DELETE FROM users WHERE is_guest AND created_at < $1`, time.Now().Add(-olderThan))
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"quiz-butterfly/backend/config"
+	"quiz-butterfly/backend/events"
+	"quiz-butterfly/backend/live"
+	"quiz-butterfly/backend/mailer"
+	"quiz-butterfly/backend/policy"
+	"quiz-butterfly/backend/storage"
+)
+
+// Handler serves the API routes. It holds the settings and services the
+// handlers share, fixed when it is built by New.
+type Handler struct {
+	db        *sql.DB
+	jwtSecret []byte
+
+	// passwords and usernames are the rules new credentials must follow
+	passwords policy.PasswordPolicy
+	usernames policy.UsernamePolicy
+
+	mail     mailer.Mailer
+	resetURL string
+	resetTTL time.Duration
+	// pendingMail counts reset emails still being sent after their
+	// request returned
+	pendingMail sync.WaitGroup
+
+	// oidc is nil when OIDC login is off
+	oidc *oidcClient
+
+	blobs              storage.BlobStore
+	maxAttachmentBytes int64
+	// attachmentKey signs attachment links, which stay valid for
+	// attachmentURLTTL
+	attachmentKey    []byte
+	attachmentURLTTL time.Duration
+
+	// daily decides when a new daily challenge starts
+	daily  *time.Location
+	events *events.Bus
+	live   live.Hub
+	// liveUpgrader opens live room sockets from allowed origins only
+	liveUpgrader websocket.Upgrader
+}
+
+// Deps are the services a Handler works with
+type Deps struct {
+	DB *sql.DB
+	// Mailer sends reset emails; nil uses the one cfg.Mail describes
+	Mailer mailer.Mailer
+	// Blobs stores question attachments
+	Blobs storage.BlobStore
+	// Events carries change notifications; nil starts a bus of its own
+	Events *events.Bus
+	// Live holds live quiz rooms
+	Live live.Hub
+}
+
+// New builds a Handler from cfg, which config.Load has validated, and deps.
+// A breached password file that can't be read is logged and the bundled
+// list kept.
+func New(cfg *config.Config, deps Deps) (*Handler, error) {
+	loc, err := cfg.Daily.Location()
+	if err != nil {
+		return nil, fmt.Errorf("daily challenge time zone: %w", err)
+	}
+
+	h := &Handler{
+		db:                 deps.DB,
+		jwtSecret:          []byte(cfg.Auth.JWTSecret),
+		passwords:          policy.DefaultPasswordPolicy(),
+		usernames:          policy.DefaultUsernamePolicy(),
+		mail:               deps.Mailer,
+		resetURL:           cfg.PasswordReset.URL,
+		resetTTL:           cfg.PasswordReset.TTL,
+		blobs:              deps.Blobs,
+		maxAttachmentBytes: cfg.Attachments.MaxBytes,
+		attachmentKey:      attachmentKey([]byte(cfg.Auth.JWTSecret)),
+		attachmentURLTTL:   cfg.Attachments.URLTTL,
+		daily:              loc,
+		events:             deps.Events,
+		live:               deps.Live,
+		liveUpgrader:       newLiveUpgrader(cfg.Server),
+	}
+	if h.mail == nil {
+		h.mail = mailer.New(cfg.Mail)
+	}
+	if h.events == nil {
+		h.events = events.NewBus()
+	}
+
+	h.passwords.MinLength = cfg.Password.MinLength
+	h.passwords.RequireUpper = cfg.Password.RequireUpper
+	h.passwords.RequireLower = cfg.Password.RequireLower
+	h.passwords.RequireDigit = cfg.Password.RequireDigit
+	h.passwords.RequireSymbol = cfg.Password.RequireSymbol
+	if path := cfg.Password.BreachedFile; path != "" {
+		list, err := policy.LoadBreachedList(path)
+		if err != nil {
+			slog.Warn("Failed to load breached password list, using bundled list", "path", path, "error", err)
+		} else {
+			h.passwords.Breached = list
+		}
+	}
+	h.usernames.Reserve(cfg.Usernames.Reserved...)
+
+	if cfg.OIDC.Issuer != "" {
+		h.oidc = newOIDCClient(cfg.OIDC)
+	}
+	return h, nil
+}
@@ -1,44 +1,50 @@
 package handlers
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"log/slog"
 	"net/http"
 	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
-
-	"quiz-butterfly/backend/database"
+	"github.com/lib/pq"
+
+	"quiz-butterfly/backend/achievements"
+	"quiz-butterfly/backend/apierror"
+	"quiz-butterfly/backend/events"
+	"quiz-butterfly/backend/grading"
+	"quiz-butterfly/backend/locale"
+	"quiz-butterfly/backend/logging"
+	"quiz-butterfly/backend/metrics"
 	"quiz-butterfly/backend/models"
 )
 
-// ErrorResponse digunakan untuk respon error JSON
-type ErrorResponse struct {
-	Error string `json:"error"`
-}
-
 // GetProfileHandler returns user profile with high scores
-func GetProfileHandler(c *gin.Context) {
-	db := database.GetDB()
+func (h *Handler) GetProfileHandler(c *gin.Context) {
+	db := h.db
+	ctx := c.Request.Context()
 	userID := c.GetInt("user_id")
 
 	var user models.User
-	err := db.QueryRow(`
-		SELECT id, username, created_at, updated_at
-		FROM users WHERE id = $1`, userID).Scan(&user.ID, &user.Username, &user.CreatedAt, &user.UpdatedAt)
+	err := db.QueryRowContext(ctx, `
+		SELECT id, username, email, is_guest, role, locale, created_at, updated_at
+		FROM users WHERE id = $1`, userID).Scan(&user.ID, &user.Username, &user.Email, &user.IsGuest, &user.Role,
+		&user.Locale, &user.CreatedAt, &user.UpdatedAt)
 
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to get user"})
+		c.Error(apierror.Internal(err, "Failed to get user"))
 		return
 	}
 
 	// Get high scores
-	rows, err := db.Query(`
+	rows, err := db.QueryContext(ctx, `
 		SELECT difficulty, score FROM high_scores
 		WHERE user_id = $1 ORDER BY difficulty`, userID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to get high scores"})
+		c.Error(apierror.Internal(err, "Failed to get high scores"))
 		return
 	}
 	defer rows.Close()
@@ -47,29 +53,41 @@ func GetProfileHandler(c *gin.Context) {
 	for rows.Next() {
 		var hs models.HighScore
 		if err := rows.Scan(&hs.Difficulty, &hs.Score); err != nil {
-			continue
+			c.Error(apierror.Internal(err, "Failed to get high scores"))
+			return
 		}
 		hs.UserID = userID
 		highScores = append(highScores, hs)
 	}
+	if err := rows.Err(); err != nil {
+		c.Error(apierror.Internal(err, "Failed to get high scores"))
+		return
+	}
+
+	userAchievements, err := achievements.ForUser(ctx, db, userID)
+	if err != nil {
+		c.Error(apierror.Internal(err, "Failed to get achievements"))
+		return
+	}
 
-	c.JSON(http.StatusOK, models.UserProfile{User: user, HighScores: highScores})
+	c.JSON(http.StatusOK, models.UserProfile{User: user, HighScores: highScores, Achievements: userAchievements})
 }
 
-func GetQuestionsHandler(c *gin.Context) {
-	db := database.GetDB()
+func (h *Handler) GetQuestionsHandler(c *gin.Context) {
+	db := h.db
+	ctx := c.Request.Context()
 	difficulty := c.Param("difficulty")
 
 	if difficulty != "easy" && difficulty != "medium" && difficulty != "advance" {
-		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid difficulty level"})
+		c.Error(apierror.BadRequest(apierror.InvalidDifficulty))
 		return
 	}
 
-	rows, err := db.Query(`
-		SELECT id, question_text, options, correct_answer_index, reference, difficulty, created_at
+	rows, err := db.QueryContext(ctx, `
+		SELECT `+questionColumns+`
 		FROM questions WHERE difficulty = $1 ORDER BY id`, difficulty)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to get questions"})
+		c.Error(apierror.Internal(err, "Failed to get questions"))
 		return
 	}
 	defer rows.Close()
@@ -77,404 +95,722 @@ func GetQuestionsHandler(c *gin.Context) {
 	var questions []models.Question
 	for rows.Next() {
 		var q models.Question
-		if err := rows.Scan(&q.ID, &q.QuestionText, &q.Options, &q.CorrectAnswerIndex, &q.Reference, &q.Difficulty, &q.CreatedAt); err != nil {
-			continue
+		if err := scanQuestion(rows, &q); err != nil {
+			c.Error(apierror.Internal(err, "Failed to read questions"))
+			return
 		}
 		questions = append(questions, q)
 	}
+	if err := rows.Err(); err != nil {
+		c.Error(apierror.Internal(err, "Failed to read questions"))
+		return
+	}
+
+	if err := h.loadAttachments(ctx, db, questionPointers(questions)...); err != nil {
+		c.Error(apierror.Internal(err, "Failed to get question attachments"))
+		return
+	}
+	if err := localizeQuestions(ctx, db, requestLocale(c, db), questionPointers(questions)...); err != nil {
+		c.Error(apierror.Internal(err, "Failed to get question translations"))
+		return
+	}
 
-	c.JSON(http.StatusOK, questions)
+	players := make([]*models.PlayerQuestion, len(questions))
+	for i := range questions {
+		players[i] = questions[i].ForPlayer()
+	}
+	c.JSON(http.StatusOK, players)
 }
 
-func StartQuizHandler(c *gin.Context) {
-	db := database.GetDB()
+func (h *Handler) StartQuizHandler(c *gin.Context) {
+	db := h.db
+	ctx := c.Request.Context()
 	userID := c.GetInt("user_id")
 
 	var req models.QuizStartRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		c.Error(apierror.Binding(err))
+		return
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		c.Error(apierror.Internal(err, "Failed to start quiz"))
+		return
+	}
+	defer tx.Rollback()
+
+	if err := closePlayingSessions(ctx, tx, userID); err != nil {
+		c.Error(apierror.Internal(err, "Failed to start quiz"))
 		return
 	}
 
 	var session models.QuizSession
-	err := db.QueryRow(`
-		INSERT INTO quiz_sessions (user_id, difficulty, current_question_index, score, status)
-		VALUES ($1, $2, 0, 0, 'playing')
-		RETURNING id, user_id, difficulty, current_question_index, score, status, started_at, created_at`,
-		userID, req.Difficulty).Scan(&session.ID, &session.UserID, &session.Difficulty,
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO quiz_sessions (user_id, difficulty, mode, current_question_index, score, status)
+		VALUES ($1, $2, 'standard', 0, 0, 'playing')
+		RETURNING id, user_id, difficulty, mode, current_question_index, score, status, started_at, created_at`,
+		userID, req.Difficulty).Scan(&session.ID, &session.UserID, &session.Difficulty, &session.Mode,
 		&session.CurrentQuestionIndex, &session.Score, &session.Status, &session.StartedAt, &session.CreatedAt)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to start quiz"})
+		c.Error(apierror.Internal(err, "Failed to start quiz"))
 		return
 	}
+	logging.With(c, "session_id", session.ID)
 
-	var question models.Question
-	err = db.QueryRow(`
-		SELECT id, question_text, options, correct_answer_index, reference, difficulty, created_at
-		FROM questions WHERE difficulty = $1 ORDER BY id LIMIT 1`,
-		req.Difficulty).Scan(&question.ID, &question.QuestionText, &question.Options,
-		&question.CorrectAnswerIndex, &question.Reference, &question.Difficulty, &question.CreatedAt)
-
+	question, err := h.sessionQuestion(ctx, tx, &session, 0, requestLocale(c, tx))
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to get first question"})
+		c.Error(apierror.Internal(err, "Failed to get first question"))
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.Error(apierror.Internal(err, "Failed to start quiz"))
 		return
 	}
+	metrics.QuizStarted(session.Difficulty, session.Mode)
 
 	progress := models.QuizProgress{
 		SessionID:            session.ID,
+		Mode:                 session.Mode,
 		CurrentQuestionIndex: 0,
 		Score:                0,
 		Status:               "playing",
-		CurrentQuestion:      &question,
+		CurrentQuestion:      question.ForPlayer(),
 	}
 
 	c.JSON(http.StatusOK, progress)
 }
 
-func GetQuizProgressHandler(c *gin.Context) {
-	db := database.GetDB()
+func (h *Handler) GetQuizProgressHandler(c *gin.Context) {
+	db := h.db
+	ctx := c.Request.Context()
 	userID := c.GetInt("user_id")
 
 	var session models.QuizSession
-	err := db.QueryRow(`
-		SELECT id, user_id, difficulty, current_question_index, score, status, started_at, finished_at, created_at
+	err := db.QueryRowContext(ctx, `
+		SELECT id, user_id, difficulty, mode, question_ids, current_question_index, score, status, started_at, finished_at, created_at
 		FROM quiz_sessions WHERE user_id = $1 AND status = 'playing'
-		ORDER BY created_at DESC LIMIT 1`, userID).Scan(&session.ID, &session.UserID, &session.Difficulty,
-		&session.CurrentQuestionIndex, &session.Score, &session.Status, &session.StartedAt, &session.FinishedAt, &session.CreatedAt)
+		ORDER BY created_at DESC LIMIT 1`, userID).Scan(&session.ID, &session.UserID, &session.Difficulty, &session.Mode,
+		&session.QuestionIDs, &session.CurrentQuestionIndex, &session.Score, &session.Status, &session.StartedAt,
+		&session.FinishedAt, &session.CreatedAt)
 
 	if err == sql.ErrNoRows {
-		c.JSON(http.StatusNotFound, ErrorResponse{Error: "No active quiz session"})
+		c.Error(apierror.NotFound(apierror.NoActiveSession))
 		return
 	}
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to get quiz progress"})
+		c.Error(apierror.Internal(err, "Failed to get quiz progress"))
 		return
 	}
+	logging.With(c, "session_id", session.ID)
 
-	var question models.Question
-	err = db.QueryRow(`
-		SELECT id, question_text, options, correct_answer_index, reference, difficulty, created_at
-		FROM questions WHERE difficulty = $1 ORDER BY id LIMIT 1 OFFSET $2`,
-		session.Difficulty, session.CurrentQuestionIndex).Scan(&question.ID, &question.QuestionText, &question.Options,
-		&question.CorrectAnswerIndex, &question.Reference, &question.Difficulty, &question.CreatedAt)
-
+	loc := requestLocale(c, db)
 	progress := models.QuizProgress{
 		SessionID:            session.ID,
+		Mode:                 session.Mode,
+		TotalQuestions:       len(session.QuestionIDs),
 		CurrentQuestionIndex: session.CurrentQuestionIndex,
 		Score:                session.Score,
 		Status:               session.Status,
 	}
 
+	// Past the last question there is none to show until the quiz is finished
+	question, err := h.sessionQuestion(ctx, db, &session, session.CurrentQuestionIndex, loc)
 	if err == nil {
-		progress.CurrentQuestion = &question
+		progress.CurrentQuestion = question.ForPlayer()
+	} else if err != sql.ErrNoRows {
+		c.Error(apierror.Internal(err, "Failed to get current question"))
+		return
 	}
 
-	rows, err := db.Query(`
-		SELECT question_text, user_answer, correct_answer, is_correct, reference, answered_at
-		FROM user_answers WHERE quiz_session_id = $1 ORDER BY answered_at`, session.ID)
-	if err == nil {
-		defer rows.Close()
-		var answers []models.UserAnswer
-		for rows.Next() {
-			var ans models.UserAnswer
-			rows.Scan(&ans.QuestionText, &ans.UserAnswer, &ans.CorrectAnswer, &ans.IsCorrect, &ans.Reference, &ans.AnsweredAt)
-			answers = append(answers, ans)
+	// Question text and answers are stored as shown; the explanation is
+	// looked up in the language asked for now
+	rows, err := db.QueryContext(ctx, `
+		SELECT ua.question_text, ua.user_answer, ua.correct_answer, ua.is_correct, ua.credit,
+			COALESCE(ua.reference, ''), COALESCE(NULLIF(t.explanation, ''), q.explanation, ''), ua.answered_at
+		FROM user_answers ua
+		LEFT JOIN questions q ON q.id = ua.question_id
+		LEFT JOIN question_translations t ON t.question_id = ua.question_id AND t.locale = $2
+		WHERE ua.quiz_session_id = $1 ORDER BY ua.answered_at`, session.ID, loc)
+	if err != nil {
+		c.Error(apierror.Internal(err, "Failed to get answers"))
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var ans models.UserAnswer
+		if err := rows.Scan(&ans.QuestionText, &ans.UserAnswer, &ans.CorrectAnswer, &ans.IsCorrect, &ans.Credit,
+			&ans.Reference, &ans.Explanation, &ans.AnsweredAt); err != nil {
+			c.Error(apierror.Internal(err, "Failed to get answers"))
+			return
 		}
-		progress.UserAnswers = answers
+		progress.UserAnswers = append(progress.UserAnswers, ans)
+	}
+	if err := rows.Err(); err != nil {
+		c.Error(apierror.Internal(err, "Failed to get answers"))
+		return
 	}
 
 	c.JSON(http.StatusOK, progress)
 }
 
-func SubmitAnswerHandler(c *gin.Context) {
-	db := database.GetDB()
+func (h *Handler) SubmitAnswerHandler(c *gin.Context) {
+	db := h.db
+	ctx := c.Request.Context()
 	userID := c.GetInt("user_id")
 
 	var req models.QuizAnswerRequest
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		c.Error(apierror.Binding(err))
 		return
 	}
 
 	var session models.QuizSession
-	err := db.QueryRow(`
-		SELECT id, difficulty, current_question_index, score
-		FROM quiz_sessions WHERE user_id = $1 AND status = 'playing'`, userID).Scan(
-		&session.ID, &session.Difficulty, &session.CurrentQuestionIndex, &session.Score)
+	err := db.QueryRowContext(ctx, `
+		SELECT id, difficulty, mode, question_ids, current_question_index, score
+		FROM quiz_sessions WHERE user_id = $1 AND status = 'playing'
+		ORDER BY created_at DESC LIMIT 1`, userID).Scan(
+		&session.ID, &session.Difficulty, &session.Mode, &session.QuestionIDs, &session.CurrentQuestionIndex, &session.Score)
+	if err == sql.ErrNoRows {
+		c.Error(apierror.NotFound(apierror.NoActiveSession))
+		return
+	}
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "No active quiz session"})
+		c.Error(apierror.Internal(err, "Failed to get quiz session"))
+		return
+	}
+	logging.With(c, "session_id", session.ID)
+
+	// Only the question the session is on can be answered, and only once
+	currentID, err := currentQuestionID(ctx, db, &session)
+	if err == sql.ErrNoRows {
+		c.Error(apierror.Conflict(apierror.QuizComplete))
+		return
+	}
+	if err != nil {
+		c.Error(apierror.Internal(err, "Failed to get quiz session"))
+		return
+	}
+	if req.QuestionID != currentID {
+		c.Error(apierror.Conflict(apierror.NotCurrentQuestion))
 		return
 	}
 
 	var question models.Question
-	err = db.QueryRow(`
-		SELECT id, question_text, options, correct_answer_index, reference
-		FROM questions WHERE id = $1`, req.QuestionID).Scan(
-		&question.ID, &question.QuestionText, &question.Options, &question.CorrectAnswerIndex, &question.Reference)
+	err = scanQuestion(db.QueryRowContext(ctx, `
+		SELECT `+questionColumns+`
+		FROM questions WHERE id = $1`, req.QuestionID), &question)
+	if err == sql.ErrNoRows {
+		c.Error(apierror.BadRequest(apierror.InvalidQuestionID))
+		return
+	}
 	if err != nil {
-		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid question ID"})
+		c.Error(apierror.Internal(err, "Failed to get question"))
 		return
 	}
 
-	isCorrect := req.Answer == question.Options[question.CorrectAnswerIndex]
-	correctAnswer := question.Options[question.CorrectAnswerIndex]
-	score := session.Score
-	if isCorrect {
-		score++
+	answer := grading.Answer{Text: req.Answer, Choices: req.Answers}
+	result, err := gradeAnyLocale(ctx, db, question, answer)
+	if err != nil {
+		c.Error(apierror.Internal(err, "Failed to grade answer"))
+		return
 	}
+	score := session.Score + result.Credit
 
-	_, err = db.Exec(`
-		INSERT INTO user_answers (quiz_session_id, question_id, question_text, user_answer, correct_answer, is_correct, reference)
-		VALUES ($1, $2, $3, $4, $5, $6, $7)`,
-		session.ID, req.QuestionID, question.QuestionText, req.Answer, correctAnswer, isCorrect, question.Reference)
+	// The answer is recorded and explained in the language it was shown in
+	loc := requestLocale(c, db)
+	if err := localizeQuestions(ctx, db, loc, &question); err != nil {
+		c.Error(apierror.Internal(err, "Failed to get question translations"))
+		return
+	}
+
+	// Moving the session on only if it is still on this question keeps a
+	// repeated or concurrent submit from being counted twice
+	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to save answer"})
+		c.Error(apierror.Internal(err, "Failed to save answer"))
 		return
 	}
+	defer tx.Rollback()
 
-	session.CurrentQuestionIndex++
-	_, err = db.Exec(`
-		UPDATE quiz_sessions SET current_question_index = $1, score = $2
-		WHERE id = $3`, session.CurrentQuestionIndex, score, session.ID)
+	res, err := tx.ExecContext(ctx, `
+		UPDATE quiz_sessions SET current_question_index = current_question_index + 1, score = $1
+		WHERE id = $2 AND current_question_index = $3 AND status = 'playing'`,
+		score, session.ID, session.CurrentQuestionIndex)
+	if err != nil {
+		c.Error(apierror.Internal(err, "Failed to update session"))
+		return
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		c.Error(apierror.Internal(err, "Failed to update session"))
+		return
+	} else if n == 0 {
+		c.Error(apierror.Conflict(apierror.NotCurrentQuestion))
+		return
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO user_answers (quiz_session_id, question_id, question_text, user_answer, correct_answer, is_correct, credit, reference)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		session.ID, req.QuestionID, question.QuestionText, answer.String(), grading.CorrectAnswer(question),
+		result.Correct, result.Credit, question.Reference)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to update session"})
+		c.Error(apierror.Internal(err, "Failed to save answer"))
 		return
 	}
 
-	var nextQuestion models.Question
-	err = db.QueryRow(`
-		SELECT id, question_text, options, correct_answer_index, reference, difficulty, created_at
-		FROM questions WHERE difficulty = $1 ORDER BY id LIMIT 1 OFFSET $2`,
-		session.Difficulty, session.CurrentQuestionIndex).Scan(&nextQuestion.ID, &nextQuestion.QuestionText,
-		&nextQuestion.Options, &nextQuestion.CorrectAnswerIndex, &nextQuestion.Reference,
-		&nextQuestion.Difficulty, &nextQuestion.CreatedAt)
+	if err := tx.Commit(); err != nil {
+		c.Error(apierror.Internal(err, "Failed to save answer"))
+		return
+	}
+	metrics.Answer(result.Correct)
+	session.CurrentQuestionIndex++
+
+	if err := h.loadAttachments(ctx, db, &question); err != nil {
+		c.Error(apierror.Internal(err, "Failed to get question attachments"))
+		return
+	}
+	feedback := &models.AnswerFeedback{
+		QuestionID:    question.ID,
+		IsCorrect:     result.Correct,
+		Credit:        result.Credit,
+		CorrectAnswer: grading.CorrectAnswer(question),
+		Reference:     question.Reference,
+		Explanation:   question.Explanation,
+		Attachments:   question.Attachments,
+	}
+
+	nextQuestion, err := h.sessionQuestion(ctx, db, &session, session.CurrentQuestionIndex, loc)
 
 	progress := models.QuizProgress{
 		SessionID:            session.ID,
+		Mode:                 session.Mode,
+		TotalQuestions:       len(session.QuestionIDs),
 		CurrentQuestionIndex: session.CurrentQuestionIndex,
 		Score:                score,
 		Status:               "playing",
+		LastAnswer:           feedback,
 	}
 	if err == nil {
-		progress.CurrentQuestion = &nextQuestion
-	} else {
+		progress.CurrentQuestion = nextQuestion.ForPlayer()
+	} else if err == sql.ErrNoRows {
 		progress.Status = "finished"
+	} else {
+		c.Error(apierror.Internal(err, "Failed to get next question"))
+		return
 	}
 
+	progress.NewAchievements = h.awardAchievements(ctx, userID, achievements.OnAnswer)
+
 	c.JSON(http.StatusOK, progress)
 }
 
-func FinishQuizHandler(c *gin.Context) {
-	db := database.GetDB()
+func (h *Handler) FinishQuizHandler(c *gin.Context) {
+	db := h.db
+	ctx := c.Request.Context()
 	userID := c.GetInt("user_id")
 
 	var session models.QuizSession
-	err := db.QueryRow(`
-		SELECT id, difficulty, score FROM quiz_sessions
-		WHERE user_id = $1 AND status = 'playing'`, userID).Scan(&session.ID, &session.Difficulty, &session.Score)
+	err := db.QueryRowContext(ctx, `
+		SELECT id, difficulty, mode, challenge_date, score FROM quiz_sessions
+		WHERE user_id = $1 AND status = 'playing'
+		ORDER BY created_at DESC LIMIT 1`, userID).Scan(&session.ID, &session.Difficulty, &session.Mode,
+		&session.ChallengeDate, &session.Score)
+	if err == sql.ErrNoRows {
+		c.Error(apierror.NotFound(apierror.NoActiveSession))
+		return
+	}
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "No active quiz session"})
+		c.Error(apierror.Internal(err, "Failed to get quiz session"))
 		return
 	}
+	logging.With(c, "session_id", session.ID)
+
+	// The session only counts as finished once its score is recorded, so a
+	// failure part way leaves it playing and the finish can be retried
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		c.Error(apierror.Internal(err, "Failed to finish quiz"))
+		return
+	}
+	defer tx.Rollback()
 
 	now := time.Now()
-	_, err = db.Exec(`
+	_, err = tx.ExecContext(ctx, `
 		UPDATE quiz_sessions SET status = 'finished', finished_at = $1
 		WHERE id = $2`, now, session.ID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to finish quiz"})
+		c.Error(apierror.Internal(err, "Failed to finish quiz"))
 		return
 	}
 
-	_, err = db.Exec(`
-		UPDATE high_scores SET score = GREATEST(score, $1), created_at = $2
-		WHERE user_id = $3 AND difficulty = $4`, session.Score, now, userID, session.Difficulty)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to update high score"})
+	// Daily challenges mix difficulties and rank on their own leaderboard,
+	// so only standard sessions count toward high scores
+	var highScore, previousHighScore float64
+	if session.Mode == modeStandard {
+		highScore, previousHighScore, err = h.recordScore(ctx, tx, userID, session.Difficulty, session.Score, now)
+		if err != nil {
+			c.Error(apierror.Internal(err, "Failed to record score"))
+			return
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.Error(apierror.Internal(err, "Failed to finish quiz"))
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{
-		"message":     "Quiz finished successfully",
-		"final_score": session.Score,
+	metrics.QuizFinished(session.Difficulty, session.Mode)
+
+	finished := gin.H{
+		"session_id":  session.ID,
+		"mode":        session.Mode,
 		"difficulty":  session.Difficulty,
+		"final_score": session.Score,
+	}
+	if session.ChallengeDate != nil {
+		finished["challenge_date"] = session.ChallengeDate.Format(dailyDateLayout)
+	}
+	h.events.Publish(events.Event{
+		Type:   events.SessionFinished,
+		UserID: userID,
+		Data:   finished,
+	})
+	if highScore != previousHighScore {
+		ev := events.Event{
+			Type: events.HighScoreUpdated,
+			Data: gin.H{
+				"user_id":    userID,
+				"username":   c.GetString("username"),
+				"difficulty": session.Difficulty,
+				"score":      highScore,
+			},
+		}
+		// Guests are not on the leaderboard, so only they hear about it
+		if c.GetBool("is_guest") {
+			ev.UserID = userID
+		}
+		h.events.Publish(ev)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":          "Quiz finished successfully",
+		"mode":             session.Mode,
+		"final_score":      session.Score,
+		"difficulty":       session.Difficulty,
+		"new_achievements": h.awardAchievements(ctx, userID, achievements.OnFinish),
 	})
 }
 
-// CreateQuestionHandler creates a new question (admin only)
-func CreateQuestionHandler(c *gin.Context) {
-	db := database.GetDB()
+// queryRower is satisfied by both *sql.DB and *sql.Tx
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
 
-	var req models.Question
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
-		return
+// questionColumns selects everything scanQuestion reads, in order
+const questionColumns = `id, question_text, question_type, options, correct_answer_index,
+	correct_answer_indices, numeric_answer, numeric_tolerance, accepted_answers,
+	COALESCE(reference, ''), COALESCE(explanation, ''), difficulty, locale, created_at`
+
+// rowScanner is satisfied by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanQuestion reads a row selected with questionColumns
+func scanQuestion(row rowScanner, q *models.Question) error {
+	return row.Scan(&q.ID, &q.QuestionText, &q.Type, &q.Options, &q.CorrectAnswerIndex,
+		&q.CorrectAnswerIndices, &q.NumericAnswer, &q.NumericTolerance, &q.AcceptedAnswers,
+		&q.Reference, &q.Explanation, &q.Difficulty, &q.Locale, &q.CreatedAt)
+}
+
+func questionPointers(questions []models.Question) []*models.Question {
+	ptrs := make([]*models.Question, len(questions))
+	for i := range questions {
+		ptrs[i] = &questions[i]
 	}
+	return ptrs
+}
 
-	// Validate difficulty
-	if req.Difficulty != "easy" && req.Difficulty != "medium" && req.Difficulty != "advance" {
-		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid difficulty level"})
-		return
+// insertQuestion saves a validated question and sets its ID and CreatedAt
+func insertQuestion(ctx context.Context, db queryRower, q *models.Question, createdAt time.Time) error {
+	options := q.Options
+	if options == nil {
+		// Numeric and short text questions have no options
+		options = pq.StringArray{}
+	}
+	return db.QueryRowContext(ctx, `
+		INSERT INTO questions (question_text, question_type, options, correct_answer_index,
+			correct_answer_indices, numeric_answer, numeric_tolerance, accepted_answers,
+			reference, explanation, difficulty, locale, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+		RETURNING id, created_at`,
+		q.QuestionText, q.Type, options, q.CorrectAnswerIndex,
+		q.CorrectAnswerIndices, q.NumericAnswer, q.NumericTolerance, q.AcceptedAnswers,
+		q.Reference, q.Explanation, q.Difficulty, q.Locale, createdAt).Scan(&q.ID, &q.CreatedAt)
+}
+
+// queryer is satisfied by both *sql.DB and *sql.Tx
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// dbtx is satisfied by both *sql.DB and *sql.Tx
+type dbtx interface {
+	queryRower
+	queryer
+}
+
+// sessionQuestion returns the question at index in the session. Sessions
+// with a fixed question list follow it; others walk every question of their
+// difficulty by ID. The question is shown in loc where translated. It
+// returns sql.ErrNoRows past the last question.
+func (h *Handler) sessionQuestion(ctx context.Context, db dbtx, session *models.QuizSession, index int, loc string) (*models.Question, error) {
+	var row *sql.Row
+	if len(session.QuestionIDs) > 0 {
+		if index >= len(session.QuestionIDs) {
+			return nil, sql.ErrNoRows
+		}
+		row = db.QueryRowContext(ctx, `
+			SELECT `+questionColumns+`
+			FROM questions WHERE id = $1`, session.QuestionIDs[index])
+	} else {
+		row = db.QueryRowContext(ctx, `
+			SELECT `+questionColumns+`
+			FROM questions WHERE difficulty = $1 ORDER BY id LIMIT 1 OFFSET $2`, session.Difficulty, index)
 	}
 
-	// Validate options array length
-	if len(req.Options) == 0 {
-		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Options cannot be empty"})
-		return
+	var q models.Question
+	if err := scanQuestion(row, &q); err != nil {
+		return nil, err
+	}
+	if err := h.loadAttachments(ctx, db, &q); err != nil {
+		return nil, err
 	}
+	if err := localizeQuestions(ctx, db, loc, &q); err != nil {
+		return nil, err
+	}
+	return &q, nil
+}
 
-	// Validate correct answer index
-	if req.CorrectAnswerIndex < 0 || req.CorrectAnswerIndex >= len(req.Options) {
-		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid correct answer index"})
-		return
+// currentQuestionID returns the ID of the question the session is on, or
+// sql.ErrNoRows once every question has been answered
+func currentQuestionID(ctx context.Context, db queryRower, session *models.QuizSession) (int, error) {
+	index := session.CurrentQuestionIndex
+	if len(session.QuestionIDs) > 0 {
+		if index >= len(session.QuestionIDs) {
+			return 0, sql.ErrNoRows
+		}
+		return int(session.QuestionIDs[index]), nil
 	}
+	var id int
+	err := db.QueryRowContext(ctx, `
+		SELECT id FROM questions WHERE difficulty = $1 ORDER BY id LIMIT 1 OFFSET $2`,
+		session.Difficulty, index).Scan(&id)
+	return id, err
+}
 
-	err := db.QueryRow(`
-		INSERT INTO questions (question_text, options, correct_answer_index, reference, difficulty, created_at)
-		VALUES ($1, $2, $3, $4, $5, $6)
-		RETURNING id, created_at`,
-		req.QuestionText, req.Options, req.CorrectAnswerIndex, req.Reference, req.Difficulty, time.Now()).Scan(&req.ID, &req.CreatedAt)
+// closePlayingSessions abandons any session the user left unfinished, so
+// the session being started is the only one answers can go to. Abandoned
+// sessions never count as finished: not for high scores, leaderboards,
+// achievements or assignments.
+func closePlayingSessions(ctx context.Context, tx *sql.Tx, userID int) error {
+	_, err := tx.ExecContext(ctx, `
+		UPDATE quiz_sessions SET status = 'abandoned', finished_at = NOW()
+		WHERE user_id = $1 AND status = 'playing'`, userID)
+	return err
+}
 
+// awardAchievements evaluates the achievement rules for trigger and
+// announces new badges. Failures are logged rather than failing the quiz
+// request that triggered them.
+func (h *Handler) awardAchievements(ctx context.Context, userID int, trigger achievements.Trigger) []models.Achievement {
+	awarded, err := achievements.Evaluate(ctx, h.db, userID, trigger, h.daily)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to create question"})
-		return
+		slog.Error("Failed to evaluate achievements", "user_id", userID, "error", err)
 	}
 
-	c.JSON(http.StatusCreated, req)
+	for _, a := range awarded {
+		h.events.Publish(events.Event{Type: events.AchievementAwarded, UserID: userID, Data: a})
+	}
+	return awarded
 }
 
-// UpdateQuestionHandler updates an existing question (admin only)
-func UpdateQuestionHandler(c *gin.Context) {
-	db := database.GetDB()
-	questionIDStr := c.Param("id")
-	questionID := 0
-
-	if _, err := fmt.Sscanf(questionIDStr, "%d", &questionID); err != nil {
-		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid question ID"})
-		return
-	}
+// CreateQuestionHandler creates a new question (admin only)
+func (h *Handler) CreateQuestionHandler(c *gin.Context) {
+	db := h.db
+	ctx := c.Request.Context()
 
 	var req models.Question
 	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, ErrorResponse{Error: err.Error()})
+		c.Error(apierror.Binding(err))
 		return
 	}
 
-	// Validate difficulty if provided
-	if req.Difficulty != "" && req.Difficulty != "easy" && req.Difficulty != "medium" && req.Difficulty != "advance" {
-		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid difficulty level"})
+	if problem := validateQuestion(&req); problem != nil {
+		c.Error(apierror.Validation(*problem))
 		return
 	}
 
-	// Validate options array length if provided
-	if req.Options != nil && len(req.Options) == 0 {
-		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Options cannot be empty"})
+	if !checkDuplicates(c, db, req.QuestionText, 0) {
 		return
 	}
 
-	// Validate correct answer index if options are provided
-	if req.Options != nil && (req.CorrectAnswerIndex < 0 || req.CorrectAnswerIndex >= len(req.Options)) {
-		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid correct answer index"})
+	if err := insertQuestion(ctx, db, &req, time.Now()); err != nil {
+		c.Error(dbError(err, "Failed to create question"))
 		return
 	}
 
-	// Build dynamic update query
-	setParts := []string{}
-	args := []interface{}{}
-	argCount := 1
+	h.events.Publish(events.Event{
+		Type: events.QuestionCreated,
+		Data: gin.H{"question_id": req.ID, "difficulty": req.Difficulty},
+	})
+
+	c.JSON(http.StatusCreated, req)
+}
 
-	if req.QuestionText != "" {
-		setParts = append(setParts, fmt.Sprintf("question_text = $%d", argCount))
-		args = append(args, req.QuestionText)
-		argCount++
+// validateQuestion checks a question about to be saved and returns the
+// first problem found, or nil if it is valid. The answer fields are
+// checked by the rules of the question's type.
+func validateQuestion(q *models.Question) *models.FieldError {
+	if strings.TrimSpace(q.QuestionText) == "" {
+		fe := apierror.Field("question_text", apierror.Required, "")
+		return &fe
 	}
 
-	if req.Options != nil {
-		setParts = append(setParts, fmt.Sprintf("options = $%d", argCount))
-		args = append(args, req.Options)
-		argCount++
+	// Validate difficulty
+	if q.Difficulty != "easy" && q.Difficulty != "medium" && q.Difficulty != "advance" {
+		fe := apierror.Field("difficulty", apierror.NotAllowed, "easy, medium, advance")
+		return &fe
 	}
 
-	if req.CorrectAnswerIndex >= 0 {
-		setParts = append(setParts, fmt.Sprintf("correct_answer_index = $%d", argCount))
-		args = append(args, req.CorrectAnswerIndex)
-		argCount++
+	if q.Locale == "" {
+		q.Locale = locale.Default
 	}
+	loc, ok := locale.Parse(q.Locale)
+	if !ok {
+		fe := apierror.Field("locale", apierror.NotAllowed, strings.Join(locale.Supported(), ", "))
+		return &fe
+	}
+	q.Locale = loc
 
-	if req.Reference != "" {
-		setParts = append(setParts, fmt.Sprintf("reference = $%d", argCount))
-		args = append(args, req.Reference)
-		argCount++
+	if p := grading.Validate(q); p != nil {
+		fe := apierror.Field(p.Field, p.Rule, p.Param)
+		return &fe
 	}
+	return nil
+}
+
+// UpdateQuestionHandler updates an existing question (admin only)
+func (h *Handler) UpdateQuestionHandler(c *gin.Context) {
+	db := h.db
+	ctx := c.Request.Context()
+	questionIDStr := c.Param("id")
+	questionID := 0
 
-	if req.Difficulty != "" {
-		setParts = append(setParts, fmt.Sprintf("difficulty = $%d", argCount))
-		args = append(args, req.Difficulty)
-		argCount++
+	if _, err := fmt.Sscanf(questionIDStr, "%d", &questionID); err != nil {
+		c.Error(apierror.BadRequest(apierror.InvalidQuestionID))
+		return
+	}
+
+	// Fields left out of the request keep their current values
+	var question models.Question
+	err := scanQuestion(db.QueryRowContext(ctx, `
+		SELECT `+questionColumns+`
+		FROM questions WHERE id = $1`, questionID), &question)
+	if err == sql.ErrNoRows {
+		c.Error(apierror.NotFound(apierror.QuestionNotFound))
+		return
+	}
+	if err != nil {
+		c.Error(apierror.Internal(err, "Failed to get question"))
+		return
 	}
+	previousText := question.QuestionText
 
-	if len(setParts) == 0 {
-		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "No fields to update"})
+	if err := c.ShouldBindJSON(&question); err != nil {
+		c.Error(apierror.Binding(err))
 		return
 	}
+	question.ID = questionID
 
-	setParts = append(setParts, fmt.Sprintf("updated_at = $%d", argCount))
-	args = append(args, time.Now())
-	argCount++
+	if problem := validateQuestion(&question); problem != nil {
+		c.Error(apierror.Validation(*problem))
+		return
+	}
 
-	query := fmt.Sprintf("UPDATE questions SET %s WHERE id = $%d", strings.Join(setParts, ", "), argCount)
-	args = append(args, questionID)
+	if question.QuestionText != previousText && !checkDuplicates(c, db, question.QuestionText, questionID) {
+		return
+	}
 
-	result, err := db.Exec(query, args...)
+	if question.Options == nil {
+		question.Options = pq.StringArray{}
+	}
+	result, err := db.ExecContext(ctx, `
+		UPDATE questions SET question_text = $1, question_type = $2, options = $3, correct_answer_index = $4,
+			correct_answer_indices = $5, numeric_answer = $6, numeric_tolerance = $7, accepted_answers = $8,
+			reference = $9, explanation = $10, difficulty = $11, locale = $12, updated_at = $13
+		WHERE id = $14`,
+		question.QuestionText, question.Type, question.Options, question.CorrectAnswerIndex,
+		question.CorrectAnswerIndices, question.NumericAnswer, question.NumericTolerance, question.AcceptedAnswers,
+		question.Reference, question.Explanation, question.Difficulty, question.Locale, time.Now(), questionID)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to update question"})
+		c.Error(dbError(err, "Failed to update question"))
 		return
 	}
 
 	rowsAffected, err := result.RowsAffected()
 	if err != nil || rowsAffected == 0 {
-		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Question not found"})
+		c.Error(apierror.NotFound(apierror.QuestionNotFound))
 		return
 	}
 
-	// Return updated question
-	var updatedQuestion models.Question
-	err = db.QueryRow(`
-		SELECT id, question_text, options, correct_answer_index, reference, difficulty, created_at
-		FROM questions WHERE id = $1`, questionID).Scan(&updatedQuestion.ID, &updatedQuestion.QuestionText,
-		&updatedQuestion.Options, &updatedQuestion.CorrectAnswerIndex, &updatedQuestion.Reference,
-		&updatedQuestion.Difficulty, &updatedQuestion.CreatedAt)
-
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to retrieve updated question"})
-		return
-	}
+	updatedQuestion := question
+	h.events.Publish(events.Event{
+		Type: events.QuestionUpdated,
+		Data: gin.H{"question_id": updatedQuestion.ID, "difficulty": updatedQuestion.Difficulty},
+	})
 
 	c.JSON(http.StatusOK, updatedQuestion)
 }
 
 // DeleteQuestionHandler deletes a question (admin only)
-func DeleteQuestionHandler(c *gin.Context) {
-	db := database.GetDB()
+func (h *Handler) DeleteQuestionHandler(c *gin.Context) {
+	db := h.db
+	ctx := c.Request.Context()
 	questionIDStr := c.Param("id")
 	questionID := 0
 
 	if _, err := fmt.Sscanf(questionIDStr, "%d", &questionID); err != nil {
-		c.JSON(http.StatusBadRequest, ErrorResponse{Error: "Invalid question ID"})
+		c.Error(apierror.BadRequest(apierror.InvalidQuestionID))
 		return
 	}
 
-	result, err := db.Exec("DELETE FROM questions WHERE id = $1", questionID)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, ErrorResponse{Error: "Failed to delete question"})
+	// Attachment rows go with the question; their blobs are removed after.
+	// The LEFT JOIN gives a question without attachments one NULL key,
+	// which is filtered out so the array comes back empty.
+	var blobKeys pq.StringArray
+	err := db.QueryRowContext(ctx, `
+		WITH deleted AS (DELETE FROM questions WHERE id = $1 RETURNING id)
+		SELECT COALESCE(array_agg(a.blob_key) FILTER (WHERE a.blob_key IS NOT NULL), '{}')
+		FROM deleted LEFT JOIN question_attachments a ON a.question_id = deleted.id
+		GROUP BY deleted.id`, questionID).Scan(&blobKeys)
+	if err == sql.ErrNoRows {
+		c.Error(apierror.NotFound(apierror.QuestionNotFound))
 		return
 	}
-
-	rowsAffected, err := result.RowsAffected()
-	if err != nil || rowsAffected == 0 {
-		c.JSON(http.StatusNotFound, ErrorResponse{Error: "Question not found"})
+	if err != nil {
+		c.Error(apierror.Internal(err, "Failed to delete question"))
 		return
 	}
+	h.deleteBlobs(c, blobKeys...)
+
+	h.events.Publish(events.Event{
+		Type: events.QuestionDeleted,
+		Data: gin.H{"question_id": questionID},
+	})
 
 	c.JSON(http.StatusOK, gin.H{"message": "Question deleted successfully"})
 }
@@ -0,0 +1,379 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+
+	"quiz-butterfly/backend/apierror"
+	"quiz-butterfly/backend/config"
+	"quiz-butterfly/backend/events"
+	"quiz-butterfly/backend/storage"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// newTestHandler builds a Handler with the default settings over a sqlmock
+// database. Postgres sends NUMERIC columns as text, so rows for them should
+// hold []byte.
+func newTestHandler(t *testing.T, deps Deps) (*Handler, sqlmock.Sqlmock) {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	cfg := config.Default()
+	cfg.Auth.JWTSecret = "test-secret"
+	deps.DB = db
+	h, err := New(cfg, deps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h, mock
+}
+
+// serve runs one request for target through handler, routed as route, as
+// the given user with the error middleware in front, and returns the
+// recorded response
+func serve(handler gin.HandlerFunc, userID int, method, route, target, body string) *httptest.ResponseRecorder {
+	router := gin.New()
+	router.Use(apierror.Middleware(), func(c *gin.Context) {
+		c.Set("user_id", userID)
+		c.Set("username", "alice")
+	})
+	router.Handle(method, route, handler)
+
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestFinishQuizRecordsFractionalHighScore(t *testing.T) {
+	bus := events.NewBus()
+	h, mock := newTestHandler(t, Deps{Events: bus})
+	sub := bus.Subscribe(1)
+
+	mock.ExpectQuery(`SELECT id, difficulty, mode, challenge_date, score FROM quiz_sessions`).
+		WithArgs(1).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "difficulty", "mode", "challenge_date", "score"}).
+			AddRow(42, "easy", modeStandard, nil, []byte("7.50")))
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE quiz_sessions SET status = 'finished'`).
+		WithArgs(sqlmock.AnyArg(), 42).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`UPDATE high_scores`).
+		WithArgs(7.5, sqlmock.AnyArg(), 1, "easy").
+		WillReturnRows(sqlmock.NewRows([]string{"score", "score"}).AddRow([]byte("7.50"), []byte("5.25")))
+	mock.ExpectExec(`INSERT INTO best_scores_by_day`).
+		WithArgs(1, "easy", sqlmock.AnyArg(), 7.5, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	rec := serve(h.FinishQuizHandler, 1, http.MethodPost, "/", "/", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+	}
+	var body struct {
+		FinalScore float64 `json:"final_score"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.FinalScore != 7.5 {
+		t.Errorf("final_score = %v, want 7.5", body.FinalScore)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+
+	var highScore any
+	timeout := time.After(time.Second)
+	for highScore == nil {
+		select {
+		case ev := <-sub.C:
+			if ev.Type == events.HighScoreUpdated {
+				highScore = ev.Data.(gin.H)["score"]
+			}
+		case <-timeout:
+			t.Fatal("no high_score_updated event")
+		}
+	}
+	if highScore != 7.5 {
+		t.Errorf("high score event score = %v, want 7.5", highScore)
+	}
+}
+
+func TestFinishQuizLeavesSessionPlayingWhenScoreFails(t *testing.T) {
+	h, mock := newTestHandler(t, Deps{})
+
+	mock.ExpectQuery(`SELECT id, difficulty, mode, challenge_date, score FROM quiz_sessions`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "difficulty", "mode", "challenge_date", "score"}).
+			AddRow(42, "easy", modeStandard, nil, []byte("3.00")))
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE quiz_sessions SET status = 'finished'`).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(`UPDATE high_scores`).
+		WillReturnError(sqlmock.ErrCancelled)
+	mock.ExpectRollback()
+
+	rec := serve(h.FinishQuizHandler, 1, http.MethodPost, "/", "/", "")
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+// questionColumnNames names the columns of questionColumns, for mocked rows
+func questionColumnNames() []string {
+	return []string{"id", "question_text", "question_type", "options", "correct_answer_index",
+		"correct_answer_indices", "numeric_answer", "numeric_tolerance", "accepted_answers",
+		"reference", "explanation", "difficulty", "locale", "created_at"}
+}
+
+func expectPlayingSession(mock sqlmock.Sqlmock, index int, questionIDs string) {
+	mock.ExpectQuery(`SELECT id, difficulty, mode, question_ids, current_question_index, score`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "difficulty", "mode", "question_ids", "current_question_index", "score"}).
+			AddRow(42, "mixed", "daily", questionIDs, index, []byte("1.00")))
+}
+
+func TestSubmitAnswerRejectsOtherQuestions(t *testing.T) {
+	tests := []struct {
+		name       string
+		index      int
+		questionID int
+		code       apierror.Code
+	}{
+		{"earlier question", 1, 7, apierror.NotCurrentQuestion},
+		{"later question", 1, 9, apierror.NotCurrentQuestion},
+		{"past the last question", 3, 9, apierror.QuizComplete},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, mock := newTestHandler(t, Deps{})
+			expectPlayingSession(mock, tt.index, "{7,8,9}")
+
+			rec := serve(h.SubmitAnswerHandler, 1, http.MethodPost, "/", "/",
+				fmt.Sprintf(`{"question_id": %d, "answer": "A"}`, tt.questionID))
+			if rec.Code != http.StatusConflict {
+				t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+			}
+			var body struct {
+				Code apierror.Code `json:"code"`
+			}
+			json.Unmarshal(rec.Body.Bytes(), &body)
+			if body.Code != tt.code {
+				t.Errorf("code = %q, want %q", body.Code, tt.code)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestSubmitAnswerCountsEachQuestionOnce(t *testing.T) {
+	h, mock := newTestHandler(t, Deps{})
+	expectPlayingSession(mock, 1, "{7,8,9}")
+	mock.ExpectQuery(`FROM questions WHERE id = \$1`).
+		WithArgs(8).
+		WillReturnRows(sqlmock.NewRows(questionColumnNames()).
+			AddRow(8, "2 + 2?", "single_choice", "{3,4}", 1, nil, nil, nil, nil, "", "", "easy", "en", time.Now()))
+	mock.ExpectQuery(`FROM question_translations WHERE question_id = \$1`).
+		WillReturnRows(sqlmock.NewRows([]string{"question_id"}))
+	mock.ExpectQuery(`SELECT locale FROM users`).
+		WillReturnRows(sqlmock.NewRows([]string{"locale"}).AddRow("en"))
+	// Another request moved the session on after it was read
+	mock.ExpectBegin()
+	mock.ExpectExec(`UPDATE quiz_sessions SET current_question_index = current_question_index \+ 1`).
+		WithArgs(2.0, 42, 1).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+
+	rec := serve(h.SubmitAnswerHandler, 1, http.MethodPost, "/", "/", `{"question_id": 8, "answer": "4"}`)
+	if rec.Code != http.StatusConflict {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPlayerQuestionsHideAnswers(t *testing.T) {
+	h, mock := newTestHandler(t, Deps{})
+	mock.ExpectQuery(`FROM questions WHERE difficulty = \$1 ORDER BY id`).
+		WithArgs("easy").
+		WillReturnRows(sqlmock.NewRows(questionColumnNames()).
+			AddRow(1, "2 + 2?", "single_choice", "{3,4}", 1, nil, nil, nil, nil, "Arithmetic", "Adding", "easy", "en", time.Now()).
+			AddRow(2, "Pick the primes", "multiple_select", "{2,3,4}", 0, "{0,1}", nil, nil, nil, "", "", "easy", "en", time.Now()).
+			AddRow(3, "g in m/s²?", "numeric", "{}", 0, nil, []byte("9.81"), []byte("0.01"), nil, "", "", "easy", "en", time.Now()).
+			AddRow(4, "Capital of France?", "short_text", "{}", 0, nil, nil, nil, "{Paris}", "", "", "easy", "en", time.Now()))
+	mock.ExpectQuery(`FROM question_attachments`).
+		WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectQuery(`SELECT locale FROM users`).
+		WillReturnRows(sqlmock.NewRows([]string{"locale"}).AddRow("en"))
+
+	rec := serve(h.GetQuestionsHandler, 1, http.MethodGet, "/:difficulty", "/easy", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+	}
+	var questions []map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &questions); err != nil {
+		t.Fatal(err)
+	}
+	if len(questions) != 4 {
+		t.Fatalf("got %d questions, want 4", len(questions))
+	}
+	for _, q := range questions {
+		for _, field := range []string{"correct_answer_index", "correct_answer_indices", "numeric_answer",
+			"numeric_tolerance", "accepted_answers", "reference", "explanation"} {
+			if _, ok := q[field]; ok {
+				t.Errorf("question %v sends %s", q["id"], field)
+			}
+		}
+	}
+}
+
+func TestQuizProgressReportsUnreadableAnswers(t *testing.T) {
+	h, mock := newTestHandler(t, Deps{})
+	mock.ExpectQuery(`FROM quiz_sessions WHERE user_id = \$1 AND status = 'playing'`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "difficulty", "mode", "question_ids",
+			"current_question_index", "score", "status", "started_at", "finished_at", "created_at"}).
+			AddRow(42, 1, "mixed", "daily", "{7}", 1, []byte("1.00"), "playing", time.Now(), nil, time.Now()))
+	mock.ExpectQuery(`SELECT locale FROM users`).
+		WillReturnRows(sqlmock.NewRows([]string{"locale"}).AddRow("en"))
+	mock.ExpectQuery(`FROM user_answers ua`).
+		WillReturnRows(sqlmock.NewRows([]string{"question_text", "user_answer", "correct_answer", "is_correct",
+			"credit", "reference", "explanation", "answered_at"}).
+			AddRow("2 + 2?", "4", "4", "not a bool", []byte("1.00"), "", "", time.Now()))
+
+	rec := serve(h.GetQuizProgressHandler, 1, http.MethodGet, "/", "/", "")
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDeleteQuestionWithoutAttachments(t *testing.T) {
+	h, mock := newTestHandler(t, Deps{})
+	// Postgres answers {NULL} for array_agg over the LEFT JOIN's one
+	// empty row, which pq.StringArray can't scan, unless it is filtered
+	mock.ExpectQuery(`SELECT COALESCE\(array_agg\(a.blob_key\) FILTER \(WHERE a.blob_key IS NOT NULL\), '\{\}'\)`).
+		WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"coalesce"}).AddRow([]byte("{}")))
+
+	rec := serve(h.DeleteQuestionHandler, 1, http.MethodDelete, "/:id", "/7", "")
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestLeaderboardReportsBrokenResults(t *testing.T) {
+	h, mock := newTestHandler(t, Deps{})
+	// The connection drops after the first row; a short list would look
+	// like a real leaderboard
+	mock.ExpectQuery(`FROM best_scores_by_day b JOIN users u`).
+		WithArgs("easy", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"user_id", "username", "score"}).
+			AddRow(1, "alice", 10).
+			AddRow(2, "bob", 8).
+			RowError(1, errors.New("connection reset")))
+
+	rec := serve(h.GetLeaderboardHandler, 1, http.MethodGet, "/:difficulty", "/easy", "")
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCreateClassRetriesJoinCodeCollision(t *testing.T) {
+	h, mock := newTestHandler(t, Deps{})
+	insert := `INSERT INTO classes \(teacher_id, name, join_code\)`
+	// Drivers wrapped for tracing may wrap the error too
+	mock.ExpectQuery(insert).
+		WillReturnError(fmt.Errorf("insert: %w", &pq.Error{Code: "23505"}))
+	mock.ExpectQuery(insert).
+		WithArgs(1, "Physics", sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "teacher_id", "name", "join_code", "created_at"}).
+			AddRow(4, 1, "Physics", "ABCD2345", time.Now()))
+
+	rec := serve(h.CreateClassHandler, 1, http.MethodPost, "/", "/", `{"name": " Physics "}`)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestAttachmentLinks(t *testing.T) {
+	blobs, err := storage.NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := blobs.Put(context.Background(), "k1.png", strings.NewReader("png")); err != nil {
+		t.Fatal(err)
+	}
+	h, mock := newTestHandler(t, Deps{Blobs: blobs})
+
+	signed := h.attachmentURL(7)
+	u, _ := url.Parse(signed)
+	q := u.Query()
+	q.Set("expires", strconv.FormatInt(time.Now().Add(-time.Minute).Unix(), 10))
+	expired := u.Path + "?" + q.Encode()
+	q = u.Query()
+	q.Set("signature", "forged")
+	forged := u.Path + "?" + q.Encode()
+	other := strings.Replace(signed, "/7?", "/8?", 1)
+
+	for name, target := range map[string]string{
+		"expired":               expired,
+		"forged":                forged,
+		"another attachment":    other,
+		"unsigned":              "/api/attachments/7",
+		"session token instead": "/api/attachments/7?token=abc",
+	} {
+		rec := serve(h.DownloadAttachmentHandler, 0, http.MethodGet, "/api/attachments/:id", target, "")
+		if rec.Code != http.StatusForbidden || responseCode(t, rec) != apierror.InvalidAttachmentLink {
+			t.Errorf("%s: status = %d, body %s", name, rec.Code, rec.Body)
+		}
+	}
+
+	mock.ExpectQuery(`SELECT blob_key, filename, content_type, size_bytes\s+FROM question_attachments WHERE id = \$1`).
+		WithArgs(7).
+		WillReturnRows(sqlmock.NewRows([]string{"blob_key", "filename", "content_type", "size_bytes"}).
+			AddRow("k1.png", "diagram.png", "image/png", 3))
+	rec := serve(h.DownloadAttachmentHandler, 0, http.MethodGet, "/api/attachments/:id", signed, "")
+	if rec.Code != http.StatusOK || rec.Body.String() != "png" {
+		t.Fatalf("signed link: status = %d, body %s", rec.Code, rec.Body)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"quiz-butterfly/backend/database"
+	"quiz-butterfly/backend/logging"
+)
+
+// readinessTimeout bounds the checks behind /readyz, so a hung database
+// fails the probe instead of stalling it
+const readinessTimeout = 2 * time.Second
+
+// LivenessHandler reports that the process is up and serving. It checks
+// nothing else, so an orchestrator only restarts the server when the
+// server itself is stuck, not when Postgres is down.
+func (h *Handler) LivenessHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// ReadinessHandler reports whether the server can take traffic: Postgres
+// answers and the current schema.sql has been applied, down to the columns
+// later changes added. Each check is listed with "ok"
+// or what is wrong, and any failure makes the response 503.
+func (h *Handler) ReadinessHandler(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), readinessTimeout)
+	defer cancel()
+
+	checks := gin.H{"database": "ok", "schema": "ok"}
+	ready := true
+	if err := database.Ping(ctx); err != nil {
+		logging.From(c).Warn("Readiness check failed", "check", "database", "error", err)
+		checks["database"] = "unreachable"
+		checks["schema"] = "unknown"
+		ready = false
+	} else if missing, err := database.MissingTables(ctx); err != nil {
+		logging.From(c).Warn("Readiness check failed", "check", "schema", "error", err)
+		checks["schema"] = "unknown"
+		ready = false
+	} else if len(missing) > 0 {
+		checks["schema"] = "pending migrations, missing tables: " + strings.Join(missing, ", ")
+		ready = false
+	} else if outdated, err := database.OutdatedColumns(ctx); err != nil {
+		logging.From(c).Warn("Readiness check failed", "check", "schema", "error", err)
+		checks["schema"] = "unknown"
+		ready = false
+	} else if len(outdated) > 0 {
+		checks["schema"] = "pending migrations, outdated columns: " + strings.Join(outdated, "; ")
+		ready = false
+	}
+
+	if !ready {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"status": "unavailable", "checks": checks})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "ok", "checks": checks})
+}
@@ -0,0 +1,127 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"quiz-butterfly/backend/apierror"
+	"quiz-butterfly/backend/models"
+)
+
+// GetLeaderboardHandler returns the top scores for a difficulty within
+// ?window=day|week|month|all (default all). Each user appears once with
+// their best score in the window; ties go to whoever reached it first.
+// Guest accounts are left out.
+func (h *Handler) GetLeaderboardHandler(c *gin.Context) {
+	db := h.db
+	ctx := c.Request.Context()
+	difficulty := c.Param("difficulty")
+
+	if difficulty != "easy" && difficulty != "medium" && difficulty != "advance" {
+		c.Error(apierror.BadRequest(apierror.InvalidDifficulty))
+		return
+	}
+
+	since, ok := h.leaderboardWindowStart(c.DefaultQuery("window", "all"), time.Now())
+	if !ok {
+		c.Error(apierror.BadRequest(apierror.InvalidWindow))
+		return
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT user_id, username, score FROM (
+			SELECT DISTINCT ON (b.user_id) b.user_id, u.username, b.score, b.achieved_at
+			FROM best_scores_by_day b JOIN users u ON u.id = b.user_id
+			WHERE b.difficulty = $1 AND ($2::date IS NULL OR b.day >= $2::date)
+				AND NOT u.is_guest AND b.score > 0
+			ORDER BY b.user_id, b.score DESC, b.achieved_at ASC
+		) best
+		ORDER BY score DESC, achieved_at ASC
+		LIMIT 10`, difficulty, since)
+	if err != nil {
+		c.Error(apierror.Internal(err, "Failed to get leaderboard"))
+		return
+	}
+	defer rows.Close()
+
+	entries := []models.LeaderboardEntry{}
+	for rows.Next() {
+		var entry models.LeaderboardEntry
+		if err := rows.Scan(&entry.UserID, &entry.Username, &entry.Score); err != nil {
+			c.Error(apierror.Internal(err, "Failed to get leaderboard"))
+			return
+		}
+		entry.Rank = len(entries) + 1
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		c.Error(apierror.Internal(err, "Failed to get leaderboard"))
+		return
+	}
+
+	c.JSON(http.StatusOK, entries)
+}
+
+// recordScore counts a finished standard session's score toward the user's
+// high score and the per-day best that windowed leaderboards are built
+// from, and returns the high score before and after. Every path that
+// finishes a standard session must call it in the same transaction, or
+// the two tables drift apart.
+func (h *Handler) recordScore(ctx context.Context, tx *sql.Tx, userID int, difficulty string, score float64, at time.Time) (highScore, previous float64, err error) {
+	// The subquery reads the row before the update, so the old score can be
+	// compared to tell whether the high score changed
+	err = tx.QueryRowContext(ctx, `
+		UPDATE high_scores hs SET score = GREATEST(hs.score, $1),
+			created_at = CASE WHEN $1 > hs.score THEN $2 ELSE hs.created_at END
+		FROM (SELECT id, score FROM high_scores WHERE user_id = $3 AND difficulty = $4 FOR UPDATE) old
+		WHERE hs.id = old.id
+		RETURNING hs.score, old.score`, score, at, userID, difficulty).Scan(&highScore, &previous)
+	if err != nil {
+		return 0, 0, fmt.Errorf("update high score: %w", err)
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO best_scores_by_day (user_id, difficulty, day, score, achieved_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (user_id, difficulty, day) DO UPDATE
+		SET score = EXCLUDED.score, achieved_at = EXCLUDED.achieved_at
+		WHERE best_scores_by_day.score < EXCLUDED.score`,
+		userID, difficulty, h.leaderboardDay(at), score, at)
+	if err != nil {
+		return 0, 0, fmt.Errorf("update best score of the day: %w", err)
+	}
+	return highScore, previous, nil
+}
+
+// leaderboardDay is the calendar day a score counts toward. Days follow
+// the daily challenge time zone so both reset together.
+func (h *Handler) leaderboardDay(t time.Time) string {
+	return t.In(h.daily).Format(dailyDateLayout)
+}
+
+// leaderboardWindowStart returns the first day included in window, or a
+// null date for all time. Weeks start on Monday.
+func (h *Handler) leaderboardWindowStart(window string, now time.Time) (sql.NullString, bool) {
+	local := now.In(h.daily)
+	today := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, time.UTC)
+
+	var start time.Time
+	switch window {
+	case "all":
+		return sql.NullString{}, true
+	case "day":
+		start = today
+	case "week":
+		start = today.AddDate(0, 0, -((int(today.Weekday()) + 6) % 7))
+	case "month":
+		start = today.AddDate(0, 0, 1-today.Day())
+	default:
+		return sql.NullString{}, false
+	}
+	return sql.NullString{String: start.Format(dailyDateLayout), Valid: true}, true
+}
@@ -0,0 +1,260 @@
+package handlers
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+
+	"quiz-butterfly/backend/apierror"
+	"quiz-butterfly/backend/config"
+	"quiz-butterfly/backend/grading"
+	"quiz-butterfly/backend/live"
+	"quiz-butterfly/backend/models"
+)
+
+const (
+	liveWriteWait  = 10 * time.Second
+	livePongWait   = 60 * time.Second
+	livePingPeriod = 50 * time.Second
+	liveMaxMessage = 4096
+)
+
+// newLiveUpgrader accepts sockets from the browser origins server allows
+// to call the API. Requests without an Origin header don't come from a
+// browser page and are let through; they still need a token.
+func newLiveUpgrader(server config.Server) websocket.Upgrader {
+	return websocket.Upgrader{
+		ReadBufferSize:  1024,
+		WriteBufferSize: 1024,
+		CheckOrigin: func(r *http.Request) bool {
+			origin := r.Header.Get("Origin")
+			return origin == "" || server.AllowsOrigin(origin)
+		},
+	}
+}
+
+// liveClientMessage is what players and the host send over the socket
+type liveClientMessage struct {
+	Type       string `json:"type"` // "next" (host) or "answer" (player)
+	QuestionID int    `json:"question_id"`
+	Answer     string `json:"answer"`
+	// Answers holds the chosen options of a multiple_select question
+	Answers []string `json:"answers"`
+}
+
+// CreateLiveRoomHandler opens a live room hosted by the caller
+func (h *Handler) CreateLiveRoomHandler(c *gin.Context) {
+	db := h.db
+	ctx := c.Request.Context()
+
+	var req models.LiveRoomCreateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(apierror.Binding(err))
+		return
+	}
+	if req.QuestionCount == 0 {
+		req.QuestionCount = 10
+	}
+	if req.QuestionTimeSeconds == 0 {
+		req.QuestionTimeSeconds = 20
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT `+questionColumns+`
+		FROM questions WHERE difficulty = $1 ORDER BY random() LIMIT $2`, req.Difficulty, req.QuestionCount)
+	if err != nil {
+		c.Error(apierror.Internal(err, "Failed to get questions"))
+		return
+	}
+	defer rows.Close()
+
+	var questions []models.Question
+	for rows.Next() {
+		var q models.Question
+		if err := scanQuestion(rows, &q); err != nil {
+			c.Error(apierror.Internal(err, "Failed to get questions"))
+			return
+		}
+		questions = append(questions, q)
+	}
+	if err := rows.Err(); err != nil {
+		c.Error(apierror.Internal(err, "Failed to get questions"))
+		return
+	}
+	if len(questions) == 0 {
+		c.Error(apierror.BadRequest(apierror.NoQuestions))
+		return
+	}
+	if err := h.loadAttachments(ctx, db, questionPointers(questions)...); err != nil {
+		c.Error(apierror.Internal(err, "Failed to get question attachments"))
+		return
+	}
+
+	host := live.Player{UserID: c.GetInt("user_id"), Username: c.GetString("username")}
+	pin, err := h.live.CreateRoom(live.RoomConfig{
+		Host:         host,
+		Difficulty:   req.Difficulty,
+		Questions:    questions,
+		QuestionTime: time.Duration(req.QuestionTimeSeconds) * time.Second,
+	})
+	if err != nil {
+		c.Error(apierror.Unavailable(apierror.LiveUnavailable))
+		return
+	}
+
+	c.JSON(http.StatusCreated, models.LiveRoomResponse{
+		PIN:                 pin,
+		Difficulty:          req.Difficulty,
+		QuestionCount:       len(questions),
+		QuestionTimeSeconds: req.QuestionTimeSeconds,
+	})
+}
+
+// LiveRoomSocketHandler upgrades to a WebSocket and joins the room with
+// the given PIN. Room events are pushed as JSON; the host sends
+// {"type":"next"} to advance and players send
+// {"type":"answer","question_id":1,"answer":"..."}.
+func (h *Handler) LiveRoomSocketHandler(c *gin.Context) {
+	player := live.Player{UserID: c.GetInt("user_id"), Username: c.GetString("username")}
+
+	sub, err := h.live.Subscribe(c.Param("pin"), player)
+	if err != nil {
+		switch {
+		case errors.Is(err, live.ErrRoomNotFound):
+			c.Error(apierror.NotFound(apierror.RoomNotFound))
+		case errors.Is(err, live.ErrGameStarted):
+			c.Error(apierror.Conflict(apierror.RoomStarted))
+		case errors.Is(err, live.ErrRoomFull):
+			c.Error(apierror.Conflict(apierror.RoomFull))
+		default:
+			c.Error(apierror.Unavailable(apierror.LiveUnavailable))
+		}
+		return
+	}
+
+	conn, err := h.liveUpgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		h.live.Unsubscribe(sub)
+		return
+	}
+
+	// Replies meant only for this client (such as rejected answers) go
+	// through the write pump too, since a connection allows one writer
+	replies := make(chan live.Event, 8)
+	go liveWritePump(conn, sub, replies)
+	h.liveReadPump(conn, c.Param("pin"), player, apierror.RequestLocale(c), sub, replies)
+}
+
+// liveReadPump handles client messages until the connection drops.
+// Rejected messages get an "error" event in loc, shaped like an HTTP error
+// body.
+func (h *Handler) liveReadPump(conn *websocket.Conn, pin string, player live.Player, loc string, sub *live.Subscription, replies chan<- live.Event) {
+	defer func() {
+		h.live.Unsubscribe(sub)
+		conn.Close()
+	}()
+
+	conn.SetReadLimit(liveMaxMessage)
+	conn.SetReadDeadline(time.Now().Add(livePongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(livePongWait))
+	})
+
+	for {
+		var msg liveClientMessage
+		if err := conn.ReadJSON(&msg); err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+				slog.Warn("Live room read failed", "pin", pin, "user_id", player.UserID, "error", err)
+			}
+			return
+		}
+
+		var err error
+		switch msg.Type {
+		case "next":
+			err = h.live.Advance(pin, player.UserID)
+		case "answer":
+			err = h.live.Answer(pin, player.UserID, msg.QuestionID, grading.Answer{Text: msg.Answer, Choices: msg.Answers})
+		default:
+			err = errLiveUnknownMessage
+		}
+		if err != nil {
+			code := liveErrorCode(err)
+			if code == apierror.InternalError {
+				slog.Error("Live room message failed", "pin", pin, "user_id", player.UserID, "type", msg.Type, "error", err)
+			}
+			select {
+			case replies <- live.Event{Type: "error", Data: models.ErrorResponse{Code: string(code), Error: apierror.Message(code, loc)}}:
+			default:
+			}
+		}
+	}
+}
+
+var errLiveUnknownMessage = errors.New("unknown message type")
+
+// liveErrorCode maps an error from the hub to the code sent to the client.
+// Anything unexpected is reported as internal so its text never reaches
+// the client.
+func liveErrorCode(err error) apierror.Code {
+	switch {
+	case errors.Is(err, errLiveUnknownMessage):
+		return apierror.LiveUnknownMessage
+	case errors.Is(err, live.ErrRoomNotFound):
+		return apierror.RoomNotFound
+	case errors.Is(err, live.ErrNotHost):
+		return apierror.LiveNotHost
+	case errors.Is(err, live.ErrGameStarted):
+		return apierror.RoomStarted
+	case errors.Is(err, live.ErrRoomFull):
+		return apierror.RoomFull
+	case errors.Is(err, live.ErrNotAccepting):
+		return apierror.LiveNotAccepting
+	case errors.Is(err, live.ErrAlreadyAnswered):
+		return apierror.LiveAlreadyAnswered
+	case errors.Is(err, live.ErrWrongQuestion):
+		return apierror.LiveWrongQuestion
+	case errors.Is(err, live.ErrNotPlayer):
+		return apierror.LiveHostCannotAnswer
+	case errors.Is(err, live.ErrHubClosed):
+		return apierror.LiveUnavailable
+	}
+	return apierror.InternalError
+}
+
+// liveWritePump forwards room events to the socket and keeps it alive
+func liveWritePump(conn *websocket.Conn, sub *live.Subscription, replies <-chan live.Event) {
+	ticker := time.NewTicker(livePingPeriod)
+	defer func() {
+		ticker.Stop()
+		conn.Close()
+	}()
+
+	for {
+		select {
+		case ev, ok := <-sub.Events:
+			conn.SetWriteDeadline(time.Now().Add(liveWriteWait))
+			if !ok {
+				conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+				return
+			}
+			if err := conn.WriteJSON(ev); err != nil {
+				return
+			}
+		case ev := <-replies:
+			conn.SetWriteDeadline(time.Now().Add(liveWriteWait))
+			if err := conn.WriteJSON(ev); err != nil {
+				return
+			}
+		case <-ticker.C:
+			conn.SetWriteDeadline(time.Now().Add(liveWriteWait))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"quiz-butterfly/backend/apierror"
+	"quiz-butterfly/backend/config"
+	"quiz-butterfly/backend/live"
+)
+
+func TestLiveErrorCodeHidesUnexpectedErrors(t *testing.T) {
+	tests := []struct {
+		err  error
+		want apierror.Code
+	}{
+		{live.ErrNotHost, apierror.LiveNotHost},
+		{fmt.Errorf("answer: %w", live.ErrAlreadyAnswered), apierror.LiveAlreadyAnswered},
+		{errLiveUnknownMessage, apierror.LiveUnknownMessage},
+		{live.ErrHubClosed, apierror.LiveUnavailable},
+		{errors.New("redis: connection refused at 10.0.0.5:6379"), apierror.InternalError},
+	}
+	for _, tt := range tests {
+		if got := liveErrorCode(tt.err); got != tt.want {
+			t.Errorf("liveErrorCode(%v) = %q, want %q", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestLiveUpgraderChecksOrigin(t *testing.T) {
+	upgrader := newLiveUpgrader(config.Server{CORSOrigins: []string{"https://quiz.example.com"}})
+	tests := []struct {
+		origin string
+		want   bool
+	}{
+		{"https://quiz.example.com", true},
+		{"https://evil.example.net", false},
+		// Not a browser page, such as a command line client
+		{"", true},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/live/rooms/123456/ws", nil)
+		if tt.origin != "" {
+			r.Header.Set("Origin", tt.origin)
+		}
+		if got := upgrader.CheckOrigin(r); got != tt.want {
+			t.Errorf("CheckOrigin(%q) = %v, want %v", tt.origin, got, tt.want)
+		}
+	}
+}
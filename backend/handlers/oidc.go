@@ -0,0 +1,349 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/oauth2"
+
+	"quiz-butterfly/backend/apierror"
+	"quiz-butterfly/backend/config"
+	"quiz-butterfly/backend/logging"
+	"quiz-butterfly/backend/metrics"
+	"quiz-butterfly/backend/models"
+)
+
+const (
+	oidcFlowCookie = "oidc_flow"
+	oidcFlowTTL    = 10 * time.Minute
+)
+
+// oidcClient discovers the provider lazily so the API still starts when
+// the identity provider is briefly unreachable
+type oidcClient struct {
+	cfg config.OIDC
+
+	mu       sync.Mutex
+	oauth    *oauth2.Config
+	verifier *oidc.IDTokenVerifier
+}
+
+func newOIDCClient(cfg config.OIDC) *oidcClient {
+	if len(cfg.Scopes) == 0 {
+		cfg.Scopes = []string{"profile", "email"}
+	}
+	return &oidcClient{cfg: cfg}
+}
+
+func (o *oidcClient) setup(ctx context.Context) (*oauth2.Config, *oidc.IDTokenVerifier, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if o.oauth != nil {
+		return o.oauth, o.verifier, nil
+	}
+
+	provider, err := oidc.NewProvider(ctx, o.cfg.Issuer)
+	if err != nil {
+		return nil, nil, fmt.Errorf("discover %s: %w", o.cfg.Issuer, err)
+	}
+
+	o.oauth = &oauth2.Config{
+		ClientID:     o.cfg.ClientID,
+		ClientSecret: o.cfg.ClientSecret,
+		RedirectURL:  o.cfg.RedirectURL,
+		Endpoint:     provider.Endpoint(),
+		Scopes:       append([]string{oidc.ScopeOpenID}, o.cfg.Scopes...),
+	}
+	o.verifier = provider.Verifier(&oidc.Config{ClientID: o.cfg.ClientID})
+	return o.oauth, o.verifier, nil
+}
+
+// oidcFlowClaims carries state, nonce and PKCE verifier between the login
+// redirect and the callback in a signed, short-lived cookie
+type oidcFlowClaims struct {
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+	jwt.RegisteredClaims
+}
+
+// oidcFlowKey derives the cookie signing key from the JWT secret so a flow
+// cookie can never be replayed as an API token
+func (h *Handler) oidcFlowKey() []byte {
+	sum := sha256.Sum256(append([]byte("oidc-flow:"), h.jwtSecret...))
+	return sum[:]
+}
+
+// OIDCLoginHandler redirects the browser to the identity provider
+func (h *Handler) OIDCLoginHandler(c *gin.Context) {
+	if h.oidc == nil {
+		c.Error(apierror.NotFound(apierror.OIDCDisabled))
+		return
+	}
+
+	oauthConfig, _, err := h.oidc.setup(c.Request.Context())
+	if err != nil {
+		logging.From(c).Error("OIDC setup failed", "error", err)
+		c.Error(apierror.Unavailable(apierror.OIDCUnavailable))
+		return
+	}
+
+	flow := oidcFlowClaims{
+		State:    randomString(16),
+		Nonce:    randomString(16),
+		Verifier: oauth2.GenerateVerifier(),
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(oidcFlowTTL)),
+		},
+	}
+	cookie, err := jwt.NewWithClaims(jwt.SigningMethodHS256, flow).SignedString(h.oidcFlowKey())
+	if err != nil {
+		c.Error(apierror.Internal(err, "Failed to start login"))
+		return
+	}
+
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(oidcFlowCookie, cookie, int(oidcFlowTTL.Seconds()), "/auth/oidc", "", c.Request.TLS != nil, true)
+
+	authURL := oauthConfig.AuthCodeURL(flow.State, oidc.Nonce(flow.Nonce), oauth2.S256ChallengeOption(flow.Verifier))
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// OIDCCallbackHandler finishes the login: it checks state, exchanges the
+// code with the PKCE verifier, verifies the ID token and signs the user in
+// with the usual JWT
+func (h *Handler) OIDCCallbackHandler(c *gin.Context) {
+	if h.oidc == nil {
+		c.Error(apierror.NotFound(apierror.OIDCDisabled))
+		return
+	}
+
+	if errCode := c.Query("error"); errCode != "" {
+		metrics.Login("oidc", false)
+		c.Error(apierror.Unauthorized(apierror.OIDCRejected, errCode))
+		return
+	}
+
+	cookie, err := c.Cookie(oidcFlowCookie)
+	if err != nil {
+		c.Error(apierror.BadRequest(apierror.OIDCStateExpired))
+		return
+	}
+	c.SetCookie(oidcFlowCookie, "", -1, "/auth/oidc", "", c.Request.TLS != nil, true)
+
+	var flow oidcFlowClaims
+	_, err = jwt.ParseWithClaims(cookie, &flow, func(token *jwt.Token) (interface{}, error) {
+		return h.oidcFlowKey(), nil
+	}, jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}))
+	if err != nil || flow.State == "" || c.Query("state") != flow.State {
+		c.Error(apierror.BadRequest(apierror.OIDCInvalidState))
+		return
+	}
+
+	ctx := c.Request.Context()
+	oauthConfig, verifier, err := h.oidc.setup(ctx)
+	if err != nil {
+		logging.From(c).Error("OIDC setup failed", "error", err)
+		c.Error(apierror.Unavailable(apierror.OIDCUnavailable))
+		return
+	}
+
+	oauthToken, err := oauthConfig.Exchange(ctx, c.Query("code"), oauth2.VerifierOption(flow.Verifier))
+	if err != nil {
+		metrics.Login("oidc", false)
+		c.Error(apierror.Unauthorized(apierror.OIDCExchangeFailed))
+		return
+	}
+
+	rawIDToken, ok := oauthToken.Extra("id_token").(string)
+	if !ok {
+		metrics.Login("oidc", false)
+		c.Error(apierror.Unauthorized(apierror.OIDCInvalidIDToken))
+		return
+	}
+
+	idToken, err := verifier.Verify(ctx, rawIDToken)
+	if err != nil || idToken.Nonce != flow.Nonce {
+		metrics.Login("oidc", false)
+		c.Error(apierror.Unauthorized(apierror.OIDCInvalidIDToken))
+		return
+	}
+
+	var claims struct {
+		Email             string `json:"email"`
+		EmailVerified     bool   `json:"email_verified"`
+		PreferredUsername string `json:"preferred_username"`
+	}
+	if err := idToken.Claims(&claims); err != nil {
+		metrics.Login("oidc", false)
+		c.Error(apierror.Unauthorized(apierror.OIDCInvalidIDToken))
+		return
+	}
+
+	user, err := h.findOrLinkOIDCUser(ctx, idToken.Issuer, idToken.Subject, claims.Email, claims.EmailVerified, claims.PreferredUsername)
+	if errors.Is(err, errOIDCLinkRequired) {
+		metrics.Login("oidc", false)
+		c.Error(apierror.Conflict(apierror.OIDCLinkRequired))
+		return
+	}
+	if err != nil {
+		c.Error(apierror.Internal(err, "OIDC account linking failed for "+idToken.Issuer+"/"+idToken.Subject))
+		return
+	}
+
+	token, err := h.generateToken(user.ID, user.Username)
+	if err != nil {
+		c.Error(apierror.Internal(err, "Failed to generate token"))
+		return
+	}
+
+	metrics.Login("oidc", true)
+	if h.oidc.cfg.FrontendURL != "" {
+		c.Redirect(http.StatusFound, h.oidc.cfg.FrontendURL+"#token="+url.QueryEscape(token))
+		return
+	}
+	c.JSON(http.StatusOK, models.AuthResponse{User: user, Token: token})
+}
+
+// errOIDCLinkRequired means the provider's email belongs to a local account
+// whose email hasn't been verified, so it can't be linked automatically
+var errOIDCLinkRequired = errors.New("email belongs to an unverified local account")
+
+// findOrLinkOIDCUser returns the user linked to issuer/subject. An unknown
+// identity is linked to the existing user with the same email when both
+// the provider and this server have verified it, or else to a newly
+// created user without a local password.
+func (h *Handler) findOrLinkOIDCUser(ctx context.Context, issuer, subject, email string, emailVerified bool, preferredUsername string) (models.User, error) {
+	db := h.db
+	email = strings.ToLower(strings.TrimSpace(email))
+
+	var user models.User
+	err := db.QueryRowContext(ctx, `
+		SELECT u.id, u.username, u.email, u.is_guest, u.role, u.created_at, u.updated_at
+		FROM user_identities i JOIN users u ON u.id = i.user_id
+		WHERE i.issuer = $1 AND i.subject = $2`, issuer, subject).Scan(
+		&user.ID, &user.Username, &user.Email, &user.IsGuest, &user.Role, &user.CreatedAt, &user.UpdatedAt)
+	if err == nil {
+		return user, nil
+	}
+	if err != sql.ErrNoRows {
+		return user, err
+	}
+
+	// A user created here must not be left without the identity that signs
+	// it in, so both are saved together
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return user, err
+	}
+	defer tx.Rollback()
+
+	found := false
+	if email != "" && emailVerified {
+		// Anyone can register a local account with someone else's email, so
+		// only an address proven through a reset link is trusted
+		var verified bool
+		err = tx.QueryRowContext(ctx, `
+			SELECT id, username, email, is_guest, role, created_at, updated_at, email_verified_at IS NOT NULL
+			FROM users WHERE email = $1`, email).Scan(
+			&user.ID, &user.Username, &user.Email, &user.IsGuest, &user.Role, &user.CreatedAt, &user.UpdatedAt, &verified)
+		if err != nil && err != sql.ErrNoRows {
+			return user, err
+		}
+		if err == nil && !verified {
+			return user, errOIDCLinkRequired
+		}
+		found = err == nil
+	}
+
+	if !found {
+		username, err := h.availableUsername(ctx, tx, preferredUsername, email)
+		if err != nil {
+			return user, err
+		}
+		var userEmail *string
+		if email != "" && emailVerified {
+			userEmail = &email
+		}
+		// An empty hash never matches in bcrypt, so the account can only sign
+		// in through the provider until a password is set via reset
+		user, err = insertUser(ctx, tx, username, userEmail, "", false)
+		if err != nil {
+			return user, err
+		}
+		if userEmail != nil {
+			_, err = tx.ExecContext(ctx, "UPDATE users SET email_verified_at = now() WHERE id = $1", user.ID)
+			if err != nil {
+				return user, err
+			}
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO user_identities (user_id, issuer, subject, email)
+		VALUES ($1, $2, $3, $4)`, user.ID, issuer, subject, email)
+	if err != nil {
+		return user, err
+	}
+	return user, tx.Commit()
+}
+
+var usernameUnsafeChars = regexp.MustCompile(`[^a-z0-9_.-]+`)
+
+// availableUsername derives a free username that passes the username policy
+// from the provider's preferred username or the email's local part
+func (h *Handler) availableUsername(ctx context.Context, db queryRower, preferred, email string) (string, error) {
+	base := strings.ToLower(preferred)
+	if base == "" {
+		base, _, _ = strings.Cut(email, "@")
+	}
+	base = strings.Trim(usernameUnsafeChars.ReplaceAllString(strings.ToLower(base), ""), "_.-")
+	if len(base) > 40 {
+		base = base[:40]
+	}
+	if h.usernames.Validate(base) != nil {
+		base = "user"
+	}
+
+	for i := 0; i < 100; i++ {
+		candidate := base
+		if i > 0 {
+			candidate = fmt.Sprintf("%s%d", base, i)
+		}
+		if h.usernames.Validate(candidate) != nil {
+			continue
+		}
+		var id int
+		err := db.QueryRowContext(ctx, "SELECT id FROM users WHERE username = $1", candidate).Scan(&id)
+		if err == sql.ErrNoRows {
+			return candidate, nil
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	return "", errors.New("no free username found")
+}
+
+func randomString(n int) string {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
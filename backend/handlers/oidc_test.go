@@ -0,0 +1,279 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+
+	"quiz-butterfly/backend/apierror"
+	"quiz-butterfly/backend/config"
+)
+
+const (
+	testClientID    = "quiz-butterfly"
+	testRedirectURL = "http://api.test/auth/oidc/callback"
+)
+
+// fakeIssuer is a minimal OpenID Connect provider: discovery, an authorize
+// endpoint that approves every request, a token endpoint that checks the
+// PKCE verifier, and the key that signs its ID tokens
+type fakeIssuer struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]authorization
+	// nonce replaces the requested nonce in ID tokens when set
+	nonce string
+}
+
+// authorization is what the provider remembers about an issued code
+type authorization struct {
+	challenge string
+	nonce     string
+}
+
+func newFakeIssuer(t *testing.T) *fakeIssuer {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &fakeIssuer{key: key, codes: make(map[string]authorization)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{
+			"issuer":                                p.URL,
+			"authorization_endpoint":                p.URL + "/authorize",
+			"token_endpoint":                        p.URL + "/token",
+			"jwks_uri":                              p.URL + "/keys",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		b64 := base64.RawURLEncoding.EncodeToString
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "RSA", "alg": "RS256", "use": "sig", "kid": "test",
+			"n": b64(key.N.Bytes()),
+			"e": b64(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/authorize", p.authorize)
+	mux.HandleFunc("/token", p.token)
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+	return p
+}
+
+func (p *fakeIssuer) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+		http.Error(w, "PKCE required", http.StatusBadRequest)
+		return
+	}
+	code := randomString(8)
+	p.mu.Lock()
+	p.codes[code] = authorization{challenge: q.Get("code_challenge"), nonce: q.Get("nonce")}
+	p.mu.Unlock()
+
+	back, _ := url.Parse(q.Get("redirect_uri"))
+	back.RawQuery = url.Values{"code": {code}, "state": {q.Get("state")}}.Encode()
+	http.Redirect(w, r, back.String(), http.StatusFound)
+}
+
+func (p *fakeIssuer) token(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	p.mu.Lock()
+	auth, ok := p.codes[r.PostForm.Get("code")]
+	delete(p.codes, r.PostForm.Get("code"))
+	nonce := p.nonce
+	p.mu.Unlock()
+
+	sum := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != auth.challenge {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{"error": "invalid_grant"}`))
+		return
+	}
+	if nonce == "" {
+		nonce = auth.nonce
+	}
+
+	now := time.Now()
+	idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":            p.URL,
+		"sub":            "student-1",
+		"aud":            testClientID,
+		"iat":            now.Unix(),
+		"exp":            now.Add(time.Minute).Unix(),
+		"nonce":          nonce,
+		"email":          "alice@school.test",
+		"email_verified": true,
+	})
+	idToken.Header["kid"] = "test"
+	signed, err := idToken.SignedString(p.key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"access_token": "access",
+		"token_type":   "Bearer",
+		"expires_in":   60,
+		"id_token":     signed,
+	})
+}
+
+// oidcLogin is one browser going through the login redirect: the flow
+// cookie it was given and the callback URL the provider sent it back to
+type oidcLogin struct {
+	cookie   *http.Cookie
+	callback *url.URL
+}
+
+func newOIDCTest(t *testing.T) (*fakeIssuer, *gin.Engine, sqlmock.Sqlmock) {
+	t.Helper()
+	issuer := newFakeIssuer(t)
+	h, mock := newTestHandler(t, Deps{})
+	h.oidc = newOIDCClient(config.OIDC{
+		Issuer:      issuer.URL,
+		ClientID:    testClientID,
+		RedirectURL: testRedirectURL,
+	})
+
+	router := gin.New()
+	router.Use(apierror.Middleware())
+	router.GET("/auth/oidc/login", h.OIDCLoginHandler)
+	router.GET("/auth/oidc/callback", h.OIDCCallbackHandler)
+	return issuer, router, mock
+}
+
+// startOIDCLogin follows the login redirect to the provider and back
+func startOIDCLogin(t *testing.T, router *gin.Engine) oidcLogin {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/auth/oidc/login", nil))
+	if rec.Code != http.StatusFound {
+		t.Fatalf("login: status = %d, body %s", rec.Code, rec.Body)
+	}
+	var login oidcLogin
+	for _, c := range rec.Result().Cookies() {
+		if c.Name == oidcFlowCookie {
+			login.cookie = c
+		}
+	}
+	if login.cookie == nil {
+		t.Fatal("login set no flow cookie")
+	}
+
+	noFollow := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := noFollow.Get(rec.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	login.callback, err = url.Parse(resp.Header.Get("Location"))
+	if err != nil || !strings.HasPrefix(login.callback.String(), testRedirectURL) {
+		t.Fatalf("provider redirected to %q", resp.Header.Get("Location"))
+	}
+	return login
+}
+
+// finish calls the callback with the login's cookie and the given query
+func (l oidcLogin) finish(router *gin.Engine, query url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/auth/oidc/callback?"+query.Encode(), nil)
+	req.AddCookie(l.cookie)
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+func responseCode(t *testing.T, rec *httptest.ResponseRecorder) apierror.Code {
+	t.Helper()
+	var body struct {
+		Code apierror.Code `json:"code"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &body)
+	return body.Code
+}
+
+func TestOIDCLogin(t *testing.T) {
+	issuer, router, mock := newOIDCTest(t)
+	mock.ExpectQuery(`FROM user_identities i JOIN users u`).
+		WithArgs(issuer.URL, "student-1").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "email", "is_guest", "role", "created_at", "updated_at"}).
+			AddRow(3, "alice", "alice@school.test", false, "student", time.Now(), time.Now()))
+
+	login := startOIDCLogin(t, router)
+	rec := login.finish(router, login.callback.Query())
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+	}
+	var body struct {
+		Token string `json:"token"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &body)
+	if body.Token == "" {
+		t.Errorf("no token in %s", rec.Body)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestOIDCCallbackRejectsWrongState(t *testing.T) {
+	_, router, _ := newOIDCTest(t)
+	login := startOIDCLogin(t, router)
+
+	query := login.callback.Query()
+	query.Set("state", "forged")
+	rec := login.finish(router, query)
+	if rec.Code != http.StatusBadRequest || responseCode(t, rec) != apierror.OIDCInvalidState {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+	}
+}
+
+func TestOIDCCallbackRejectsWrongNonce(t *testing.T) {
+	issuer, router, _ := newOIDCTest(t)
+	login := startOIDCLogin(t, router)
+
+	// An ID token minted for some other login
+	issuer.nonce = "replayed"
+	rec := login.finish(router, login.callback.Query())
+	if rec.Code != http.StatusUnauthorized || responseCode(t, rec) != apierror.OIDCInvalidIDToken {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+	}
+}
+
+func TestOIDCCallbackRejectsCodeFromAnotherLogin(t *testing.T) {
+	_, router, _ := newOIDCTest(t)
+	victim := startOIDCLogin(t, router)
+	attacker := startOIDCLogin(t, router)
+
+	// The victim's code under the attacker's own state and cookie: the
+	// state checks out, but the attacker's PKCE verifier doesn't match the
+	// challenge the code was issued for
+	query := attacker.callback.Query()
+	query.Set("code", victim.callback.Query().Get("code"))
+	rec := attacker.finish(router, query)
+	if rec.Code != http.StatusUnauthorized || responseCode(t, rec) != apierror.OIDCExchangeFailed {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+	}
+}
@@ -0,0 +1,208 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"quiz-butterfly/backend/apierror"
+	"quiz-butterfly/backend/logging"
+	"quiz-butterfly/backend/mailer"
+	"quiz-butterfly/backend/models"
+)
+
+// WaitForMail blocks until reset emails already queued have been sent, or
+// until ctx ends. It is called on shutdown after the server stops taking
+// requests, so no reset link is lost to a restart.
+func (h *Handler) WaitForMail(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		h.pendingMail.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// ForgotPasswordHandler emails a single-use reset token to the account
+// owning the given address. It always answers 200 so callers can't probe
+// which addresses are registered.
+func (h *Handler) ForgotPasswordHandler(c *gin.Context) {
+	db := h.db
+	ctx := c.Request.Context()
+
+	var req models.ForgotPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(apierror.Binding(err))
+		return
+	}
+
+	response := gin.H{"message": "If the email is registered, a reset link has been sent"}
+	email := strings.ToLower(strings.TrimSpace(req.Email))
+
+	var user models.User
+	err := db.QueryRowContext(ctx, "SELECT id, username FROM users WHERE email = $1", email).Scan(&user.ID, &user.Username)
+	if err == sql.ErrNoRows {
+		c.JSON(http.StatusOK, response)
+		return
+	}
+	if err != nil {
+		c.Error(apierror.Internal(err, "Failed to process request"))
+		return
+	}
+
+	token, tokenHash, err := generateResetToken()
+	if err != nil {
+		c.Error(apierror.Internal(err, "Failed to generate reset token"))
+		return
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		c.Error(apierror.Internal(err, "Failed to process request"))
+		return
+	}
+	defer tx.Rollback()
+
+	// Only the most recent token stays usable
+	_, err = tx.ExecContext(ctx, `
+		UPDATE password_reset_tokens SET used_at = now()
+		WHERE user_id = $1 AND used_at IS NULL`, user.ID)
+	if err != nil {
+		c.Error(apierror.Internal(err, "Failed to process request"))
+		return
+	}
+
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO password_reset_tokens (user_id, token_hash, expires_at)
+		VALUES ($1, $2, $3)`, user.ID, tokenHash, time.Now().Add(h.resetTTL))
+	if err != nil {
+		c.Error(apierror.Internal(err, "Failed to process request"))
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.Error(apierror.Internal(err, "Failed to process request"))
+		return
+	}
+
+	msg := mailer.Message{
+		To:      email,
+		Subject: "Reset your Quiz Butterfly password",
+		Body: fmt.Sprintf("Hi %s,\n\nUse the link below to choose a new password. It expires in %s.\n\n%s?token=%s\n\nIf you did not ask for a reset you can ignore this email.\n",
+			user.Username, h.resetTTL, h.resetURL, token),
+	}
+
+	// Send outside the request so response time doesn't reveal whether the address exists
+	logger := logging.From(c)
+	h.pendingMail.Add(1)
+	go func() {
+		defer h.pendingMail.Done()
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		if err := h.mail.Send(ctx, msg); err != nil {
+			logger.Error("Failed to send password reset email", "user_id", user.ID, "error", err)
+		}
+	}()
+
+	c.JSON(http.StatusOK, response)
+}
+
+// ResetPasswordHandler sets a new password using a reset token
+func (h *Handler) ResetPasswordHandler(c *gin.Context) {
+	db := h.db
+	ctx := c.Request.Context()
+
+	var req models.ResetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(apierror.Binding(err))
+		return
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		c.Error(apierror.Internal(err, "Failed to reset password"))
+		return
+	}
+	defer tx.Rollback()
+
+	var tokenID, userID int
+	var username string
+	err = tx.QueryRowContext(ctx, `
+		SELECT t.id, t.user_id, u.username
+		FROM password_reset_tokens t JOIN users u ON u.id = t.user_id
+		WHERE t.token_hash = $1 AND t.used_at IS NULL AND t.expires_at > now()
+		FOR UPDATE OF t`, hashResetToken(req.Token)).Scan(&tokenID, &userID, &username)
+	if err == sql.ErrNoRows {
+		c.Error(apierror.BadRequest(apierror.InvalidResetToken))
+		return
+	}
+	if err != nil {
+		c.Error(apierror.Internal(err, "Failed to reset password"))
+		return
+	}
+
+	if err := h.passwords.Validate(req.Password, username); err != nil {
+		c.Error(policyError(err))
+		return
+	}
+
+	hashedPassword, err := hashPassword(req.Password)
+	if err != nil {
+		c.Error(apierror.Internal(err, "Failed to hash password"))
+		return
+	}
+
+	_, err = tx.ExecContext(ctx, "UPDATE password_reset_tokens SET used_at = now() WHERE id = $1", tokenID)
+	if err != nil {
+		c.Error(apierror.Internal(err, "Failed to reset password"))
+		return
+	}
+
+	// The token arrived by email, so using it also proves the address
+	_, err = tx.ExecContext(ctx, `
+		UPDATE users SET password_hash = $1, email_verified_at = COALESCE(email_verified_at, now())
+		WHERE id = $2`, hashedPassword, userID)
+	if err != nil {
+		c.Error(apierror.Internal(err, "Failed to reset password"))
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.Error(apierror.Internal(err, "Failed to reset password"))
+		return
+	}
+
+	resp := gin.H{"message": "Password has been reset successfully"}
+	if warnings := h.passwordWarnings(c, req.Password); len(warnings) > 0 {
+		resp["warnings"] = warnings
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// generateResetToken returns a random token for the user and its hash for storage
+func generateResetToken() (string, string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token := hex.EncodeToString(b)
+	return token, hashResetToken(token), nil
+}
+
+func hashResetToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,184 @@
+package handlers
+
+import (
+	"context"
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+
+	"quiz-butterfly/backend/apierror"
+	"quiz-butterfly/backend/mailer"
+)
+
+// fakeMailer keeps the messages it is asked to send
+type fakeMailer struct {
+	mu   sync.Mutex
+	sent []mailer.Message
+}
+
+func (m *fakeMailer) Send(ctx context.Context, msg mailer.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sent = append(m.sent, msg)
+	return nil
+}
+
+func (m *fakeMailer) messages() []mailer.Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]mailer.Message(nil), m.sent...)
+}
+
+// capture matches any argument and keeps it
+type capture struct{ value driver.Value }
+
+func (a *capture) Match(v driver.Value) bool {
+	a.value = v
+	return true
+}
+
+// within matches a time between from and to
+type within struct{ from, to time.Time }
+
+func (a within) Match(v driver.Value) bool {
+	t, ok := v.(time.Time)
+	return ok && !t.Before(a.from) && !t.After(a.to)
+}
+
+func TestPasswordResetTokenIsSingleUse(t *testing.T) {
+	mail := &fakeMailer{}
+	h, mock := newTestHandler(t, Deps{Mailer: mail})
+
+	tokenHash := &capture{}
+	now := time.Now()
+	mock.ExpectQuery(`SELECT id, username FROM users WHERE email = \$1`).
+		WithArgs("alice@example.com").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username"}).AddRow(1, "alice"))
+	mock.ExpectBegin()
+	// Tokens sent earlier stop working once a new one is issued
+	mock.ExpectExec(`UPDATE password_reset_tokens SET used_at = now\(\)\s+WHERE user_id = \$1 AND used_at IS NULL`).
+		WithArgs(1).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(`INSERT INTO password_reset_tokens`).
+		WithArgs(1, tokenHash, within{now.Add(h.resetTTL), time.Now().Add(h.resetTTL + time.Minute)}).
+		WillReturnResult(sqlmock.NewResult(5, 1))
+	mock.ExpectCommit()
+
+	rec := serve(h.ForgotPasswordHandler, 0, http.MethodPost, "/", "/", `{"email": "Alice@Example.com"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("forgot: status = %d, body %s", rec.Code, rec.Body)
+	}
+	if err := h.WaitForMail(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	sent := mail.messages()
+	if len(sent) != 1 || sent[0].To != "alice@example.com" {
+		t.Fatalf("sent %+v, want one message to alice@example.com", sent)
+	}
+
+	// The link carries the token; only its hash is stored
+	start := strings.Index(sent[0].Body, h.resetURL+"?")
+	if start < 0 {
+		t.Fatalf("no reset link in %q", sent[0].Body)
+	}
+	link, err := url.Parse(strings.Fields(sent[0].Body[start:])[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := link.Query().Get("token")
+	if token == "" || tokenHash.value != hashResetToken(token) {
+		t.Fatalf("stored hash %v does not match emailed token %q", tokenHash.value, token)
+	}
+
+	// Only an unused, unexpired token is found, and using it marks it used
+	lookup := `FROM password_reset_tokens t JOIN users u ON u.id = t.user_id\s+WHERE t.token_hash = \$1 AND t.used_at IS NULL AND t.expires_at > now\(\)`
+	newHash := &capture{}
+	mock.ExpectBegin()
+	mock.ExpectQuery(lookup).
+		WithArgs(hashResetToken(token)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "username"}).AddRow(5, 1, "alice"))
+	mock.ExpectExec(`UPDATE password_reset_tokens SET used_at = now\(\) WHERE id = \$1`).
+		WithArgs(5).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(`UPDATE users SET password_hash = \$1`).
+		WithArgs(newHash, 1).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	body := `{"token": "` + token + `", "password": "violet-kettle-42"}`
+	rec = serve(h.ResetPasswordHandler, 0, http.MethodPost, "/", "/", body)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("reset: status = %d, body %s", rec.Code, rec.Body)
+	}
+	if hash, _ := newHash.value.(string); checkPassword(hash, "violet-kettle-42") != nil {
+		t.Errorf("stored password hash %v does not match the new password", newHash.value)
+	}
+
+	// The same token again finds nothing
+	mock.ExpectBegin()
+	mock.ExpectQuery(lookup).
+		WithArgs(hashResetToken(token)).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "username"}))
+	mock.ExpectRollback()
+
+	rec = serve(h.ResetPasswordHandler, 0, http.MethodPost, "/", "/", body)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("reused token: status = %d, body %s", rec.Code, rec.Body)
+	}
+	var resp struct {
+		Code apierror.Code `json:"code"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	if resp.Code != apierror.InvalidResetToken {
+		t.Errorf("reused token: code = %q, want %q", resp.Code, apierror.InvalidResetToken)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestForgotPasswordForUnknownEmailSendsNothing(t *testing.T) {
+	mail := &fakeMailer{}
+	h, mock := newTestHandler(t, Deps{Mailer: mail})
+	mock.ExpectQuery(`SELECT id, username FROM users WHERE email = \$1`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username"}))
+
+	rec := serve(h.ForgotPasswordHandler, 0, http.MethodPost, "/", "/", `{"email": "nobody@example.com"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+	}
+	h.WaitForMail(context.Background())
+	if sent := mail.messages(); len(sent) != 0 {
+		t.Errorf("sent %+v for an unknown address", sent)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestResetPasswordRejectsPolicyViolations(t *testing.T) {
+	h, mock := newTestHandler(t, Deps{})
+	mock.ExpectBegin()
+	mock.ExpectQuery(`FROM password_reset_tokens t`).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "user_id", "username"}).AddRow(5, 1, "alice"))
+	// Nothing is written, so the token stays usable for another try
+	mock.ExpectRollback()
+
+	rec := serve(h.ResetPasswordHandler, 0, http.MethodPost, "/", "/", `{"token": "abc", "password": "alice-123456"}`)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, body %s", rec.Code, rec.Body)
+	}
+	if !strings.Contains(rec.Body.String(), apierror.ContainsUsername) {
+		t.Errorf("body %s does not mention %s", rec.Body, apierror.ContainsUsername)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,288 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+
+	"quiz-butterfly/backend/apierror"
+	"quiz-butterfly/backend/events"
+	"quiz-butterfly/backend/models"
+)
+
+// duplicateThreshold is the trigram similarity (0-1) of normalized
+// question text at which two questions count as near-duplicates
+const duplicateThreshold = 0.6
+
+// trigramIndexThreshold is pg_trgm's default cutoff for the % operator;
+// pairs below it are never found, so lower thresholds are refused
+const trigramIndexThreshold = 0.3
+
+// similarQuestions returns up to five existing questions whose text is close
+// to text, most similar first. excludeID skips the question being edited.
+func similarQuestions(ctx context.Context, db queryer, text string, excludeID int) ([]models.SimilarQuestion, error) {
+	rows, err := db.QueryContext(ctx, `
+		WITH input AS (SELECT normalize_question_text($1) AS t)
+		SELECT q.id, q.question_text, q.difficulty,
+			similarity(q.normalized_text, input.t) AS score,
+			q.normalized_text = input.t AS exact
+		FROM questions q, input
+		WHERE q.normalized_text % input.t AND q.id <> $2
+			AND similarity(q.normalized_text, input.t) >= $3
+		ORDER BY score DESC, q.id
+		LIMIT 5`, text, excludeID, duplicateThreshold)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	matches := []models.SimilarQuestion{}
+	for rows.Next() {
+		var m models.SimilarQuestion
+		if err := rows.Scan(&m.ID, &m.QuestionText, &m.Difficulty, &m.Similarity, &m.Exact); err != nil {
+			return nil, err
+		}
+		matches = append(matches, m)
+	}
+	return matches, rows.Err()
+}
+
+// checkDuplicates rejects a question that matches an existing one. Exact
+// matches (after normalization) are always rejected; near-duplicates pass
+// when the admin confirms with ?allow_similar=true. It reports whether the
+// handler may continue.
+func checkDuplicates(c *gin.Context, db queryer, text string, excludeID int) bool {
+	matches, err := similarQuestions(c.Request.Context(), db, text, excludeID)
+	if err != nil {
+		c.Error(apierror.Internal(err, "Failed to check for duplicate questions"))
+		return false
+	}
+	if len(matches) == 0 {
+		return true
+	}
+	if matches[0].Exact {
+		c.JSON(http.StatusConflict, models.DuplicateQuestionResponse{
+			ErrorResponse: apierror.New(c, apierror.DuplicateQuestion),
+			Similar:       matches,
+		})
+		return false
+	}
+	if c.Query("allow_similar") != "true" {
+		c.JSON(http.StatusConflict, models.DuplicateQuestionResponse{
+			ErrorResponse: apierror.New(c, apierror.SimilarQuestion),
+			Similar:       matches,
+		})
+		return false
+	}
+	return true
+}
+
+// ImportQuestionsHandler creates many questions at once (admin only).
+// Duplicates of existing questions, or of earlier items in the same batch,
+// are skipped and reported rather than failing the whole import;
+// ?allow_similar=true keeps near-duplicates and skips only exact ones.
+func (h *Handler) ImportQuestionsHandler(c *gin.Context) {
+	db := h.db
+	ctx := c.Request.Context()
+
+	var req models.QuestionImportRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(apierror.Binding(err))
+		return
+	}
+	for i := range req.Questions {
+		if problem := validateQuestion(&req.Questions[i]); problem != nil {
+			problem.Field = fmt.Sprintf("questions[%d].%s", i, problem.Field)
+			c.Error(apierror.Validation(*problem))
+			return
+		}
+	}
+	allowSimilar := c.Query("allow_similar") == "true"
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		c.Error(apierror.Internal(err, "Failed to import questions"))
+		return
+	}
+	defer tx.Rollback()
+
+	result := models.QuestionImportResult{
+		Created: []models.Question{},
+		Skipped: []models.SkippedQuestion{},
+	}
+	now := time.Now()
+	for i, q := range req.Questions {
+		// Runs inside the transaction, so earlier items in the batch count
+		matches, err := similarQuestions(ctx, tx, q.QuestionText, 0)
+		if err != nil {
+			c.Error(apierror.Internal(err, "Failed to check for duplicate questions"))
+			return
+		}
+		if len(matches) > 0 && (matches[0].Exact || !allowSimilar) {
+			reason := "similar"
+			if matches[0].Exact {
+				reason = "duplicate"
+			}
+			result.Skipped = append(result.Skipped, models.SkippedQuestion{Index: i, Reason: reason, Similar: matches})
+			continue
+		}
+
+		if err := insertQuestion(ctx, tx, &q, now); err != nil {
+			c.Error(apierror.Internal(err, "Failed to import questions"))
+			return
+		}
+		result.Created = append(result.Created, q)
+	}
+
+	if err := tx.Commit(); err != nil {
+		c.Error(apierror.Internal(err, "Failed to import questions"))
+		return
+	}
+
+	for _, q := range result.Created {
+		h.events.Publish(events.Event{
+			Type: events.QuestionCreated,
+			Data: gin.H{"question_id": q.ID, "difficulty": q.Difficulty},
+		})
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// GetDuplicateQuestionsHandler groups existing questions into clusters of
+// near-duplicates (admin only). ?threshold= overrides the similarity cutoff.
+func (h *Handler) GetDuplicateQuestionsHandler(c *gin.Context) {
+	db := h.db
+	ctx := c.Request.Context()
+
+	threshold := duplicateThreshold
+	if raw := c.Query("threshold"); raw != "" {
+		t, err := strconv.ParseFloat(raw, 64)
+		if err != nil || t < trigramIndexThreshold || t > 1 {
+			c.Error(apierror.BadRequest(apierror.InvalidThreshold))
+			return
+		}
+		threshold = t
+	}
+
+	// The % operator lets the trigram index find candidates at
+	// trigramIndexThreshold; the similarity filter then applies ours
+	rows, err := db.QueryContext(ctx, `
+		SELECT a.id, b.id, similarity(a.normalized_text, b.normalized_text) AS score
+		FROM questions a
+		JOIN questions b ON a.id < b.id AND a.normalized_text % b.normalized_text
+		WHERE similarity(a.normalized_text, b.normalized_text) >= $1
+		ORDER BY a.id, b.id`, threshold)
+	if err != nil {
+		c.Error(apierror.Internal(err, "Failed to find duplicate questions"))
+		return
+	}
+	defer rows.Close()
+
+	var pairs []models.DuplicatePair
+	for rows.Next() {
+		var p models.DuplicatePair
+		if err := rows.Scan(&p.QuestionID, &p.DuplicateID, &p.Similarity); err != nil {
+			c.Error(apierror.Internal(err, "Failed to find duplicate questions"))
+			return
+		}
+		pairs = append(pairs, p)
+	}
+	if err := rows.Err(); err != nil {
+		c.Error(apierror.Internal(err, "Failed to find duplicate questions"))
+		return
+	}
+
+	clusters, err := duplicateClusters(ctx, db, pairs)
+	if err != nil {
+		c.Error(apierror.Internal(err, "Failed to find duplicate questions"))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"threshold": threshold, "clusters": clusters})
+}
+
+// duplicateClusters joins pairs that share a question into clusters
+// (connected components) and loads their questions
+func duplicateClusters(ctx context.Context, db *sql.DB, pairs []models.DuplicatePair) ([]models.DuplicateCluster, error) {
+	parent := make(map[int]int)
+	var find func(int) int
+	find = func(id int) int {
+		if p, ok := parent[id]; ok && p != id {
+			parent[id] = find(p)
+			return parent[id]
+		}
+		parent[id] = id
+		return id
+	}
+	for _, p := range pairs {
+		a, b := find(p.QuestionID), find(p.DuplicateID)
+		if a != b {
+			parent[b] = a
+		}
+	}
+
+	byRoot := make(map[int]*models.DuplicateCluster)
+	var roots []int
+	for _, p := range pairs {
+		root := find(p.QuestionID)
+		cluster, ok := byRoot[root]
+		if !ok {
+			cluster = &models.DuplicateCluster{}
+			byRoot[root] = cluster
+			roots = append(roots, root)
+		}
+		cluster.Pairs = append(cluster.Pairs, p)
+	}
+
+	ids := make([]int64, 0, len(parent))
+	for id := range parent {
+		ids = append(ids, int64(id))
+	}
+	questions := make(map[int]models.Question)
+	if len(ids) > 0 {
+		rows, err := db.QueryContext(ctx, `
+			SELECT `+questionColumns+`
+			FROM questions WHERE id = ANY($1)`, pq.Int64Array(ids))
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var q models.Question
+			if err := scanQuestion(rows, &q); err != nil {
+				return nil, err
+			}
+			questions[q.ID] = q
+		}
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	clusters := make([]models.DuplicateCluster, 0, len(roots))
+	for _, root := range roots {
+		cluster := byRoot[root]
+		var memberIDs []int
+		for id := range parent {
+			if find(id) == root {
+				memberIDs = append(memberIDs, id)
+			}
+		}
+		sort.Ints(memberIDs)
+		for _, id := range memberIDs {
+			if q, ok := questions[id]; ok {
+				cluster.Questions = append(cluster.Questions, q)
+			}
+		}
+		clusters = append(clusters, *cluster)
+	}
+	return clusters, nil
+}
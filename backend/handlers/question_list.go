@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"quiz-butterfly/backend/apierror"
+	"quiz-butterfly/backend/models"
+)
+
+const (
+	questionPageDefault = 20
+	questionPageMax     = 100
+)
+
+// ListQuestionsHandler pages through questions for admins, newest first.
+// Query parameters:
+//
+//	q              full-text search over question text and options
+//	difficulty     easy, medium or advance
+//	reference      case-insensitive substring of the reference
+//	created_after  RFC 3339 time or YYYY-MM-DD (inclusive)
+//	created_before RFC 3339 time or YYYY-MM-DD (exclusive)
+//	limit          page size, 1-100 (default 20)
+//	cursor         next_cursor from the previous page
+func (h *Handler) ListQuestionsHandler(c *gin.Context) {
+	db := h.db
+	ctx := c.Request.Context()
+
+	var where []string
+	var args []interface{}
+	add := func(cond string, arg interface{}) {
+		args = append(args, arg)
+		where = append(where, fmt.Sprintf(cond, len(args)))
+	}
+
+	if q := strings.TrimSpace(c.Query("q")); q != "" {
+		add("search_vector @@ websearch_to_tsquery('simple', $%d)", q)
+	}
+	if difficulty := c.Query("difficulty"); difficulty != "" {
+		if difficulty != "easy" && difficulty != "medium" && difficulty != "advance" {
+			c.Error(apierror.BadRequest(apierror.InvalidDifficulty))
+			return
+		}
+		add("difficulty = $%d", difficulty)
+	}
+	if reference := strings.TrimSpace(c.Query("reference")); reference != "" {
+		add("reference ILIKE '%%' || $%d || '%%'", escapeLike(reference))
+	}
+	for _, bound := range []struct{ param, cond string }{
+		{"created_after", "created_at >= $%d"},
+		{"created_before", "created_at < $%d"},
+	} {
+		raw := c.Query(bound.param)
+		if raw == "" {
+			continue
+		}
+		t, err := parseQuestionTime(raw)
+		if err != nil {
+			c.Error(apierror.BadRequest(apierror.InvalidTime, bound.param))
+			return
+		}
+		add(bound.cond, t)
+	}
+
+	limit := questionPageDefault
+	if raw := c.Query("limit"); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n < 1 || n > questionPageMax {
+			c.Error(apierror.BadRequest(apierror.InvalidLimit, questionPageMax))
+			return
+		}
+		limit = n
+	}
+
+	if raw := c.Query("cursor"); raw != "" {
+		createdAt, id, err := decodeQuestionCursor(raw)
+		if err != nil {
+			c.Error(apierror.BadRequest(apierror.InvalidCursor))
+			return
+		}
+		args = append(args, createdAt, id)
+		where = append(where, fmt.Sprintf("(created_at, id) < ($%d, $%d)", len(args)-1, len(args)))
+	}
+
+	query := `
+		SELECT ` + questionColumns + `
+		FROM questions`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	// One extra row tells whether another page follows
+	args = append(args, limit+1)
+	query += fmt.Sprintf(" ORDER BY created_at DESC, id DESC LIMIT $%d", len(args))
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		c.Error(apierror.Internal(err, "Failed to get questions"))
+		return
+	}
+	defer rows.Close()
+
+	questions := []models.Question{}
+	for rows.Next() {
+		var q models.Question
+		if err := scanQuestion(rows, &q); err != nil {
+			c.Error(apierror.Internal(err, "Failed to read questions"))
+			return
+		}
+		questions = append(questions, q)
+	}
+	if err := rows.Err(); err != nil {
+		c.Error(apierror.Internal(err, "Failed to read questions"))
+		return
+	}
+
+	page := models.QuestionPage{Questions: questions}
+	if len(questions) > limit {
+		page.Questions = questions[:limit]
+	}
+	if err := h.loadAttachments(ctx, db, questionPointers(page.Questions)...); err != nil {
+		c.Error(apierror.Internal(err, "Failed to get question attachments"))
+		return
+	}
+	if len(questions) > limit {
+		last := page.Questions[limit-1]
+		page.NextCursor = encodeQuestionCursor(last.CreatedAt, last.ID)
+	}
+
+	c.JSON(http.StatusOK, page)
+}
+
+// The cursor is the sort key of the last row returned, kept opaque so the
+// ordering can change without breaking clients that treat it as a token
+func encodeQuestionCursor(createdAt time.Time, id int) string {
+	raw := createdAt.UTC().Format(time.RFC3339Nano) + "|" + strconv.Itoa(id)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeQuestionCursor(cursor string) (time.Time, int, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+	ts, idStr, found := strings.Cut(string(raw), "|")
+	if !found {
+		return time.Time{}, 0, fmt.Errorf("malformed cursor")
+	}
+	createdAt, err := time.Parse(time.RFC3339Nano, ts)
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		return time.Time{}, 0, err
+	}
+	return createdAt, id, nil
+}
+
+func parseQuestionTime(raw string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, raw); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", raw)
+}
+
+// escapeLike makes % and _ in user input match literally
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
@@ -0,0 +1,373 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/lib/pq"
+
+	"quiz-butterfly/backend/apierror"
+	"quiz-butterfly/backend/events"
+	"quiz-butterfly/backend/grading"
+	"quiz-butterfly/backend/locale"
+	"quiz-butterfly/backend/models"
+)
+
+// requestLocale picks the language to show questions in: the user's saved
+// preference, then the Accept-Language header, then the default
+func requestLocale(c *gin.Context, db queryRower) string {
+	var preferred sql.NullString
+	err := db.QueryRowContext(c.Request.Context(), "SELECT locale FROM users WHERE id = $1", c.GetInt("user_id")).Scan(&preferred)
+	if err == nil && preferred.Valid {
+		return preferred.String
+	}
+	if l, ok := locale.FromAcceptLanguage(c.GetHeader("Accept-Language")); ok {
+		return l
+	}
+	return locale.Default
+}
+
+// localizeQuestions replaces the content of each question with its
+// translation into loc. Questions without one keep their own language, as
+// does any field the translation leaves empty.
+func localizeQuestions(ctx context.Context, db queryer, loc string, questions ...*models.Question) error {
+	byID := make(map[int]*models.Question, len(questions))
+	ids := make(pq.Int64Array, 0, len(questions))
+	for _, q := range questions {
+		if q.Locale != loc {
+			byID[q.ID] = q
+			ids = append(ids, int64(q.ID))
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT `+translationColumns+`
+		FROM question_translations WHERE question_id = ANY($1) AND locale = $2`, ids, loc)
+	if err != nil {
+		return err
+	}
+	translations, err := scanTranslations(rows)
+	if err != nil {
+		return err
+	}
+
+	for _, t := range translations {
+		q := byID[t.QuestionID]
+		q.QuestionText = t.QuestionText
+		q.Locale = t.Locale
+		// Options edited since the translation was written no longer line
+		// up, so the question's own are shown until it is updated
+		if len(t.Options) == len(q.Options) && len(t.Options) > 0 {
+			q.Options = t.Options
+		}
+		if len(t.AcceptedAnswers) > 0 {
+			q.AcceptedAnswers = t.AcceptedAnswers
+		}
+		if t.Explanation != "" {
+			q.Explanation = t.Explanation
+		}
+	}
+	return nil
+}
+
+// gradeAnyLocale grades answer against q as written, whichever language
+// it was given in. Translated options are mapped back to the original
+// option at the same index, and short_text accepts the answers of every
+// translation, so the result never depends on the player's language.
+func gradeAnyLocale(ctx context.Context, db queryer, q models.Question, answer grading.Answer) (grading.Result, error) {
+	rows, err := db.QueryContext(ctx, `
+		SELECT `+translationColumns+`
+		FROM question_translations WHERE question_id = $1`, q.ID)
+	if err != nil {
+		return grading.Result{}, err
+	}
+	translations, err := scanTranslations(rows)
+	if err != nil {
+		return grading.Result{}, err
+	}
+
+	original := func(text string) string {
+		for _, option := range q.Options {
+			if sameOption(q.Type, text, option) {
+				return text
+			}
+		}
+		for _, t := range translations {
+			if len(t.Options) != len(q.Options) {
+				continue
+			}
+			for i, option := range t.Options {
+				if sameOption(q.Type, text, option) {
+					return q.Options[i]
+				}
+			}
+		}
+		return text
+	}
+
+	mapped := grading.Answer{Text: original(answer.Text)}
+	for _, choice := range answer.Choices {
+		mapped.Choices = append(mapped.Choices, original(choice))
+	}
+	accepted := append(pq.StringArray{}, q.AcceptedAnswers...)
+	for _, t := range translations {
+		accepted = append(accepted, t.AcceptedAnswers...)
+	}
+	q.AcceptedAnswers = accepted
+
+	return grading.Grade(q, mapped), nil
+}
+
+// sameOption matches an answer to an option the way grading does for the
+// question type
+func sameOption(questionType, answer, option string) bool {
+	if questionType == grading.TrueFalse {
+		return strings.EqualFold(strings.TrimSpace(answer), option)
+	}
+	return answer == option
+}
+
+// translationColumns selects everything scanTranslations reads, in order
+const translationColumns = `question_id, locale, question_text, options, accepted_answers,
+	COALESCE(explanation, ''), updated_at`
+
+func scanTranslations(rows *sql.Rows) ([]models.QuestionTranslation, error) {
+	defer rows.Close()
+
+	translations := []models.QuestionTranslation{}
+	for rows.Next() {
+		var t models.QuestionTranslation
+		if err := rows.Scan(&t.QuestionID, &t.Locale, &t.QuestionText, &t.Options, &t.AcceptedAnswers,
+			&t.Explanation, &t.UpdatedAt); err != nil {
+			return nil, err
+		}
+		translations = append(translations, t)
+	}
+	return translations, rows.Err()
+}
+
+// GetQuestionTranslationsHandler lists a question's translations (admin only)
+func (h *Handler) GetQuestionTranslationsHandler(c *gin.Context) {
+	db := h.db
+	ctx := c.Request.Context()
+
+	questionID := 0
+	if _, err := fmt.Sscanf(c.Param("id"), "%d", &questionID); err != nil {
+		c.Error(apierror.BadRequest(apierror.InvalidQuestionID))
+		return
+	}
+
+	var exists bool
+	if err := db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM questions WHERE id = $1)", questionID).Scan(&exists); err != nil {
+		c.Error(apierror.Internal(err, "Failed to get question"))
+		return
+	}
+	if !exists {
+		c.Error(apierror.NotFound(apierror.QuestionNotFound))
+		return
+	}
+
+	rows, err := db.QueryContext(ctx, `
+		SELECT `+translationColumns+`
+		FROM question_translations WHERE question_id = $1 ORDER BY locale`, questionID)
+	if err != nil {
+		c.Error(apierror.Internal(err, "Failed to get translations"))
+		return
+	}
+	translations, err := scanTranslations(rows)
+	if err != nil {
+		c.Error(apierror.Internal(err, "Failed to read translations"))
+		return
+	}
+
+	c.JSON(http.StatusOK, translations)
+}
+
+// PutQuestionTranslationHandler creates or replaces a question's
+// translation into the :locale language (admin only)
+func (h *Handler) PutQuestionTranslationHandler(c *gin.Context) {
+	db := h.db
+	ctx := c.Request.Context()
+
+	questionID := 0
+	if _, err := fmt.Sscanf(c.Param("id"), "%d", &questionID); err != nil {
+		c.Error(apierror.BadRequest(apierror.InvalidQuestionID))
+		return
+	}
+	loc, ok := locale.Parse(c.Param("locale"))
+	if !ok {
+		c.Error(apierror.BadRequest(apierror.UnsupportedLocale, strings.Join(locale.Supported(), ", ")))
+		return
+	}
+
+	var req models.QuestionTranslationRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(apierror.Binding(err))
+		return
+	}
+
+	var question models.Question
+	err := scanQuestion(db.QueryRowContext(ctx, `
+		SELECT `+questionColumns+`
+		FROM questions WHERE id = $1`, questionID), &question)
+	if err == sql.ErrNoRows {
+		c.Error(apierror.NotFound(apierror.QuestionNotFound))
+		return
+	}
+	if err != nil {
+		c.Error(apierror.Internal(err, "Failed to get question"))
+		return
+	}
+
+	t := models.QuestionTranslation{
+		QuestionID:      questionID,
+		Locale:          loc,
+		QuestionText:    strings.TrimSpace(req.QuestionText),
+		Options:         req.Options,
+		AcceptedAnswers: req.AcceptedAnswers,
+		Explanation:     req.Explanation,
+	}
+	if problem := validateTranslation(&question, &t); problem != nil {
+		c.Error(apierror.Validation(*problem))
+		return
+	}
+
+	err = db.QueryRowContext(ctx, `
+		INSERT INTO question_translations (question_id, locale, question_text, options, accepted_answers, explanation, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)
+		ON CONFLICT (question_id, locale) DO UPDATE
+		SET question_text = EXCLUDED.question_text, options = EXCLUDED.options,
+			accepted_answers = EXCLUDED.accepted_answers, explanation = EXCLUDED.explanation,
+			updated_at = EXCLUDED.updated_at
+		RETURNING updated_at`,
+		questionID, loc, t.QuestionText, t.Options, t.AcceptedAnswers, t.Explanation, time.Now()).Scan(&t.UpdatedAt)
+	if err != nil {
+		c.Error(dbError(err, "Failed to save translation"))
+		return
+	}
+
+	h.events.Publish(events.Event{
+		Type: events.QuestionUpdated,
+		Data: gin.H{"question_id": questionID, "difficulty": question.Difficulty},
+	})
+
+	c.JSON(http.StatusOK, t)
+}
+
+// validateTranslation checks t against the question it translates and
+// returns the first problem found, or nil if it is valid
+func validateTranslation(q *models.Question, t *models.QuestionTranslation) *models.FieldError {
+	problem := func(field, code, param string) *models.FieldError {
+		fe := apierror.Field(field, code, param)
+		return &fe
+	}
+
+	if t.Locale == q.Locale {
+		return problem("locale", apierror.SameAsSource, q.Locale)
+	}
+	if t.QuestionText == "" {
+		return problem("question_text", apierror.Required, "")
+	}
+
+	// Translated options must line up with the question's by index
+	if len(t.Options) > 0 && len(t.Options) != len(q.Options) {
+		if len(q.Options) == 0 {
+			return problem("options", apierror.NotApplicable, "")
+		}
+		return problem("options", apierror.WrongCount, strconv.Itoa(len(q.Options)))
+	}
+	for _, option := range t.Options {
+		if strings.TrimSpace(option) == "" {
+			return problem("options", apierror.Blank, "")
+		}
+	}
+
+	if len(t.AcceptedAnswers) > 0 && q.Type != grading.ShortText {
+		return problem("accepted_answers", apierror.NotApplicable, "")
+	}
+	for _, a := range t.AcceptedAnswers {
+		if strings.TrimSpace(a) == "" {
+			return problem("accepted_answers", apierror.Blank, "")
+		}
+	}
+	return nil
+}
+
+// DeleteQuestionTranslationHandler removes a question's translation into
+// the :locale language (admin only)
+func (h *Handler) DeleteQuestionTranslationHandler(c *gin.Context) {
+	db := h.db
+	ctx := c.Request.Context()
+
+	questionID := 0
+	if _, err := fmt.Sscanf(c.Param("id"), "%d", &questionID); err != nil {
+		c.Error(apierror.BadRequest(apierror.InvalidQuestionID))
+		return
+	}
+	loc, ok := locale.Parse(c.Param("locale"))
+	if !ok {
+		c.Error(apierror.BadRequest(apierror.UnsupportedLocale, strings.Join(locale.Supported(), ", ")))
+		return
+	}
+
+	var difficulty string
+	err := db.QueryRowContext(ctx, `
+		DELETE FROM question_translations t USING questions q
+		WHERE t.question_id = $1 AND t.locale = $2 AND q.id = t.question_id
+		RETURNING q.difficulty`, questionID, loc).Scan(&difficulty)
+	if err == sql.ErrNoRows {
+		c.Error(apierror.NotFound(apierror.TranslationNotFound))
+		return
+	}
+	if err != nil {
+		c.Error(apierror.Internal(err, "Failed to delete translation"))
+		return
+	}
+
+	h.events.Publish(events.Event{
+		Type: events.QuestionUpdated,
+		Data: gin.H{"question_id": questionID, "difficulty": difficulty},
+	})
+
+	c.JSON(http.StatusOK, gin.H{"message": "Translation deleted successfully"})
+}
+
+// UpdateLocaleHandler saves the user's preferred language for questions.
+// An empty locale clears it, so Accept-Language decides again.
+func (h *Handler) UpdateLocaleHandler(c *gin.Context) {
+	db := h.db
+	ctx := c.Request.Context()
+	userID := c.GetInt("user_id")
+
+	var req models.UserLocaleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.Error(apierror.Binding(err))
+		return
+	}
+
+	var preferred *string
+	if strings.TrimSpace(req.Locale) != "" {
+		loc, ok := locale.Parse(req.Locale)
+		if !ok {
+			c.Error(apierror.BadRequest(apierror.UnsupportedLocale, strings.Join(locale.Supported(), ", ")))
+			return
+		}
+		preferred = &loc
+	}
+
+	if _, err := db.ExecContext(ctx, "UPDATE users SET locale = $1, updated_at = NOW() WHERE id = $2", preferred, userID); err != nil {
+		c.Error(apierror.Internal(err, "Failed to update locale"))
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Locale updated successfully", "locale": preferred})
+}
@@ -0,0 +1,140 @@
+package live
+
+import (
+	"errors"
+	"time"
+
+	"quiz-butterfly/backend/grading"
+	"quiz-butterfly/backend/models"
+)
+
+// Room statuses
+const (
+	StatusWaiting  = "waiting"
+	StatusQuestion = "question"
+	StatusReview   = "review"
+	StatusFinished = "finished"
+)
+
+// Event types pushed to room subscribers
+const (
+	EventRoomState      = "room_state"
+	EventPlayerJoined   = "player_joined"
+	EventPlayerLeft     = "player_left"
+	EventQuestion       = "question"
+	EventAnswerProgress = "answer_progress"
+	EventQuestionResult = "question_result"
+	EventFinished       = "finished"
+	EventRoomClosed     = "room_closed"
+)
+
+var (
+	ErrRoomNotFound    = errors.New("room not found")
+	ErrNotHost         = errors.New("only the host can do that")
+	ErrGameStarted     = errors.New("game has already started")
+	ErrRoomFull        = errors.New("room is full")
+	ErrNotAccepting    = errors.New("not accepting answers right now")
+	ErrAlreadyAnswered = errors.New("already answered this question")
+	ErrWrongQuestion   = errors.New("answer is for a different question")
+	ErrNotPlayer       = errors.New("host cannot answer")
+	ErrHubClosed       = errors.New("live hub is closed")
+)
+
+// Player identifies a participant in a room
+type Player struct {
+	UserID   int    `json:"user_id"`
+	Username string `json:"username"`
+}
+
+// RoomConfig describes a room to open
+type RoomConfig struct {
+	Host         Player
+	Difficulty   string
+	Questions    []models.Question
+	QuestionTime time.Duration
+}
+
+// Event is a message pushed to everyone subscribed to a room
+type Event struct {
+	Type string      `json:"type"`
+	Data interface{} `json:"data,omitempty"`
+}
+
+// Subscription delivers a room's events to one connection. Events is
+// closed when the room closes or the subscriber falls too far behind.
+type Subscription struct {
+	Events <-chan Event
+	id     uint64
+	pin    string
+}
+
+// Hub holds live quiz rooms. MemoryHub keeps them in process; another
+// implementation could keep rooms in a shared store and fan events out
+// across instances without changing the handlers.
+type Hub interface {
+	// CreateRoom opens a room and returns its join PIN
+	CreateRoom(cfg RoomConfig) (string, error)
+	// Subscribe joins p to the room (the host subscribes as themself) and
+	// starts delivering events, beginning with the current room state
+	Subscribe(pin string, p Player) (*Subscription, error)
+	// Unsubscribe stops delivering events to sub
+	Unsubscribe(sub *Subscription)
+	// Advance is called by the host: it starts the game, closes the
+	// running question early, or moves to the next question
+	Advance(pin string, userID int) error
+	// Answer records a player's answer for the current question
+	Answer(pin string, userID int, questionID int, answer grading.Answer) error
+	// Close shuts every room and stops background work
+	Close()
+}
+
+// QuestionView is a question as shown to players, without the answer
+type QuestionView struct {
+	Index        int                         `json:"index"`
+	Total        int                         `json:"total"`
+	QuestionID   int                         `json:"question_id"`
+	QuestionText string                      `json:"question_text"`
+	Type         string                      `json:"type"`
+	Options      []string                    `json:"options"`
+	Attachments  []models.QuestionAttachment `json:"attachments,omitempty"`
+	Deadline     time.Time                   `json:"deadline"`
+	TimeLimit    int                         `json:"time_limit_seconds"`
+}
+
+// Standing is one player's place on the live leaderboard
+type Standing struct {
+	Rank       int    `json:"rank"`
+	UserID     int    `json:"user_id"`
+	Username   string `json:"username"`
+	Score      int    `json:"score"`
+	Correct    int    `json:"correct"`
+	LastPoints int    `json:"last_points"`
+}
+
+// RoomState is the snapshot sent to a new subscriber
+type RoomState struct {
+	PIN            string        `json:"pin"`
+	Host           Player        `json:"host"`
+	Difficulty     string        `json:"difficulty"`
+	Status         string        `json:"status"`
+	Players        []Player      `json:"players"`
+	QuestionIndex  int           `json:"question_index"`
+	TotalQuestions int           `json:"total_questions"`
+	Question       *QuestionView `json:"question,omitempty"`
+	Leaderboard    []Standing    `json:"leaderboard"`
+}
+
+// QuestionResult is pushed when a question closes
+type QuestionResult struct {
+	QuestionID    int        `json:"question_id"`
+	CorrectAnswer string     `json:"correct_answer"`
+	Reference     string     `json:"reference"`
+	Explanation   string     `json:"explanation,omitempty"`
+	Leaderboard   []Standing `json:"leaderboard"`
+}
+
+// AnswerProgress is pushed whenever a player answers
+type AnswerProgress struct {
+	Answered int `json:"answered"`
+	Players  int `json:"players"`
+}
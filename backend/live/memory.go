@@ -0,0 +1,449 @@
+package live
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+
+	"quiz-butterfly/backend/grading"
+)
+
+const (
+	maxPlayers       = 200
+	subscriberBuffer = 64
+	// A room is dropped after this long without activity, or sooner once
+	// finished or abandoned
+	roomIdleTTL      = 2 * time.Hour
+	roomFinishedTTL  = 10 * time.Minute
+	roomAbandonedTTL = 30 * time.Minute
+)
+
+// MemoryHub keeps rooms in process memory
+type MemoryHub struct {
+	mu     sync.Mutex
+	rooms  map[string]*room
+	nextID uint64
+	closed bool
+	stop   chan struct{}
+}
+
+type room struct {
+	mu        sync.Mutex
+	pin       string
+	cfg       RoomConfig
+	status    string
+	index     int
+	deadline  time.Time
+	timer     *time.Timer
+	players   map[int]*playerState
+	order     []int
+	answers   map[int]int // user ID -> points earned on the current question
+	subs      map[uint64]*subscriber
+	updatedAt time.Time
+}
+
+type playerState struct {
+	Player
+	score      int
+	correct    int
+	lastPoints int
+}
+
+type subscriber struct {
+	player Player
+	ch     chan Event
+}
+
+// NewMemoryHub creates a MemoryHub and starts its cleanup loop
+func NewMemoryHub() *MemoryHub {
+	h := &MemoryHub{
+		rooms: make(map[string]*room),
+		stop:  make(chan struct{}),
+	}
+	go h.sweepLoop()
+	return h
+}
+
+func (h *MemoryHub) CreateRoom(cfg RoomConfig) (string, error) {
+	if len(cfg.Questions) == 0 {
+		return "", fmt.Errorf("room needs at least one question")
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		return "", ErrHubClosed
+	}
+
+	pin := ""
+	for attempt := 0; attempt < 20; attempt++ {
+		candidate := randomPIN()
+		if _, taken := h.rooms[candidate]; !taken {
+			pin = candidate
+			break
+		}
+	}
+	if pin == "" {
+		return "", fmt.Errorf("no free room PIN")
+	}
+
+	h.rooms[pin] = &room{
+		pin:       pin,
+		cfg:       cfg,
+		status:    StatusWaiting,
+		index:     -1,
+		players:   make(map[int]*playerState),
+		answers:   make(map[int]int),
+		subs:      make(map[uint64]*subscriber),
+		updatedAt: time.Now(),
+	}
+	return pin, nil
+}
+
+func (h *MemoryHub) Subscribe(pin string, p Player) (*Subscription, error) {
+	h.mu.Lock()
+	r, ok := h.rooms[pin]
+	h.nextID++
+	id := h.nextID
+	h.mu.Unlock()
+	if !ok {
+		return nil, ErrRoomNotFound
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if p.UserID != r.cfg.Host.UserID {
+		if _, joined := r.players[p.UserID]; !joined {
+			if r.status != StatusWaiting {
+				return nil, ErrGameStarted
+			}
+			if len(r.players) >= maxPlayers {
+				return nil, ErrRoomFull
+			}
+			r.players[p.UserID] = &playerState{Player: p}
+			r.order = append(r.order, p.UserID)
+			r.broadcast(Event{Type: EventPlayerJoined, Data: p})
+		}
+	}
+
+	sub := &subscriber{player: p, ch: make(chan Event, subscriberBuffer)}
+	sub.ch <- Event{Type: EventRoomState, Data: r.state()}
+	r.subs[id] = sub
+	r.updatedAt = time.Now()
+
+	return &Subscription{Events: sub.ch, id: id, pin: pin}, nil
+}
+
+func (h *MemoryHub) Unsubscribe(s *Subscription) {
+	h.mu.Lock()
+	r, ok := h.rooms[s.pin]
+	h.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	sub, ok := r.subs[s.id]
+	if !ok {
+		return
+	}
+	delete(r.subs, s.id)
+	close(sub.ch)
+
+	// Players keep their seat during a game so they can reconnect; in the
+	// lobby their last connection leaving means they left
+	if r.status != StatusWaiting || sub.player.UserID == r.cfg.Host.UserID {
+		return
+	}
+	for _, other := range r.subs {
+		if other.player.UserID == sub.player.UserID {
+			return
+		}
+	}
+	r.removePlayer(sub.player.UserID)
+	r.broadcast(Event{Type: EventPlayerLeft, Data: sub.player})
+}
+
+func (h *MemoryHub) Advance(pin string, userID int) error {
+	h.mu.Lock()
+	r, ok := h.rooms[pin]
+	h.mu.Unlock()
+	if !ok {
+		return ErrRoomNotFound
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if userID != r.cfg.Host.UserID {
+		return ErrNotHost
+	}
+	r.updatedAt = time.Now()
+
+	switch r.status {
+	case StatusQuestion:
+		r.endQuestion()
+	case StatusWaiting, StatusReview:
+		if r.index+1 >= len(r.cfg.Questions) {
+			r.finish()
+		} else {
+			r.startQuestion(r.index + 1)
+		}
+	}
+	return nil
+}
+
+func (h *MemoryHub) Answer(pin string, userID int, questionID int, answer grading.Answer) error {
+	h.mu.Lock()
+	r, ok := h.rooms[pin]
+	h.mu.Unlock()
+	if !ok {
+		return ErrRoomNotFound
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	player, isPlayer := r.players[userID]
+	if !isPlayer {
+		return ErrNotPlayer
+	}
+	now := time.Now()
+	if r.status != StatusQuestion || now.After(r.deadline) {
+		return ErrNotAccepting
+	}
+	question := r.cfg.Questions[r.index]
+	if question.ID != questionID {
+		return ErrWrongQuestion
+	}
+	if _, answered := r.answers[userID]; answered {
+		return ErrAlreadyAnswered
+	}
+
+	points := 0
+	if result := grading.Grade(question, answer); result.Credit > 0 {
+		// Half the points for being right, the rest for being quick,
+		// scaled by partial credit
+		remaining := r.deadline.Sub(now)
+		points = int(result.Credit * float64(500+int(500*remaining/r.cfg.QuestionTime)))
+		if result.Correct {
+			player.correct++
+		}
+	}
+	r.answers[userID] = points
+	r.updatedAt = now
+
+	r.broadcast(Event{Type: EventAnswerProgress, Data: AnswerProgress{Answered: len(r.answers), Players: len(r.players)}})
+
+	if len(r.answers) >= len(r.players) {
+		r.endQuestion()
+	}
+	return nil
+}
+
+func (h *MemoryHub) Close() {
+	h.mu.Lock()
+	if h.closed {
+		h.mu.Unlock()
+		return
+	}
+	h.closed = true
+	close(h.stop)
+	rooms := h.rooms
+	h.rooms = make(map[string]*room)
+	h.mu.Unlock()
+
+	for _, r := range rooms {
+		r.mu.Lock()
+		r.close()
+		r.mu.Unlock()
+	}
+}
+
+func (h *MemoryHub) sweepLoop() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-h.stop:
+			return
+		case now := <-ticker.C:
+			h.sweep(now)
+		}
+	}
+}
+
+// sweep closes rooms that finished, were abandoned or went idle
+func (h *MemoryHub) sweep(now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for pin, r := range h.rooms {
+		r.mu.Lock()
+		idle := now.Sub(r.updatedAt)
+		expired := idle > roomIdleTTL ||
+			(r.status == StatusFinished && idle > roomFinishedTTL) ||
+			(len(r.subs) == 0 && idle > roomAbandonedTTL)
+		if expired {
+			r.close()
+			delete(h.rooms, pin)
+		}
+		r.mu.Unlock()
+	}
+}
+
+// The methods below must be called with r.mu held.
+
+func (r *room) startQuestion(index int) {
+	r.index = index
+	r.status = StatusQuestion
+	r.deadline = time.Now().Add(r.cfg.QuestionTime)
+	r.answers = make(map[int]int)
+
+	r.timer = time.AfterFunc(r.cfg.QuestionTime, func() {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		if r.status == StatusQuestion && r.index == index {
+			r.endQuestion()
+		}
+	})
+
+	r.broadcast(Event{Type: EventQuestion, Data: r.questionView()})
+}
+
+func (r *room) endQuestion() {
+	if r.timer != nil {
+		r.timer.Stop()
+		r.timer = nil
+	}
+	r.status = StatusReview
+
+	for _, p := range r.players {
+		p.lastPoints = r.answers[p.UserID]
+		p.score += p.lastPoints
+	}
+
+	question := r.cfg.Questions[r.index]
+	r.broadcast(Event{Type: EventQuestionResult, Data: QuestionResult{
+		QuestionID:    question.ID,
+		CorrectAnswer: grading.CorrectAnswer(question),
+		Reference:     question.Reference,
+		Explanation:   question.Explanation,
+		Leaderboard:   r.leaderboard(),
+	}})
+}
+
+func (r *room) finish() {
+	r.status = StatusFinished
+	r.broadcast(Event{Type: EventFinished, Data: r.leaderboard()})
+}
+
+func (r *room) close() {
+	if r.timer != nil {
+		r.timer.Stop()
+		r.timer = nil
+	}
+	r.broadcast(Event{Type: EventRoomClosed})
+	for id, sub := range r.subs {
+		close(sub.ch)
+		delete(r.subs, id)
+	}
+}
+
+func (r *room) removePlayer(userID int) {
+	delete(r.players, userID)
+	for i, id := range r.order {
+		if id == userID {
+			r.order = append(r.order[:i], r.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// broadcast sends ev to every subscriber. A subscriber whose buffer is full
+// is dropped rather than stalling the room.
+func (r *room) broadcast(ev Event) {
+	for id, sub := range r.subs {
+		select {
+		case sub.ch <- ev:
+		default:
+			close(sub.ch)
+			delete(r.subs, id)
+		}
+	}
+}
+
+func (r *room) questionView() *QuestionView {
+	if r.status != StatusQuestion {
+		return nil
+	}
+	q := r.cfg.Questions[r.index]
+	return &QuestionView{
+		Index:        r.index,
+		Total:        len(r.cfg.Questions),
+		QuestionID:   q.ID,
+		QuestionText: q.QuestionText,
+		Type:         q.Type,
+		Options:      q.Options,
+		Attachments:  q.Attachments,
+		Deadline:     r.deadline,
+		TimeLimit:    int(r.cfg.QuestionTime.Seconds()),
+	}
+}
+
+func (r *room) leaderboard() []Standing {
+	standings := make([]Standing, 0, len(r.order))
+	for _, id := range r.order {
+		p := r.players[id]
+		standings = append(standings, Standing{
+			UserID:     p.UserID,
+			Username:   p.Username,
+			Score:      p.score,
+			Correct:    p.correct,
+			LastPoints: p.lastPoints,
+		})
+	}
+	sort.SliceStable(standings, func(i, j int) bool {
+		if standings[i].Score != standings[j].Score {
+			return standings[i].Score > standings[j].Score
+		}
+		return standings[i].Correct > standings[j].Correct
+	})
+	for i := range standings {
+		standings[i].Rank = i + 1
+	}
+	return standings
+}
+
+func (r *room) state() RoomState {
+	players := make([]Player, 0, len(r.order))
+	for _, id := range r.order {
+		players = append(players, r.players[id].Player)
+	}
+	return RoomState{
+		PIN:            r.pin,
+		Host:           r.cfg.Host,
+		Difficulty:     r.cfg.Difficulty,
+		Status:         r.status,
+		Players:        players,
+		QuestionIndex:  r.index,
+		TotalQuestions: len(r.cfg.Questions),
+		Question:       r.questionView(),
+		Leaderboard:    r.leaderboard(),
+	}
+}
+
+func randomPIN() string {
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		panic(err)
+	}
+	return fmt.Sprintf("%06d", n.Int64())
+}
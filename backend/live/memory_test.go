@@ -0,0 +1,61 @@
+package live
+
+import (
+	"testing"
+	"time"
+
+	"quiz-butterfly/backend/grading"
+	"quiz-butterfly/backend/models"
+)
+
+// A question without options closing on its timer used to index Options
+// and take the process down with it
+func TestQuestionResultForQuestionWithoutOptions(t *testing.T) {
+	answer := 9.81
+	questions := []models.Question{
+		{ID: 1, Type: grading.Numeric, QuestionText: "g in m/s²?", NumericAnswer: &answer, Explanation: "Standard gravity"},
+		{ID: 2, Type: grading.ShortText, QuestionText: "Capital of France?", AcceptedAnswers: []string{"Paris"}},
+	}
+	hub := NewMemoryHub()
+	defer hub.Close()
+
+	host := Player{UserID: 1, Username: "teacher"}
+	pin, err := hub.CreateRoom(RoomConfig{Host: host, Difficulty: "easy", Questions: questions, QuestionTime: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub, err := hub.Subscribe(pin, host)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []QuestionResult{
+		{QuestionID: 1, CorrectAnswer: "9.81", Explanation: "Standard gravity"},
+		{QuestionID: 2, CorrectAnswer: "Paris"},
+	}
+	timeout := time.After(2 * time.Second)
+	for _, w := range want {
+		if err := hub.Advance(pin, host.UserID); err != nil {
+			t.Fatal(err)
+		}
+		var got QuestionResult
+	wait:
+		for {
+			select {
+			case ev, ok := <-sub.Events:
+				if !ok {
+					t.Fatal("room closed")
+				}
+				if ev.Type == EventQuestionResult {
+					got = ev.Data.(QuestionResult)
+					break wait
+				}
+			case <-timeout:
+				t.Fatal("no question result")
+			}
+		}
+		if got.QuestionID != w.QuestionID || got.CorrectAnswer != w.CorrectAnswer || got.Explanation != w.Explanation {
+			t.Errorf("result = %+v, want %+v", got, w)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package locale
+
+import (
+	"strconv"
+	"strings"
+)
+
+// Supported locales
+const (
+	English    = "en"
+	Indonesian = "id"
+
+	// Default is used when neither the user nor the request names a
+	// supported locale, and is the language questions are written in
+	// unless they say otherwise
+	Default = English
+)
+
+var supported = []string{English, Indonesian}
+
+// Supported lists every locale the app has content for
+func Supported() []string {
+	return append([]string(nil), supported...)
+}
+
+// Parse reduces a language tag such as "id-ID" or "en_US" to a supported
+// locale. It reports false for languages the app doesn't have.
+func Parse(tag string) (string, bool) {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	base, _, _ := strings.Cut(strings.ReplaceAll(tag, "_", "-"), "-")
+	// "in" is the withdrawn code for Indonesian that old Java-based
+	// clients still send
+	if base == "in" {
+		base = Indonesian
+	}
+	for _, l := range supported {
+		if base == l {
+			return l, true
+		}
+	}
+	return "", false
+}
+
+// FromAcceptLanguage picks the supported locale an Accept-Language header
+// weights highest; ties go to the one listed first. It reports false when
+// the header names none of them.
+func FromAcceptLanguage(header string) (string, bool) {
+	best, bestWeight := "", 0.0
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(part, ";")
+		weight := 1.0
+		for _, param := range strings.Split(params, ";") {
+			key, value, ok := strings.Cut(strings.TrimSpace(param), "=")
+			if !ok || strings.TrimSpace(key) != "q" {
+				continue
+			}
+			if w, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+				weight = w
+			}
+		}
+		if l, ok := Parse(tag); ok && weight > bestWeight {
+			best, bestWeight = l, weight
+		}
+	}
+	return best, best != ""
+}
@@ -0,0 +1,214 @@
+package logging
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequestIDHeader carries the request ID in both directions
+const RequestIDHeader = "X-Request-ID"
+
+// RequestIDKey is the gin context key the request ID is stored under
+const RequestIDKey = "request_id"
+
+// maxLoggedBody caps the request body logged at debug level
+const maxLoggedBody = 8 << 10
+
+// redacted replaces secrets in logged bodies and query strings
+const redacted = "[REDACTED]"
+
+// RequestID gives every request an ID, kept from the X-Request-ID header
+// when a proxy in front already set a usable one, and echoes it back. The
+// ID is added to the request's logger.
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(RequestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		c.Set(RequestIDKey, id)
+		c.Header(RequestIDHeader, id)
+		With(c, RequestIDKey, id)
+		c.Next()
+	}
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, r := range id {
+		if r < '!' || r > '~' {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "unknown"
+	}
+	return hex.EncodeToString(b)
+}
+
+// With adds args to the logger of the request, for every later record
+// including its access log line
+func With(c *gin.Context, args ...any) {
+	c.Request = c.Request.WithContext(WithAttrs(c.Request.Context(), args...))
+}
+
+// From returns the logger of the request
+func From(c *gin.Context) *slog.Logger {
+	return Ctx(c.Request.Context())
+}
+
+// Middleware logs one line per request. 5xx responses are logged as
+// errors and 4xx as warnings. At debug level JSON request bodies are
+// logged too, with passwords and tokens redacted.
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+
+		var body json.RawMessage
+		if From(c).Enabled(c.Request.Context(), slog.LevelDebug) {
+			body = peekJSONBody(c.Request)
+		}
+
+		c.Next()
+
+		status := c.Writer.Status()
+		level := slog.LevelInfo
+		switch {
+		case status >= http.StatusInternalServerError:
+			level = slog.LevelError
+		case status >= http.StatusBadRequest:
+			level = slog.LevelWarn
+		}
+
+		attrs := []slog.Attr{
+			slog.String("method", c.Request.Method),
+			slog.String("path", c.Request.URL.Path),
+			slog.Int("status", status),
+			slog.Float64("duration_ms", float64(time.Since(start).Microseconds())/1000),
+			slog.String("client_ip", c.ClientIP()),
+			slog.Int("bytes", max(c.Writer.Size(), 0)),
+		}
+		if c.Request.URL.RawQuery != "" {
+			attrs = append(attrs, slog.String("query", RedactQuery(c.Request.URL.Query())))
+		}
+		if body != nil {
+			attrs = append(attrs, slog.Any("body", body))
+		}
+		From(c).LogAttrs(c.Request.Context(), level, "request", attrs...)
+	}
+}
+
+// Recovery turns a panic in a handler into a 500 and logs it with the
+// stack, in place of gin's text-only recovery
+func Recovery() gin.HandlerFunc {
+	return gin.CustomRecoveryWithWriter(io.Discard, func(c *gin.Context, recovered any) {
+		From(c).Error("panic", "error", recovered, "stack", string(debug.Stack()))
+		c.AbortWithStatus(http.StatusInternalServerError)
+	})
+}
+
+// peekJSONBody reads a JSON request body for logging and puts it back for
+// the handler. Bodies that are too large or not valid JSON are left out.
+func peekJSONBody(r *http.Request) json.RawMessage {
+	if r.Body == nil || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		return nil
+	}
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxLoggedBody+1))
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(data), r.Body), r.Body}
+	if err != nil || len(data) > maxLoggedBody {
+		return nil
+	}
+	return RedactJSON(data)
+}
+
+// RedactJSON replaces the value of every key that looks like a secret
+// (password, token, secret), at any depth. Input that isn't JSON yields
+// nil, since it can't be checked.
+func RedactJSON(data []byte) json.RawMessage {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var v any
+	if err := decoder.Decode(&v); err != nil {
+		return nil
+	}
+	out, err := json.Marshal(redactValue(v))
+	if err != nil {
+		return nil
+	}
+	return out
+}
+
+func redactValue(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for key, value := range v {
+			if sensitive(key) {
+				v[key] = redacted
+			} else {
+				v[key] = redactValue(value)
+			}
+		}
+	case []any:
+		for i, value := range v {
+			v[i] = redactValue(value)
+		}
+	}
+	return v
+}
+
+// RedactQuery encodes query with secrets replaced. Besides the keys
+// RedactJSON hides, this covers the OAuth code and state of the OIDC
+// callback.
+func RedactQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for _, key := range keys {
+		lower := strings.ToLower(key)
+		hide := sensitive(key) || lower == "code" || lower == "state"
+		for _, value := range query[key] {
+			if b.Len() > 0 {
+				b.WriteByte('&')
+			}
+			b.WriteString(url.QueryEscape(key))
+			b.WriteByte('=')
+			if hide {
+				b.WriteString(redacted)
+			} else {
+				b.WriteString(url.QueryEscape(value))
+			}
+		}
+	}
+	return b.String()
+}
+
+func sensitive(key string) bool {
+	key = strings.ToLower(key)
+	return strings.Contains(key, "password") || strings.Contains(key, "token") || strings.Contains(key, "secret")
+}
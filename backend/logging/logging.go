@@ -0,0 +1,64 @@
+package logging
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// Setup makes slog the process-wide logger, writing to w at level (debug,
+// info, warn or error; info if empty or unknown) as JSON, or as text when
+// format is "text". Output of the
+// standard log package goes through it too.
+func Setup(w io.Writer, level, format string) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: ParseLevel(level)}
+
+	var handler slog.Handler
+	if strings.EqualFold(format, "text") {
+		handler = slog.NewTextHandler(w, opts)
+	} else {
+		handler = slog.NewJSONHandler(w, opts)
+	}
+	logger := slog.New(handler)
+	slog.SetDefault(logger)
+	return logger
+}
+
+// ParseLevel reads a level name, defaulting to info
+func ParseLevel(s string) slog.Level {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "debug":
+		return slog.LevelDebug
+	case "warn", "warning":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	}
+	return slog.LevelInfo
+}
+
+// Fatal logs msg at error level and exits, for failures at startup
+func Fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+type contextKey struct{}
+
+// WithAttrs returns a copy of ctx whose logger, as returned by Ctx, adds
+// args to every record
+func WithAttrs(ctx context.Context, args ...any) context.Context {
+	return context.WithValue(ctx, contextKey{}, Ctx(ctx).With(args...))
+}
+
+// Ctx returns the logger stored in ctx by WithAttrs, or the default one
+func Ctx(ctx context.Context) *slog.Logger {
+	if ctx != nil {
+		if logger, ok := ctx.Value(contextKey{}).(*slog.Logger); ok {
+			return logger
+		}
+	}
+	return slog.Default()
+}
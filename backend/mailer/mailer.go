@@ -0,0 +1,154 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"quiz-butterfly/backend/config"
+)
+
+// Message represents a plain-text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer sends email messages
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// SMTPMailer delivers messages through an SMTP server
+type SMTPMailer struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+// Send delivers msg through the configured SMTP server. Authentication is
+// only attempted when a username is set, so a local SMTP stand-in without
+// AUTH support works out of the box.
+func (m *SMTPMailer) Send(ctx context.Context, msg Message) error {
+	addr := net.JoinHostPort(m.Host, m.Port)
+
+	var auth smtp.Auth
+	if m.Username != "" {
+		auth = smtp.PlainAuth("", m.Username, m.Password, m.Host)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- smtp.SendMail(addr, auth, m.From, []string{msg.To}, buildMessage(m.From, msg))
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			return fmt.Errorf("smtp send to %s: %w", msg.To, err)
+		}
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// LogMailer writes messages to a logger instead of sending them
+type LogMailer struct {
+	Logger *slog.Logger
+	// Body logs the message body too. Bodies carry secrets such as reset
+	// links, so this is only for local development.
+	Body bool
+}
+
+// Send logs msg's recipient and subject, and its body if m.Body is set
+func (m *LogMailer) Send(ctx context.Context, msg Message) error {
+	logger := m.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	args := []any{"to", msg.To, "subject", msg.Subject}
+	if m.Body {
+		args = append(args, "body", msg.Body)
+	}
+	logger.InfoContext(ctx, "Mail", args...)
+	return nil
+}
+
+// FileMailer writes each message as an .eml file into Dir
+type FileMailer struct {
+	Dir  string
+	From string
+
+	mu sync.Mutex
+	n  int
+}
+
+// Send writes msg to a new file in Dir
+func (m *FileMailer) Send(ctx context.Context, msg Message) error {
+	if err := os.MkdirAll(m.Dir, 0o755); err != nil {
+		return fmt.Errorf("create mail dir: %w", err)
+	}
+
+	m.mu.Lock()
+	m.n++
+	name := fmt.Sprintf("%s-%03d.eml", time.Now().Format("20060102T150405"), m.n)
+	m.mu.Unlock()
+
+	path := filepath.Join(m.Dir, name)
+	if err := os.WriteFile(path, buildMessage(m.From, msg), 0o644); err != nil {
+		return fmt.Errorf("write mail file: %w", err)
+	}
+	return nil
+}
+
+// New builds the Mailer cfg.Driver names: smtp, file, or log for anything
+// else
+func New(cfg config.Mail) Mailer {
+	switch strings.ToLower(cfg.Driver) {
+	case "smtp":
+		return &SMTPMailer{
+			Host:     cfg.SMTPHost,
+			Port:     strconv.Itoa(cfg.SMTPPort),
+			Username: cfg.SMTPUsername,
+			Password: cfg.SMTPPassword,
+			From:     cfg.From,
+		}
+	case "file":
+		return &FileMailer{Dir: cfg.Dir, From: cfg.From}
+	default:
+		return &LogMailer{Body: cfg.LogBody}
+	}
+}
+
+func buildMessage(from string, msg Message) []byte {
+	var buf bytes.Buffer
+	writeHeader(&buf, "From", from)
+	writeHeader(&buf, "To", msg.To)
+	writeHeader(&buf, "Subject", msg.Subject)
+	writeHeader(&buf, "Date", time.Now().Format(time.RFC1123Z))
+	writeHeader(&buf, "MIME-Version", "1.0")
+	writeHeader(&buf, "Content-Type", "text/plain; charset=UTF-8")
+	buf.WriteString("\r\n")
+	buf.WriteString(strings.ReplaceAll(msg.Body, "\n", "\r\n"))
+	return buf.Bytes()
+}
+
+func writeHeader(w io.Writer, key, value string) {
+	// Strip CR/LF so user-controlled values can't inject extra headers
+	value = strings.NewReplacer("\r", "", "\n", "").Replace(value)
+	fmt.Fprintf(w, "%s: %s\r\n", key, value)
+}
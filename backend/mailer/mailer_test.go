@@ -0,0 +1,32 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestLogMailerKeepsBodyOutOfLogs(t *testing.T) {
+	msg := Message{To: "alice@example.com", Subject: "Reset your password", Body: "https://quiz.test/reset?token=s3cret"}
+
+	var buf bytes.Buffer
+	m := &LogMailer{Logger: slog.New(slog.NewTextHandler(&buf, nil))}
+	if err := m.Send(context.Background(), msg); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(buf.String(), "s3cret") {
+		t.Errorf("log has the body: %s", buf.String())
+	}
+	if !strings.Contains(buf.String(), "alice@example.com") || !strings.Contains(buf.String(), "Reset your password") {
+		t.Errorf("log lacks recipient or subject: %s", buf.String())
+	}
+
+	buf.Reset()
+	m.Body = true
+	m.Send(context.Background(), msg)
+	if !strings.Contains(buf.String(), "s3cret") {
+		t.Errorf("log lacks the body asked for: %s", buf.String())
+	}
+}
@@ -1,35 +1,158 @@
 package main
 
 import (
-	"log"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	// Embedded zone data so DAILY_CHALLENGE_TZ works in minimal images
+	_ "time/tzdata"
 
+	"quiz-butterfly/backend/apierror"
+	"quiz-butterfly/backend/config"
 	"quiz-butterfly/backend/database"
+	"quiz-butterfly/backend/events"
 	"quiz-butterfly/backend/handlers"
+	"quiz-butterfly/backend/live"
+	"quiz-butterfly/backend/logging"
+	"quiz-butterfly/backend/mailer"
+	"quiz-butterfly/backend/metrics"
+	"quiz-butterfly/backend/ratelimit"
+	"quiz-butterfly/backend/storage"
+	"quiz-butterfly/backend/tracing"
 
 	"github.com/gin-gonic/gin"
 )
 
 func main() {
+	configPath := flag.String("config", "", "YAML or TOML config file (default $CONFIG_FILE)")
+	printConfig := flag.Bool("print-config", false, "print the effective configuration, secrets redacted, and exit")
+	flag.Parse()
+
+	// Defaults, the config file, .env and the environment, validated
+	// before anything starts
+	cfg, err := config.Load(*configPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if *printConfig {
+		out, err := cfg.YAML()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Stdout.Write(out)
+		return
+	}
+
+	logging.Setup(os.Stdout, cfg.Log.Level, cfg.Log.Format)
+	slog.Info("Configuration loaded", "files", cfg.Files, "config", cfg)
+	for _, warning := range cfg.Warnings() {
+		slog.Warn(warning)
+	}
+
+	// Tracing, exported as OTEL_TRACES_EXPORTER says
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing.Exporter)
+	if err != nil {
+		logging.Fatal("Failed to set up tracing", "error", err)
+	}
+
 	// Initialize database
-	database.InitDB()
-	defer database.CloseDB()
+	database.InitDB(cfg.Database)
+
+	// Question image attachments
+	blobStore, err := storage.NewLocalStore(cfg.Attachments.Dir)
+	if err != nil {
+		logging.Fatal("Failed to open attachment store", "error", err)
+	}
+
+	// Change notifications for /api/events
+	eventBus := events.NewBus()
+
+	// Live multiplayer rooms
+	liveHub := live.NewMemoryHub()
+
+	// Handlers get their settings from cfg: token signing, password reset
+	// mail, credential rules, OIDC login when an issuer is set, attachment
+	// limits and the daily challenge time zone
+	h, err := handlers.New(cfg, handlers.Deps{
+		DB:     database.GetDB(),
+		Mailer: mailer.New(cfg.Mail),
+		Blobs:  blobStore,
+		Events: eventBus,
+		Live:   liveHub,
+	})
+	if err != nil {
+		logging.Fatal("Failed to set up handlers", "error", err)
+	}
+
+	// Remove guests that never upgraded to a full account, until shutdown
+	// cancels workers
+	workers, stopWorkers := context.WithCancel(context.Background())
+	var workersDone sync.WaitGroup
+	workersDone.Add(1)
+	go func() {
+		defer workersDone.Done()
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-workers.Done():
+				return
+			case <-ticker.C:
+			}
+			removed, err := handlers.CleanupGuests(workers, database.GetDB(), 7*24*time.Hour)
+			if err != nil {
+				slog.Error("Guest cleanup failed", "error", err)
+			} else if removed > 0 {
+				slog.Info("Removed expired guest accounts", "count", removed)
+			}
+		}
+	}()
 
 	// Set Gin mode
-	ginMode := os.Getenv("GIN_MODE")
-	if ginMode == "" {
-		ginMode = "debug"
+	gin.SetMode(cfg.Server.Mode)
+	// Gin's own debug output goes through slog as well
+	gin.DebugPrintRouteFunc = func(method, path, handler string, _ int) {
+		slog.Debug("Route registered", "method", method, "path", path, "handler", handler)
+	}
+	gin.DebugPrintFunc = func(format string, values ...any) {
+		slog.Debug(strings.TrimSpace(fmt.Sprintf(strings.TrimPrefix(format, "[WARNING] "), values...)))
 	}
-	gin.SetMode(ginMode)
 
-	// Initialize Gin router
-	r := gin.Default()
+	// Report validation errors under the JSON field names clients send
+	apierror.RegisterJSONFieldNames()
 
-	// CORS middleware
+	// Initialize Gin router with request IDs, a trace span per request,
+	// JSON access logs, request metrics, panic recovery, and the response
+	// for errors handlers report with c.Error
+	r := gin.New()
+	// c.ClientIP() keys the per-IP rate limits and lockouts, so
+	// X-Forwarded-For only counts when a configured proxy sent it
+	if err := r.SetTrustedProxies(cfg.Server.TrustedProxies); err != nil {
+		logging.Fatal("Invalid trusted proxies", "error", err)
+	}
+	r.Use(logging.RequestID(), tracing.Middleware(), tracing.LogTraceID(), logging.Middleware(),
+		metrics.Middleware(), logging.Recovery(), apierror.Middleware())
+
+	// CORS middleware, for the origins in CORS_ORIGINS
 	r.Use(func(c *gin.Context) {
-		c.Header("Access-Control-Allow-Origin", "*")
+		if origin := c.GetHeader("Origin"); origin != "" && cfg.Server.AllowsOrigin(origin) {
+			c.Header("Access-Control-Allow-Origin", origin)
+			c.Header("Vary", "Origin")
+		}
 		c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, DELETE, OPTIONS")
-		c.Header("Access-Control-Allow-Headers", "Origin, Content-Type, Authorization")
+		c.Header("Access-Control-Allow-Headers", "Origin, Content-Type, Authorization, X-Request-ID")
+		c.Header("Access-Control-Expose-Headers", "X-Request-ID")
 
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(204)
@@ -39,7 +162,7 @@ func main() {
 		c.Next()
 	})
 
-	// Health check endpoint
+	// Health check endpoint; only says the process is up, like /livez
 	r.GET("/health", func(c *gin.Context) {
 		c.JSON(200, gin.H{
 			"status":  "ok",
@@ -47,38 +170,195 @@ func main() {
 		})
 	})
 
+	// Probes for the orchestrator: restart when /livez fails, hold traffic
+	// back while /readyz does
+	r.GET("/livez", h.LivenessHandler)
+	r.GET("/readyz", h.ReadinessHandler)
+
+	// Prometheus metrics, on the API port unless METRICS_ADDR gives them a
+	// port of their own, and behind METRICS_TOKEN when it is set
+	metrics.RegisterDB(database.GetDB())
+	metricsHandler := metrics.Handler(cfg.Metrics.Token)
+	var metricsServer *http.Server
+	if addr := cfg.Metrics.Addr; addr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metricsHandler)
+		metricsServer = &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+		go func() {
+			slog.Info("Metrics server starting", "addr", addr)
+			if err := metricsServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				logging.Fatal("Metrics server stopped", "error", err)
+			}
+		}()
+	} else {
+		r.GET("/metrics", gin.WrapH(metricsHandler))
+	}
+
+	// Rate limit state: in-memory by default, Postgres to share it between instances
+	var limitStore ratelimit.Store = ratelimit.NewMemoryStore()
+	if strings.EqualFold(cfg.RateLimit.Store, "postgres") {
+		limitStore = ratelimit.NewPostgresStore(database.GetDB())
+	}
+
 	// Auth routes
 	auth := r.Group("/auth")
+	auth.Use(ratelimit.Middleware(limitStore, ratelimit.Config{
+		Name:      "auth",
+		IPLimit:   30,
+		UserLimit: 10,
+		Window:    time.Minute,
+	}))
 	{
-		auth.POST("/register", handlers.RegisterHandler)
-		auth.POST("/login", handlers.LoginHandler)
+		auth.POST("/register", h.RegisterHandler)
+		auth.POST("/login", ratelimit.Middleware(limitStore, ratelimit.Config{
+			Name: "login",
+			Lockout: &ratelimit.Lockout{
+				Threshold:     5,
+				Base:          30 * time.Second,
+				Max:           time.Hour,
+				Decay:         15 * time.Minute,
+				FailureStatus: 401,
+			},
+		}), h.LoginHandler)
+
+		auth.POST("/guest", h.GuestLoginHandler)
+		auth.POST("/guest/upgrade", h.AuthMiddleware(), h.UpgradeGuestHandler)
+		auth.GET("/oidc/login", h.OIDCLoginHandler)
+		auth.GET("/oidc/callback", h.OIDCCallbackHandler)
+
+		password := auth.Group("/password")
+		password.Use(ratelimit.Middleware(limitStore, ratelimit.Config{
+			Name:    "password",
+			IPLimit: 5,
+			Window:  15 * time.Minute,
+		}))
+		{
+			password.POST("/forgot", h.ForgotPasswordHandler)
+			password.POST("/reset", h.ResetPasswordHandler)
+		}
 	}
 
+	// Live quiz socket; the token comes from the query string because
+	// browsers can't send headers on WebSocket requests
+	r.GET("/live/rooms/:pin/ws", h.QueryTokenMiddleware(), h.AuthMiddleware(), h.LiveRoomSocketHandler)
+
+	// Server-Sent Events; EventSource can't send headers either, so the
+	// token may come from the query string
+	r.GET("/api/events", h.QueryTokenMiddleware(), h.AuthMiddleware(), h.EventsHandler)
+
+	// Attachment images are loaded by <img> tags, which can't send headers,
+	// so the signed link in the attachment's URL stands in for the token
+	r.GET("/api/attachments/:id", h.DownloadAttachmentHandler)
+
 	// Protected routes
 	api := r.Group("/api")
-	api.Use(handlers.AuthMiddleware())
+	api.Use(h.AuthMiddleware())
 	{
-		api.GET("/profile", handlers.GetProfileHandler)
-		api.POST("/quiz/start", handlers.StartQuizHandler)
-		api.POST("/quiz/answer", handlers.SubmitAnswerHandler)
-		api.GET("/quiz/progress", handlers.GetQuizProgressHandler)
-		api.POST("/quiz/finish", handlers.FinishQuizHandler)
+		api.GET("/profile", h.GetProfileHandler)
+		api.PUT("/profile/locale", h.UpdateLocaleHandler)
+		api.POST("/quiz/start", h.StartQuizHandler)
+		api.POST("/quiz/answer", h.SubmitAnswerHandler)
+		api.GET("/quiz/progress", h.GetQuizProgressHandler)
+		api.POST("/quiz/finish", h.FinishQuizHandler)
+
+		// Daily challenge; answers and finishing use the quiz routes above
+		api.GET("/daily", h.GetDailyChallengeHandler)
+		api.POST("/daily/start", h.StartDailyChallengeHandler)
+		api.GET("/daily/leaderboard", h.GetDailyLeaderboardHandler)
 		// Admin routes
 		admin := api.Group("/admin")
-		admin.Use(handlers.AdminMiddleware())
+		admin.Use(h.AdminMiddleware())
+		{
+			admin.GET("/questions", h.ListQuestionsHandler)
+			admin.POST("/questions", h.CreateQuestionHandler)
+			admin.POST("/questions/import", h.ImportQuestionsHandler)
+			admin.GET("/questions/duplicates", h.GetDuplicateQuestionsHandler)
+			admin.PUT("/questions/:id", h.UpdateQuestionHandler)
+			admin.DELETE("/questions/:id", h.DeleteQuestionHandler)
+			admin.POST("/questions/:id/attachments", h.UploadAttachmentHandler)
+			admin.DELETE("/attachments/:id", h.DeleteAttachmentHandler)
+			admin.GET("/questions/:id/translations", h.GetQuestionTranslationsHandler)
+			admin.PUT("/questions/:id/translations/:locale", h.PutQuestionTranslationHandler)
+			admin.DELETE("/questions/:id/translations/:locale", h.DeleteQuestionTranslationHandler)
+			admin.PUT("/users/:id/role", h.UpdateUserRoleHandler)
+		}
+		// Class routes
+		classes := api.Group("/classes")
+		classes.Use(h.RegisteredUserMiddleware())
 		{
-			admin.POST("/questions", handlers.CreateQuestionHandler)
-			admin.PUT("/questions/:id", handlers.UpdateQuestionHandler)
-			admin.DELETE("/questions/:id", handlers.DeleteQuestionHandler)
+			classes.GET("", h.ListClassesHandler)
+			classes.POST("", h.TeacherMiddleware(), h.CreateClassHandler)
+			classes.POST("/join", h.JoinClassHandler)
+			classes.GET("/:id", h.GetClassHandler)
+			classes.DELETE("/:id/members/:user_id", h.RemoveClassMemberHandler)
+			classes.POST("/:id/assignments", h.TeacherMiddleware(), h.CreateAssignmentHandler)
+			classes.GET("/:id/results", h.TeacherMiddleware(), h.GetClassResultsHandler)
 		}
-		api.GET("/questions/:difficulty", handlers.GetQuestionsHandler)
+		api.GET("/questions/:difficulty", h.RegisteredUserMiddleware(), h.GetQuestionsHandler)
+		api.GET("/leaderboard/:difficulty", h.GetLeaderboardHandler)
+		api.POST("/live/rooms", h.RegisteredUserMiddleware(), h.CreateLiveRoomHandler)
+	}
+
+	port := cfg.Server.Port
+	// How long SIGTERM waits for in-flight requests before cutting them off
+	drainTimeout := cfg.Server.ShutdownTimeout
+
+	srv := &http.Server{Addr: fmt.Sprintf(":%d", port), Handler: r, ReadHeaderTimeout: 10 * time.Second}
+	// Event streams and live rooms never finish on their own, so end them
+	// as soon as shutdown starts instead of letting them hold up the drain
+	srv.RegisterOnShutdown(eventBus.Close)
+	srv.RegisterOnShutdown(liveHub.Close)
+
+	serveErr := make(chan error, 1)
+	go func() {
+		slog.Info("Server starting", "port", port)
+		serveErr <- srv.ListenAndServe()
+	}()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+
+	exitCode := 0
+	select {
+	case sig := <-signals:
+		slog.Info("Shutting down", "signal", sig.String(), "drain_timeout", drainTimeout)
+	case err := <-serveErr:
+		slog.Error("Server stopped", "error", err)
+		exitCode = 1
+	}
+	signal.Stop(signals)
+
+	// Stop taking requests and let the ones in flight finish, so answers
+	// being submitted are still saved
+	ctx, cancel := context.WithTimeout(context.Background(), drainTimeout)
+	if err := srv.Shutdown(ctx); err != nil {
+		slog.Error("Requests still running at drain timeout", "error", err)
+		exitCode = 1
+	}
+	if metricsServer != nil {
+		if err := metricsServer.Shutdown(ctx); err != nil {
+			slog.Error("Failed to stop metrics server", "error", err)
+		}
+	}
+
+	// Then background work: the guest cleanup, and reset emails the
+	// drained requests queued
+	stopWorkers()
+	workersDone.Wait()
+	if err := h.WaitForMail(ctx); err != nil {
+		slog.Error("Reset emails still sending at drain timeout", "error", err)
+		exitCode = 1
 	}
+	cancel()
 
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+	flushCtx, cancelFlush := context.WithTimeout(context.Background(), 5*time.Second)
+	if err := shutdownTracing(flushCtx); err != nil {
+		slog.Error("Failed to flush traces", "error", err)
 	}
+	cancelFlush()
 
-	log.Printf("Server starting on port %s", port)
-	log.Fatal(r.Run(":" + port))
+	// The database goes last, once nothing can use it any more
+	database.CloseDB()
+	slog.Info("Server stopped")
+	os.Exit(exitCode)
 }
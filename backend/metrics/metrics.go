@@ -0,0 +1,131 @@
+package metrics
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// namespace prefixes every metric of the API
+const namespace = "quiz"
+
+// registry holds the API's metrics, apart from anything libraries register
+// on the global default registry
+var registry = prometheus.NewRegistry()
+
+var (
+	httpDuration = promauto.With(registry).NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Time to serve HTTP requests, by route and status.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	quizzesStarted = promauto.With(registry).NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "quizzes_started_total",
+		Help:      "Quiz sessions started, by difficulty and mode.",
+	}, []string{"difficulty", "mode"})
+
+	quizzesFinished = promauto.With(registry).NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "quizzes_finished_total",
+		Help:      "Quiz sessions finished, by difficulty and mode.",
+	}, []string{"difficulty", "mode"})
+
+	answers = promauto.With(registry).NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "answers_total",
+		Help:      "Answers submitted, by result (correct or incorrect).",
+	}, []string{"result"})
+
+	logins = promauto.With(registry).NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "logins_total",
+		Help:      "Login attempts, by method (password, guest or oidc) and result (success or failure).",
+	}, []string{"method", "result"})
+)
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+}
+
+// RegisterDB exports the connection pool stats of db
+func RegisterDB(db *sql.DB) {
+	registry.MustRegister(collectors.NewDBStatsCollector(db, "postgres"))
+}
+
+// QuizStarted counts a new quiz session
+func QuizStarted(difficulty, mode string) {
+	quizzesStarted.WithLabelValues(difficulty, mode).Inc()
+}
+
+// QuizFinished counts a finished quiz session
+func QuizFinished(difficulty, mode string) {
+	quizzesFinished.WithLabelValues(difficulty, mode).Inc()
+}
+
+// Answer counts a graded answer
+func Answer(correct bool) {
+	result := "incorrect"
+	if correct {
+		result = "correct"
+	}
+	answers.WithLabelValues(result).Inc()
+}
+
+// Login counts a login attempt through method
+func Login(method string, success bool) {
+	result := "failure"
+	if success {
+		result = "success"
+	}
+	logins.WithLabelValues(method, result).Inc()
+}
+
+// Middleware records the duration of every request under its route
+// pattern, so /api/questions/:id is one series however many IDs are
+// requested. Requests that match no route share the route "unmatched".
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		httpDuration.WithLabelValues(c.Request.Method, route, strconv.Itoa(c.Writer.Status())).
+			Observe(time.Since(start).Seconds())
+	}
+}
+
+// Handler serves the metrics in the Prometheus text format. When token is
+// set, scrapes must send it as a bearer token.
+func Handler(token string) http.Handler {
+	metrics := promhttp.HandlerFor(registry, promhttp.HandlerOpts{Registry: registry})
+	if token == "" {
+		return metrics
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		given, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="metrics"`)
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		metrics.ServeHTTP(w, r)
+	})
+}
@@ -8,11 +8,17 @@ import (
 
 // User represents a user in the system
 type User struct {
-	ID           int       `json:"id" db:"id"`
-	Username     string    `json:"username" db:"username"`
-	PasswordHash string    `json:"-" db:"password_hash"`
-	CreatedAt    time.Time `json:"created_at" db:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at" db:"updated_at"`
+	ID           int     `json:"id" db:"id"`
+	Username     string  `json:"username" db:"username"`
+	Email        *string `json:"email,omitempty" db:"email"`
+	PasswordHash string  `json:"-" db:"password_hash"`
+	IsGuest      bool    `json:"is_guest" db:"is_guest"`
+	Role         string  `json:"role" db:"role"`
+	// Locale is the preferred language for questions; unset follows the
+	// browser's Accept-Language
+	Locale    *string   `json:"locale,omitempty" db:"locale"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
 }
 
 // HighScore represents a user's high score for a difficulty level
@@ -20,32 +26,156 @@ type HighScore struct {
 	ID         int       `json:"id" db:"id"`
 	UserID     int       `json:"user_id" db:"user_id"`
 	Difficulty string    `json:"difficulty" db:"difficulty"`
-	Score      int       `json:"score" db:"score"`
+	Score      float64   `json:"score" db:"score"`
 	CreatedAt  time.Time `json:"created_at" db:"created_at"`
 }
 
 // Question represents a quiz question
 type Question struct {
-	ID                 int            `json:"id" db:"id"`
-	QuestionText       string         `json:"question_text" db:"question_text"`
+	ID           int    `json:"id" db:"id"`
+	QuestionText string `json:"question_text" db:"question_text"`
+	// Type is single_choice (default), multiple_select, true_false,
+	// numeric or short_text; see the grading package
+	Type               string         `json:"type" db:"question_type"`
 	Options            pq.StringArray `json:"options" db:"options"`
 	CorrectAnswerIndex int            `json:"correct_answer_index" db:"correct_answer_index"`
-	Reference          string         `json:"reference" db:"reference"`
-	Difficulty         string         `json:"difficulty" db:"difficulty"`
-	CreatedAt          time.Time      `json:"created_at" db:"created_at"`
+	// CorrectAnswerIndices holds every correct option of a multiple_select
+	CorrectAnswerIndices pq.Int64Array `json:"correct_answer_indices,omitempty" db:"correct_answer_indices"`
+	// NumericAnswer and NumericTolerance grade numeric questions
+	NumericAnswer    *float64 `json:"numeric_answer,omitempty" db:"numeric_answer"`
+	NumericTolerance *float64 `json:"numeric_tolerance,omitempty" db:"numeric_tolerance"`
+	// AcceptedAnswers are the answers a short_text question takes,
+	// compared ignoring case, punctuation and spacing
+	AcceptedAnswers pq.StringArray `json:"accepted_answers,omitempty" db:"accepted_answers"`
+	Reference       string         `json:"reference" db:"reference"`
+	// Explanation is markdown shown after the question is answered
+	Explanation string `json:"explanation,omitempty" db:"explanation"`
+	Difficulty  string `json:"difficulty" db:"difficulty"`
+	// Locale is the language of the text, options and explanation: the one
+	// the question was written in, or the translation shown in its place
+	Locale      string               `json:"locale" db:"locale"`
+	Attachments []QuestionAttachment `json:"attachments,omitempty"`
+	CreatedAt   time.Time            `json:"created_at" db:"created_at"`
+}
+
+// PlayerQuestion is a question as sent to someone answering it: nothing
+// that gives the answer away. The answer, reference and explanation come
+// back in AnswerFeedback once it is answered.
+type PlayerQuestion struct {
+	ID           int                  `json:"id"`
+	QuestionText string               `json:"question_text"`
+	Type         string               `json:"type"`
+	Options      []string             `json:"options"`
+	Difficulty   string               `json:"difficulty"`
+	Locale       string               `json:"locale"`
+	Attachments  []QuestionAttachment `json:"attachments,omitempty"`
+}
+
+// ForPlayer returns the question without its answers
+func (q *Question) ForPlayer() *PlayerQuestion {
+	options := []string(q.Options)
+	if options == nil {
+		options = []string{}
+	}
+	return &PlayerQuestion{
+		ID:           q.ID,
+		QuestionText: q.QuestionText,
+		Type:         q.Type,
+		Options:      options,
+		Difficulty:   q.Difficulty,
+		Locale:       q.Locale,
+		Attachments:  q.Attachments,
+	}
+}
+
+// QuestionTranslation represents a question's content in another language.
+// Options line up with the question's own by index, so answers given in
+// any language grade the same.
+type QuestionTranslation struct {
+	QuestionID   int            `json:"question_id" db:"question_id"`
+	Locale       string         `json:"locale" db:"locale"`
+	QuestionText string         `json:"question_text" db:"question_text"`
+	Options      pq.StringArray `json:"options,omitempty" db:"options"`
+	// AcceptedAnswers are extra short_text answers in this language
+	AcceptedAnswers pq.StringArray `json:"accepted_answers,omitempty" db:"accepted_answers"`
+	Explanation     string         `json:"explanation,omitempty" db:"explanation"`
+	UpdatedAt       time.Time      `json:"updated_at" db:"updated_at"`
+}
+
+// QuestionAttachment represents an image attached to a question
+type QuestionAttachment struct {
+	ID          int       `json:"id" db:"id"`
+	QuestionID  int       `json:"question_id" db:"question_id"`
+	BlobKey     string    `json:"-" db:"blob_key"`
+	Filename    string    `json:"filename" db:"filename"`
+	ContentType string    `json:"content_type" db:"content_type"`
+	SizeBytes   int64     `json:"size_bytes" db:"size_bytes"`
+	URL         string    `json:"url"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+}
+
+// QuestionPage represents one page of the admin question list
+type QuestionPage struct {
+	Questions  []Question `json:"questions"`
+	NextCursor string     `json:"next_cursor,omitempty"`
+}
+
+// QuestionImportRequest represents a batch of questions to create
+type QuestionImportRequest struct {
+	Questions []Question `json:"questions" binding:"required,min=1,max=500"`
+}
+
+// SimilarQuestion represents an existing question close to a new one
+type SimilarQuestion struct {
+	ID           int     `json:"id"`
+	QuestionText string  `json:"question_text"`
+	Difficulty   string  `json:"difficulty"`
+	Similarity   float64 `json:"similarity"`
+	Exact        bool    `json:"exact"`
+}
+
+// SkippedQuestion represents an import item left out as a duplicate
+type SkippedQuestion struct {
+	Index   int               `json:"index"`
+	Reason  string            `json:"reason"` // duplicate or similar
+	Similar []SimilarQuestion `json:"similar"`
+}
+
+// QuestionImportResult represents the outcome of a question import
+type QuestionImportResult struct {
+	Created []Question        `json:"created"`
+	Skipped []SkippedQuestion `json:"skipped"`
+}
+
+// DuplicatePair represents two existing questions that look alike
+type DuplicatePair struct {
+	QuestionID  int     `json:"question_id"`
+	DuplicateID int     `json:"duplicate_id"`
+	Similarity  float64 `json:"similarity"`
+}
+
+// DuplicateCluster represents a group of questions linked by similarity
+type DuplicateCluster struct {
+	Questions []Question      `json:"questions"`
+	Pairs     []DuplicatePair `json:"pairs"`
 }
 
 // QuizSession represents a quiz session
 type QuizSession struct {
-	ID                   int        `json:"id" db:"id"`
-	UserID               int        `json:"user_id" db:"user_id"`
-	Difficulty           string     `json:"difficulty" db:"difficulty"`
-	CurrentQuestionIndex int        `json:"current_question_index" db:"current_question_index"`
-	Score                int        `json:"score" db:"score"`
-	Status               string     `json:"status" db:"status"`
-	StartedAt            time.Time  `json:"started_at" db:"started_at"`
-	FinishedAt           *time.Time `json:"finished_at,omitempty" db:"finished_at"`
-	CreatedAt            time.Time  `json:"created_at" db:"created_at"`
+	ID            int        `json:"id" db:"id"`
+	UserID        int        `json:"user_id" db:"user_id"`
+	Difficulty    string     `json:"difficulty" db:"difficulty"`
+	Mode          string     `json:"mode" db:"mode"`
+	ChallengeDate *time.Time `json:"challenge_date,omitempty" db:"challenge_date"`
+	// QuestionIDs fixes the questions and their order; empty means every
+	// question of Difficulty by ID
+	QuestionIDs          pq.Int64Array `json:"-" db:"question_ids"`
+	CurrentQuestionIndex int           `json:"current_question_index" db:"current_question_index"`
+	Score                float64       `json:"score" db:"score"`
+	Status               string        `json:"status" db:"status"`
+	StartedAt            time.Time     `json:"started_at" db:"started_at"`
+	FinishedAt           *time.Time    `json:"finished_at,omitempty" db:"finished_at"`
+	CreatedAt            time.Time     `json:"created_at" db:"created_at"`
 }
 
 // UserAnswer represents an answer given by a user in a quiz session
@@ -57,16 +187,56 @@ type UserAnswer struct {
 	UserAnswer    string    `json:"user_answer" db:"user_answer"`
 	CorrectAnswer string    `json:"correct_answer" db:"correct_answer"`
 	IsCorrect     bool      `json:"is_correct" db:"is_correct"`
+	Credit        float64   `json:"credit" db:"credit"`
 	Reference     string    `json:"reference" db:"reference"`
+	Explanation   string    `json:"explanation,omitempty"`
 	AnsweredAt    time.Time `json:"answered_at" db:"answered_at"`
 }
 
+// Class represents a teacher's class that students join with a code
+type Class struct {
+	ID          int       `json:"id" db:"id"`
+	TeacherID   int       `json:"teacher_id" db:"teacher_id"`
+	Name        string    `json:"name" db:"name"`
+	JoinCode    string    `json:"join_code,omitempty" db:"join_code"`
+	MemberCount int       `json:"member_count"`
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+}
+
+// ClassMember represents a student enrolled in a class
+type ClassMember struct {
+	UserID   int       `json:"user_id" db:"user_id"`
+	Username string    `json:"username" db:"username"`
+	JoinedAt time.Time `json:"joined_at" db:"joined_at"`
+}
+
+// ClassAssignment represents work assigned to a class: either a difficulty
+// to finish or a set of questions to answer before DueAt
+type ClassAssignment struct {
+	ID          int           `json:"id" db:"id"`
+	ClassID     int           `json:"class_id" db:"class_id"`
+	Title       string        `json:"title" db:"title"`
+	Difficulty  *string       `json:"difficulty,omitempty" db:"difficulty"`
+	QuestionIDs pq.Int64Array `json:"question_ids,omitempty" db:"question_ids"`
+	DueAt       time.Time     `json:"due_at" db:"due_at"`
+	CreatedAt   time.Time     `json:"created_at" db:"created_at"`
+}
+
+// Achievement represents a badge earned by a user
+type Achievement struct {
+	Code        string    `json:"code"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	AwardedAt   time.Time `json:"awarded_at"`
+}
+
 // API request/response types
 
 // RegisterRequest represents a user registration request
 type RegisterRequest struct {
-	Username string `json:"username" binding:"required,min=3,max=50"`
-	Password string `json:"password" binding:"required,min=6"`
+	Username string `json:"username" binding:"required"`
+	Email    string `json:"email" binding:"omitempty,email,max=255"`
+	Password string `json:"password" binding:"required"`
 }
 
 // LoginRequest represents a user login request
@@ -75,10 +245,30 @@ type LoginRequest struct {
 	Password string `json:"password" binding:"required"`
 }
 
+// ForgotPasswordRequest represents a request to send a password reset email
+type ForgotPasswordRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+// ResetPasswordRequest represents a request to set a new password with a reset token
+type ResetPasswordRequest struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+// GuestUpgradeRequest represents a request to turn a guest into a full account
+type GuestUpgradeRequest struct {
+	Username string `json:"username" binding:"required"`
+	Email    string `json:"email" binding:"omitempty,email,max=255"`
+	Password string `json:"password" binding:"required"`
+}
+
 // AuthResponse represents an authentication response
 type AuthResponse struct {
 	User  User   `json:"user"`
 	Token string `json:"token"`
+	// Warnings about an accepted password, such as bytes bcrypt ignores
+	Warnings []FieldError `json:"warnings,omitempty"`
 }
 
 // QuizStartRequest represents a request to start a quiz
@@ -89,26 +279,183 @@ type QuizStartRequest struct {
 // QuizAnswerRequest represents a request to submit an answer
 type QuizAnswerRequest struct {
 	QuestionID int    `json:"question_id" binding:"required"`
-	Answer     string `json:"answer" binding:"required"`
+	Answer     string `json:"answer" binding:"required_without=Answers"`
+	// Answers holds the chosen options of a multiple_select question
+	Answers []string `json:"answers" binding:"required_without=Answer"`
 }
 
 // QuizProgress represents the current quiz progress
 type QuizProgress struct {
-	SessionID            int          `json:"session_id"`
-	CurrentQuestionIndex int          `json:"current_question_index"`
-	Score                int          `json:"score"`
-	Status               string       `json:"status"`
-	CurrentQuestion      *Question    `json:"current_question,omitempty"`
-	UserAnswers          []UserAnswer `json:"user_answers,omitempty"`
+	SessionID            int             `json:"session_id"`
+	Mode                 string          `json:"mode"`
+	TotalQuestions       int             `json:"total_questions,omitempty"`
+	CurrentQuestionIndex int             `json:"current_question_index"`
+	Score                float64         `json:"score"`
+	Status               string          `json:"status"`
+	CurrentQuestion      *PlayerQuestion `json:"current_question,omitempty"`
+	LastAnswer           *AnswerFeedback `json:"last_answer,omitempty"`
+	UserAnswers          []UserAnswer    `json:"user_answers,omitempty"`
+	NewAchievements      []Achievement   `json:"new_achievements,omitempty"`
+}
+
+// AnswerFeedback represents the result of the answer just submitted
+type AnswerFeedback struct {
+	QuestionID    int                  `json:"question_id"`
+	IsCorrect     bool                 `json:"is_correct"`
+	Credit        float64              `json:"credit"`
+	CorrectAnswer string               `json:"correct_answer"`
+	Reference     string               `json:"reference"`
+	Explanation   string               `json:"explanation,omitempty"`
+	Attachments   []QuestionAttachment `json:"attachments,omitempty"`
+}
+
+// LeaderboardEntry represents one row of a leaderboard
+type LeaderboardEntry struct {
+	Rank     int     `json:"rank"`
+	UserID   int     `json:"user_id"`
+	Username string  `json:"username"`
+	Score    float64 `json:"score"`
+}
+
+// DailyStreak represents a user's run of consecutive daily challenges
+type DailyStreak struct {
+	Current int `json:"current"`
+	Longest int `json:"longest"`
+}
+
+// DailyChallengeStatus represents today's challenge from a user's view
+type DailyChallengeStatus struct {
+	Date           string      `json:"date"`
+	TotalQuestions int         `json:"total_questions"`
+	Status         string      `json:"status"` // not_started, playing, finished or abandoned
+	Score          *float64    `json:"score,omitempty"`
+	Streak         DailyStreak `json:"streak"`
+}
+
+// DailyLeaderboardEntry represents one row of a daily challenge leaderboard
+type DailyLeaderboardEntry struct {
+	Rank            int     `json:"rank"`
+	UserID          int     `json:"user_id"`
+	Username        string  `json:"username"`
+	Score           float64 `json:"score"`
+	DurationSeconds int     `json:"duration_seconds"`
+}
+
+// QuestionTranslationRequest represents a request to save a translation
+type QuestionTranslationRequest struct {
+	QuestionText    string   `json:"question_text" binding:"required"`
+	Options         []string `json:"options"`
+	AcceptedAnswers []string `json:"accepted_answers"`
+	Explanation     string   `json:"explanation"`
+}
+
+// UserLocaleRequest represents a request to set the preferred language;
+// an empty locale goes back to following Accept-Language
+type UserLocaleRequest struct {
+	Locale string `json:"locale"`
+}
+
+// UserRoleRequest represents a request to change a user's role
+type UserRoleRequest struct {
+	Role string `json:"role" binding:"required,oneof=student teacher"`
+}
+
+// ClassCreateRequest represents a request to create a class
+type ClassCreateRequest struct {
+	Name string `json:"name" binding:"required,max=100"`
+}
+
+// ClassJoinRequest represents a request to join a class
+type ClassJoinRequest struct {
+	JoinCode string `json:"join_code" binding:"required"`
+}
+
+// AssignmentCreateRequest represents a request to assign work to a class.
+// Exactly one of Difficulty or QuestionIDs must be set.
+type AssignmentCreateRequest struct {
+	Title       string    `json:"title" binding:"required,max=200"`
+	Difficulty  string    `json:"difficulty" binding:"omitempty,oneof=easy medium advance"`
+	QuestionIDs []int64   `json:"question_ids"`
+	DueAt       time.Time `json:"due_at" binding:"required"`
+}
+
+// ClassDetail represents a class with its members and assignments
+type ClassDetail struct {
+	Class       Class             `json:"class"`
+	Members     []ClassMember     `json:"members,omitempty"`
+	Assignments []ClassAssignment `json:"assignments"`
+}
+
+// StudentAssignmentResult represents one student's progress on an assignment
+type StudentAssignmentResult struct {
+	UserID   int    `json:"user_id"`
+	Username string `json:"username"`
+	// Attempts and BestScore are set for difficulty assignments
+	Attempts  int      `json:"attempts"`
+	BestScore *float64 `json:"best_score,omitempty"`
+	// Answered and Correct count distinct questions of a question set
+	Answered    int        `json:"answered"`
+	Correct     int        `json:"correct"`
+	Completed   bool       `json:"completed"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+}
+
+// AssignmentResults represents all students' results for one assignment
+type AssignmentResults struct {
+	Assignment     ClassAssignment           `json:"assignment"`
+	CompletedCount int                       `json:"completed_count"`
+	Students       []StudentAssignmentResult `json:"students"`
+}
+
+// ClassDashboard represents the per-class results overview for a teacher
+type ClassDashboard struct {
+	Class       Class               `json:"class"`
+	Assignments []AssignmentResults `json:"assignments"`
+}
+
+// LiveRoomCreateRequest represents a request to host a live quiz room
+type LiveRoomCreateRequest struct {
+	Difficulty          string `json:"difficulty" binding:"required,oneof=easy medium advance"`
+	QuestionCount       int    `json:"question_count" binding:"omitempty,min=1,max=50"`
+	QuestionTimeSeconds int    `json:"question_time_seconds" binding:"omitempty,min=5,max=120"`
+}
+
+// LiveRoomResponse represents a newly opened live quiz room
+type LiveRoomResponse struct {
+	PIN                 string `json:"pin"`
+	Difficulty          string `json:"difficulty"`
+	QuestionCount       int    `json:"question_count"`
+	QuestionTimeSeconds int    `json:"question_time_seconds"`
 }
 
 // UserProfile represents user profile information
 type UserProfile struct {
-	User       User        `json:"user"`
-	HighScores []HighScore `json:"high_scores"`
+	User         User          `json:"user"`
+	HighScores   []HighScore   `json:"high_scores"`
+	Achievements []Achievement `json:"achievements"`
 }
 
-// ErrorResponse represents an error response
+// ErrorResponse represents an error response. Code is stable for clients
+// to act on; Error is a message in the request's language. Internal errors
+// carry the request ID, which finds the cause in the server log.
 type ErrorResponse struct {
-	Error string `json:"error"`
+	Code      string       `json:"code"`
+	Error     string       `json:"error"`
+	Details   []FieldError `json:"details,omitempty"`
+	RequestID string       `json:"request_id,omitempty"`
+}
+
+// FieldError represents a problem with one request field
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Param   string `json:"param,omitempty"`
+	Message string `json:"message"`
+}
+
+// DuplicateQuestionResponse represents a question rejected as a duplicate,
+// with the existing questions it matched
+type DuplicateQuestionResponse struct {
+	ErrorResponse
+	Similar []SimilarQuestion `json:"similar"`
 }
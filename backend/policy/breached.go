@@ -0,0 +1,94 @@
+package policy
+
+import (
+	"bufio"
+	"crypto/sha1"
+	_ "embed"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+//go:embed breached.txt
+var bundledBreached string
+
+// BreachedList is an offline set of breached password hashes. Entries are
+// SHA-1 hashes bucketed by their first five hex characters, the same
+// k-anonymity layout used by the Pwned Passwords range API, so a full
+// downloaded dump can be dropped in without conversion.
+type BreachedList struct {
+	ranges map[string]map[string]struct{}
+}
+
+// NewBreachedList parses lines of the form "SHA1" or "SHA1:COUNT"
+func NewBreachedList(r io.Reader) (*BreachedList, error) {
+	list := &BreachedList{ranges: make(map[string]map[string]struct{})}
+
+	scanner := bufio.NewScanner(r)
+	line := 0
+	for scanner.Scan() {
+		line++
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		hash, _, _ := strings.Cut(text, ":")
+		if len(hash) != 40 {
+			return nil, fmt.Errorf("line %d: expected a 40 character SHA-1 hash", line)
+		}
+		list.add(strings.ToUpper(hash))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// BundledBreachedList returns the list shipped with the binary
+func BundledBreachedList() *BreachedList {
+	list, err := NewBreachedList(strings.NewReader(bundledBreached))
+	if err != nil {
+		panic("policy: invalid bundled breached list: " + err.Error())
+	}
+	return list
+}
+
+// LoadBreachedList reads a breached list from path
+func LoadBreachedList(path string) (*BreachedList, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return NewBreachedList(f)
+}
+
+// Contains reports whether password appears in the list
+func (l *BreachedList) Contains(password string) bool {
+	if l == nil {
+		return false
+	}
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	_, found := l.ranges[hash[:5]][hash[5:]]
+	return found
+}
+
+// Len returns the number of hashes in the list
+func (l *BreachedList) Len() int {
+	n := 0
+	for _, suffixes := range l.ranges {
+		n += len(suffixes)
+	}
+	return n
+}
+
+func (l *BreachedList) add(hash string) {
+	prefix, suffix := hash[:5], hash[5:]
+	if l.ranges[prefix] == nil {
+		l.ranges[prefix] = make(map[string]struct{})
+	}
+	l.ranges[prefix][suffix] = struct{}{}
+}
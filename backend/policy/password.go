@@ -0,0 +1,129 @@
+package policy
+
+import (
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// BcryptMaxBytes is the longest input bcrypt uses. Longer passwords are
+// accepted with a warning and hashed on their first BcryptMaxBytes bytes.
+const BcryptMaxBytes = 72
+
+// Rules a username or password can break. They are named like the field
+// error codes clients receive, so handlers can pass them on unchanged.
+const (
+	TooShort         = "too_short"
+	TooLongBytes     = "too_long_bytes"
+	LengthBetween    = "length_between"
+	NeedsUpper       = "needs_uppercase"
+	NeedsLower       = "needs_lowercase"
+	NeedsDigit       = "needs_digit"
+	NeedsSymbol      = "needs_symbol"
+	ContainsUsername = "contains_username"
+	Breached         = "breached"
+	BadCharacters    = "invalid_characters"
+	Reserved         = "reserved"
+)
+
+// Problem is one rule a field broke. Param is the rule's limit, if any.
+type Problem struct {
+	Field string
+	Rule  string
+	Param string
+}
+
+// PasswordPolicy describes the rules a new password must satisfy
+type PasswordPolicy struct {
+	MinLength      int
+	RequireUpper   bool
+	RequireLower   bool
+	RequireDigit   bool
+	RequireSymbol  bool
+	RejectUsername bool
+	// Breached rejects passwords found in this list when set
+	Breached *BreachedList
+}
+
+// ValidationError lists every rule a value broke
+type ValidationError struct {
+	Problems []Problem
+}
+
+func (e *ValidationError) Error() string {
+	messages := make([]string, len(e.Problems))
+	for i, p := range e.Problems {
+		messages[i] = p.Field + ": " + p.Rule
+	}
+	return strings.Join(messages, "; ")
+}
+
+// DefaultPasswordPolicy returns the policy used when nothing is configured
+func DefaultPasswordPolicy() PasswordPolicy {
+	return PasswordPolicy{
+		MinLength:      8,
+		RejectUsername: true,
+		Breached:       BundledBreachedList(),
+	}
+}
+
+// Validate checks password for the given username against the policy
+func (p PasswordPolicy) Validate(password, username string) error {
+	var problems []Problem
+	problem := func(rule, param string) {
+		problems = append(problems, Problem{Field: "password", Rule: rule, Param: param})
+	}
+
+	if utf8.RuneCountInString(password) < p.MinLength {
+		problem(TooShort, strconv.Itoa(p.MinLength))
+	}
+
+	var hasUpper, hasLower, hasDigit, hasSymbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+			hasSymbol = true
+		}
+	}
+	if p.RequireUpper && !hasUpper {
+		problem(NeedsUpper, "")
+	}
+	if p.RequireLower && !hasLower {
+		problem(NeedsLower, "")
+	}
+	if p.RequireDigit && !hasDigit {
+		problem(NeedsDigit, "")
+	}
+	if p.RequireSymbol && !hasSymbol {
+		problem(NeedsSymbol, "")
+	}
+
+	if p.RejectUsername && username != "" && strings.Contains(strings.ToLower(password), strings.ToLower(username)) {
+		problem(ContainsUsername, "")
+	}
+
+	if p.Breached.Contains(password) {
+		problem(Breached, "")
+	}
+
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+// Warnings lists what is accepted about password but worth telling the
+// user, such as the bytes bcrypt ignores
+func (p PasswordPolicy) Warnings(password string) []Problem {
+	if len(password) > BcryptMaxBytes {
+		return []Problem{{Field: "password", Rule: TooLongBytes, Param: strconv.Itoa(BcryptMaxBytes)}}
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package policy
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// rules lists the rules in err, which must be a *ValidationError or nil
+func rules(t *testing.T, err error) []string {
+	t.Helper()
+	if err == nil {
+		return nil
+	}
+	verr, ok := err.(*ValidationError)
+	if !ok {
+		t.Fatalf("error %v is a %T, want *ValidationError", err, err)
+	}
+	var names []string
+	for _, p := range verr.Problems {
+		names = append(names, p.Rule)
+	}
+	return names
+}
+
+func TestPasswordPolicyValidate(t *testing.T) {
+	strict := DefaultPasswordPolicy()
+	strict.RequireUpper = true
+	strict.RequireLower = true
+	strict.RequireDigit = true
+	strict.RequireSymbol = true
+
+	tests := []struct {
+		name     string
+		policy   PasswordPolicy
+		password string
+		want     []string
+	}{
+		{"long enough", DefaultPasswordPolicy(), "violet-kettle", nil},
+		{"too short", DefaultPasswordPolicy(), "kettle", []string{TooShort}},
+		{"length counts characters, not bytes", DefaultPasswordPolicy(), "ééééééé", []string{TooShort}},
+		{"contains the username", DefaultPasswordPolicy(), "xxALICExx", []string{ContainsUsername}},
+		{"breached", DefaultPasswordPolicy(), "password1", []string{Breached}},
+		{"every class", strict, "Violet-Kettle-42", nil},
+		{"missing classes", strict, "violetkettle", []string{NeedsUpper, NeedsDigit, NeedsSymbol}},
+		{"space counts as a symbol", strict, "Violet Kettle 42", nil},
+		{"every problem at once", strict, "ALICE", []string{TooShort, NeedsLower, NeedsDigit, NeedsSymbol, ContainsUsername}},
+		{"longer than bcrypt is accepted", DefaultPasswordPolicy(), strings.Repeat("violet-kettle ", 10), nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := rules(t, tt.policy.Validate(tt.password, "alice"))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("rules = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPasswordPolicyWithoutUsernameRule(t *testing.T) {
+	p := DefaultPasswordPolicy()
+	p.RejectUsername = false
+	p.Breached = nil
+	if err := p.Validate("alice-in-wonderland", "alice"); err != nil {
+		t.Errorf("Validate = %v", err)
+	}
+	if err := p.Validate("password1", "alice"); err != nil {
+		t.Errorf("Validate without a breached list = %v", err)
+	}
+}
+
+func TestPasswordPolicyWarnings(t *testing.T) {
+	p := DefaultPasswordPolicy()
+	if w := p.Warnings(strings.Repeat("a", BcryptMaxBytes)); w != nil {
+		t.Errorf("Warnings at the limit = %v", w)
+	}
+	// 36 two-byte characters fit; one more goes past bcrypt's limit
+	w := p.Warnings(strings.Repeat("é", BcryptMaxBytes/2+1))
+	want := []Problem{{Field: "password", Rule: TooLongBytes, Param: "72"}}
+	if !reflect.DeepEqual(w, want) {
+		t.Errorf("Warnings = %v, want %v", w, want)
+	}
+}
+
+func TestBreachedList(t *testing.T) {
+	// SHA-1 of "hunter2", with and without a count
+	list, err := NewBreachedList(strings.NewReader("# comment\n\nf3bbbd66a63d4bf1747940578ec3d0103530e21d:17\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !list.Contains("hunter2") || list.Contains("hunter3") {
+		t.Errorf("Contains gives the wrong answer")
+	}
+	if list.Len() != 1 {
+		t.Errorf("Len = %d, want 1", list.Len())
+	}
+
+	if _, err := NewBreachedList(strings.NewReader("f3bbbd66\n")); err == nil {
+		t.Error("short hash accepted")
+	}
+	if BundledBreachedList().Len() == 0 {
+		t.Error("bundled list is empty")
+	}
+}
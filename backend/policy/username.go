@@ -0,0 +1,88 @@
+package policy
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var usernamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_.-]*$`)
+
+// UsernamePolicy describes which usernames can be registered
+type UsernamePolicy struct {
+	MinLength int
+	MaxLength int
+	// Reserved names can't be registered (compared case-insensitively)
+	Reserved map[string]struct{}
+	// ReservedPrefixes are kept for generated names such as guest accounts
+	ReservedPrefixes []string
+}
+
+// DefaultUsernamePolicy returns the policy used when nothing is configured.
+// "admin" is reserved because AdminMiddleware grants admin rights by
+// username; the admin account has to be created directly in the database.
+func DefaultUsernamePolicy() UsernamePolicy {
+	return UsernamePolicy{
+		MinLength: 3,
+		MaxLength: 50,
+		Reserved: reservedSet(
+			"admin", "administrator", "root", "system", "superuser",
+			"support", "moderator", "null", "undefined",
+		),
+		ReservedPrefixes: []string{"guest-"},
+	}
+}
+
+// Reserve adds names to the reserved names, ignoring case and blanks
+func (p *UsernamePolicy) Reserve(names ...string) {
+	for _, name := range names {
+		if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+			p.Reserved[name] = struct{}{}
+		}
+	}
+}
+
+// Validate checks a username. Usernames are stored lowercased, so only
+// lowercase letters, digits, '_', '.' and '-' are allowed after lowering,
+// and the name must start with a letter or digit.
+func (p UsernamePolicy) Validate(username string) error {
+	var problems []Problem
+	name := strings.ToLower(username)
+
+	if len(name) < p.MinLength || len(name) > p.MaxLength {
+		problems = append(problems, Problem{Field: "username", Rule: LengthBetween, Param: fmt.Sprintf("%d-%d", p.MinLength, p.MaxLength)})
+	}
+	if !usernamePattern.MatchString(name) {
+		problems = append(problems, Problem{Field: "username", Rule: BadCharacters})
+	}
+	if p.IsReserved(name) {
+		problems = append(problems, Problem{Field: "username", Rule: Reserved})
+	}
+
+	if len(problems) > 0 {
+		return &ValidationError{Problems: problems}
+	}
+	return nil
+}
+
+// IsReserved reports whether name is a reserved name or starts with a reserved prefix
+func (p UsernamePolicy) IsReserved(name string) bool {
+	name = strings.ToLower(name)
+	if _, reserved := p.Reserved[name]; reserved {
+		return true
+	}
+	for _, prefix := range p.ReservedPrefixes {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func reservedSet(names ...string) map[string]struct{} {
+	set := make(map[string]struct{}, len(names))
+	for _, name := range names {
+		set[name] = struct{}{}
+	}
+	return set
+}
@@ -0,0 +1,45 @@
+package policy
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestUsernamePolicyValidate(t *testing.T) {
+	p := DefaultUsernamePolicy()
+	p.Reserve(" Teacher ", "")
+
+	tests := []struct {
+		username string
+		want     []string
+	}{
+		{"alice", nil},
+		{"Alice.Smith_2", nil},
+		{"al", []string{LengthBetween}},
+		{strings.Repeat("a", 51), []string{LengthBetween}},
+		{"_alice", []string{BadCharacters}},
+		{"alice smith", []string{BadCharacters}},
+		{"ädmin", []string{BadCharacters}},
+		{"Admin", []string{Reserved}},
+		{"teacher", []string{Reserved}},
+		{"guest-1234", []string{Reserved}},
+		{"-", []string{LengthBetween, BadCharacters}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.username, func(t *testing.T) {
+			got := rules(t, p.Validate(tt.username))
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("rules = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReserveDoesNotChangeDefaults(t *testing.T) {
+	p := DefaultUsernamePolicy()
+	p.Reserve("teacher")
+	if DefaultUsernamePolicy().IsReserved("teacher") {
+		t.Error("Reserve changed the default policy")
+	}
+}
@@ -0,0 +1,167 @@
+package ratelimit
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"quiz-butterfly/backend/apierror"
+	"quiz-butterfly/backend/logging"
+)
+
+// Config describes the limits applied to a route group
+type Config struct {
+	// Name separates the counters of different route groups
+	Name string
+	// IPLimit is the number of requests allowed per client IP per Window (0 disables)
+	IPLimit int
+	// UserLimit is the number of requests allowed per username per Window (0 disables)
+	UserLimit int
+	Window    time.Duration
+	// Lockout enables exponential lockout after repeated failures (nil disables)
+	Lockout *Lockout
+}
+
+// Lockout locks an IP or username out after Threshold consecutive failed
+// attempts. The lock lasts Base and doubles with every further failure, up to
+// Max. Failures are forgotten after Decay without a new one.
+type Lockout struct {
+	Threshold int
+	Base      time.Duration
+	Max       time.Duration
+	Decay     time.Duration
+	// FailureStatus is the response status counted as a failed attempt
+	FailureStatus int
+}
+
+// Duration returns how long to lock after the given number of consecutive failures
+func (l *Lockout) Duration(failures int) time.Duration {
+	if failures < l.Threshold {
+		return 0
+	}
+	exp := failures - l.Threshold
+	if exp > 30 {
+		return l.Max
+	}
+	d := time.Duration(float64(l.Base) * math.Pow(2, float64(exp)))
+	if d > l.Max || d <= 0 {
+		return l.Max
+	}
+	return d
+}
+
+// Middleware enforces cfg using store. It counts every request against the
+// client IP and, when the JSON body has a "username" field, against that
+// username. With lockout enabled it also watches the response status to
+// record failures. A success clears only the username's failures: the IP's
+// decay on their own, so signing in to an account of one's own between
+// guesses doesn't lift the lockout on the IP. Store errors are logged and
+// the request is let through.
+func Middleware(store Store, cfg Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx := c.Request.Context()
+
+		keys := []string{"ip:" + cfg.Name + ":" + c.ClientIP()}
+		limits := []int{cfg.IPLimit}
+		var userKey string
+		if username := peekUsername(c); username != "" {
+			userKey = "user:" + cfg.Name + ":" + username
+			keys = append(keys, userKey)
+			limits = append(limits, cfg.UserLimit)
+		}
+
+		if cfg.Lockout != nil {
+			for _, key := range keys {
+				lockedUntil, err := store.LockedUntil(ctx, key)
+				if err != nil {
+					logging.From(c).Error("Rate limit lockout lookup failed", "key", key, "error", err)
+					continue
+				}
+				if wait := time.Until(lockedUntil); wait > 0 {
+					tooManyRequests(c, wait)
+					return
+				}
+			}
+		}
+
+		for i, key := range keys {
+			if limits[i] <= 0 {
+				continue
+			}
+			count, resetAt, err := store.Incr(ctx, key, cfg.Window)
+			if err != nil {
+				logging.From(c).Error("Rate limit counter failed", "key", key, "error", err)
+				continue
+			}
+			if count > limits[i] {
+				tooManyRequests(c, time.Until(resetAt))
+				return
+			}
+		}
+
+		c.Next()
+
+		if cfg.Lockout == nil {
+			return
+		}
+
+		status := apierror.Status(c)
+		switch {
+		case status == cfg.Lockout.FailureStatus:
+			for _, key := range keys {
+				failures, err := store.RecordFailure(ctx, key, cfg.Lockout.Decay)
+				if err != nil {
+					logging.From(c).Error("Rate limit failure recording failed", "key", key, "error", err)
+					continue
+				}
+				if d := cfg.Lockout.Duration(failures); d > 0 {
+					if err := store.Lock(ctx, key, time.Now().Add(d)); err != nil {
+						logging.From(c).Error("Rate limit locking failed", "key", key, "error", err)
+					}
+				}
+			}
+		case status >= 200 && status < 300 && userKey != "":
+			if err := store.Reset(ctx, userKey); err != nil {
+				logging.From(c).Error("Rate limit reset failed", "key", userKey, "error", err)
+			}
+		}
+	}
+}
+
+func tooManyRequests(c *gin.Context, wait time.Duration) {
+	seconds := int(math.Ceil(wait.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	c.Header("Retry-After", strconv.Itoa(seconds))
+	c.AbortWithStatusJSON(http.StatusTooManyRequests, apierror.New(c, apierror.TooManyRequests))
+}
+
+// peekUsername reads the username field from a JSON body and puts the body
+// back so the handler can still bind it
+func peekUsername(c *gin.Context) string {
+	if c.Request.Body == nil || !strings.HasPrefix(c.ContentType(), "application/json") {
+		return ""
+	}
+
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, 1<<20))
+	c.Request.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), c.Request.Body))
+	if err != nil {
+		return ""
+	}
+
+	var payload struct {
+		Username string `json:"username"`
+	}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return ""
+	}
+	return strings.ToLower(strings.TrimSpace(payload.Username))
+}
@@ -0,0 +1,210 @@
+package ratelimit
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"quiz-butterfly/backend/apierror"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+// newRouter puts the middleware in front of a login-like handler that
+// accepts the password "right" and echoes the body it was given. Like
+// main.go it trusts no proxies.
+func newRouter(store Store, cfg Config) *gin.Engine {
+	return newRouterBehind(store, cfg, nil)
+}
+
+// newRouterBehind is newRouter trusting X-Forwarded-For from proxies
+func newRouterBehind(store Store, cfg Config, proxies []string) *gin.Engine {
+	r := gin.New()
+	if err := r.SetTrustedProxies(proxies); err != nil {
+		panic(err)
+	}
+	r.Use(apierror.Middleware())
+	r.POST("/login", Middleware(store, cfg), func(c *gin.Context) {
+		body, _ := io.ReadAll(c.Request.Body)
+		if !strings.Contains(string(body), `"right"`) {
+			c.Error(apierror.Unauthorized(apierror.InvalidCredentials))
+			return
+		}
+		c.String(http.StatusOK, string(body))
+	})
+	return r
+}
+
+func post(r *gin.Engine, ip, body string) *httptest.ResponseRecorder {
+	return postForwarded(r, ip, "", body)
+}
+
+// postForwarded posts from ip with X-Forwarded-For set to forwardedFor
+func postForwarded(r *gin.Engine, ip, forwardedFor, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	if forwardedFor != "" {
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+	}
+	req.RemoteAddr = ip + ":1234"
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestMiddlewareLimitsEachIP(t *testing.T) {
+	r := newRouter(NewMemoryStore(), Config{Name: "auth", IPLimit: 2, Window: time.Minute})
+	body := `{"password": "right"}`
+
+	for i := 0; i < 2; i++ {
+		if rec := post(r, "10.0.0.1", body); rec.Code != http.StatusOK {
+			t.Fatalf("request %d: status = %d", i+1, rec.Code)
+		}
+	}
+	rec := post(r, "10.0.0.1", body)
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("over the limit: status = %d", rec.Code)
+	}
+	if got := rec.Header().Get("Retry-After"); got == "" || got == "0" {
+		t.Errorf("Retry-After = %q", got)
+	}
+	if rec := post(r, "10.0.0.2", body); rec.Code != http.StatusOK {
+		t.Errorf("another IP: status = %d", rec.Code)
+	}
+}
+
+func TestMiddlewareIgnoresSpoofedForwardedFor(t *testing.T) {
+	r := newRouter(NewMemoryStore(), Config{Name: "auth", IPLimit: 2, Window: time.Minute})
+	body := `{"password": "right"}`
+
+	// A new X-Forwarded-For on every request is still the same client
+	for i, spoofed := range []string{"1.1.1.1", "2.2.2.2"} {
+		if rec := postForwarded(r, "10.0.0.1", spoofed, body); rec.Code != http.StatusOK {
+			t.Fatalf("request %d: status = %d", i+1, rec.Code)
+		}
+	}
+	if rec := postForwarded(r, "10.0.0.1", "3.3.3.3", body); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("spoofed X-Forwarded-For: status = %d, want 429", rec.Code)
+	}
+}
+
+func TestMiddlewareLimitsClientsBehindTrustedProxy(t *testing.T) {
+	r := newRouterBehind(NewMemoryStore(), Config{Name: "auth", IPLimit: 1, Window: time.Minute}, []string{"10.0.0.0/8"})
+	body := `{"password": "right"}`
+
+	if rec := postForwarded(r, "10.0.0.1", "1.1.1.1", body); rec.Code != http.StatusOK {
+		t.Fatalf("first client: status = %d", rec.Code)
+	}
+	if rec := postForwarded(r, "10.0.0.1", "2.2.2.2", body); rec.Code != http.StatusOK {
+		t.Errorf("second client through the proxy: status = %d", rec.Code)
+	}
+	if rec := postForwarded(r, "10.0.0.1", "1.1.1.1", body); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("first client again: status = %d, want 429", rec.Code)
+	}
+}
+
+func TestMiddlewareLimitsEachUsername(t *testing.T) {
+	r := newRouter(NewMemoryStore(), Config{Name: "auth", UserLimit: 1, Window: time.Minute})
+
+	rec := post(r, "10.0.0.1", `{"username": "alice", "password": "right"}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d", rec.Code)
+	}
+	// The handler still gets the whole body after the middleware read it
+	if !strings.Contains(rec.Body.String(), `"alice"`) {
+		t.Errorf("handler saw body %q", rec.Body)
+	}
+	// Usernames are counted case-insensitively and across IPs
+	if rec := post(r, "10.0.0.2", `{"username": " ALICE ", "password": "right"}`); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("same username from another IP: status = %d", rec.Code)
+	}
+	if rec := post(r, "10.0.0.1", `{"username": "bob", "password": "right"}`); rec.Code != http.StatusOK {
+		t.Errorf("another username: status = %d", rec.Code)
+	}
+}
+
+// loginLockout locks after two failures in a row
+func loginLockout() Config {
+	return Config{Name: "login", Lockout: &Lockout{
+		Threshold:     2,
+		Base:          time.Minute,
+		Max:           time.Hour,
+		Decay:         time.Hour,
+		FailureStatus: http.StatusUnauthorized,
+	}}
+}
+
+func TestMiddlewareLocksOutUsernameAfterFailures(t *testing.T) {
+	r := newRouter(NewMemoryStore(), loginLockout())
+	wrong := `{"username": "alice", "password": "wrong"}`
+
+	// Each try from another IP, so only the username adds up
+	if rec := post(r, "10.0.0.1", wrong); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("first failure: status = %d", rec.Code)
+	}
+	// A success in between starts the username's count over
+	if rec := post(r, "10.0.0.2", `{"username": "alice", "password": "right"}`); rec.Code != http.StatusOK {
+		t.Fatalf("success: status = %d", rec.Code)
+	}
+	if rec := post(r, "10.0.0.3", wrong); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("failure after success: status = %d", rec.Code)
+	}
+	if rec := post(r, "10.0.0.4", wrong); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("second failure in a row: status = %d", rec.Code)
+	}
+
+	// Now the username is locked from any IP, even with the right password
+	rec := post(r, "10.0.0.5", `{"username": "alice", "password": "right"}`)
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("locked: status = %d", rec.Code)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "60" {
+		t.Errorf("Retry-After = %q, want 60", got)
+	}
+}
+
+func TestMiddlewareSuccessKeepsIPLockout(t *testing.T) {
+	r := newRouter(NewMemoryStore(), loginLockout())
+
+	// Guessing at other accounts, with a login to one's own in between
+	if rec := post(r, "10.0.0.1", `{"username": "bob", "password": "wrong"}`); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("first guess: status = %d", rec.Code)
+	}
+	if rec := post(r, "10.0.0.1", `{"username": "mallory", "password": "right"}`); rec.Code != http.StatusOK {
+		t.Fatalf("own login: status = %d", rec.Code)
+	}
+	if rec := post(r, "10.0.0.1", `{"username": "carol", "password": "wrong"}`); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("second guess: status = %d", rec.Code)
+	}
+
+	if rec := post(r, "10.0.0.1", `{"username": "dave", "password": "wrong"}`); rec.Code != http.StatusTooManyRequests {
+		t.Errorf("IP after two failed guesses: status = %d, want 429", rec.Code)
+	}
+}
+
+func TestLockoutDuration(t *testing.T) {
+	l := &Lockout{Threshold: 5, Base: 30 * time.Second, Max: time.Hour}
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{4, 0},
+		{5, 30 * time.Second},
+		{6, time.Minute},
+		{8, 4 * time.Minute},
+		{12, time.Hour},
+		{1000, time.Hour},
+	}
+	for _, tt := range tests {
+		if got := l.Duration(tt.failures); got != tt.want {
+			t.Errorf("Duration(%d) = %v, want %v", tt.failures, got, tt.want)
+		}
+	}
+}
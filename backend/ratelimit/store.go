@@ -0,0 +1,230 @@
+package ratelimit
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+)
+
+// Store keeps request counters and failure/lockout state
+type Store interface {
+	// Incr counts a hit for key in a fixed window and returns the count so
+	// far and when the window ends
+	Incr(ctx context.Context, key string, window time.Duration) (int, time.Time, error)
+	// RecordFailure counts a failed attempt for key and returns the number of
+	// consecutive failures. The count starts over once decay has passed since
+	// the previous failure.
+	RecordFailure(ctx context.Context, key string, decay time.Duration) (int, error)
+	// Lock blocks key until the given time
+	Lock(ctx context.Context, key string, until time.Time) error
+	// LockedUntil returns the time key is locked until (zero if not locked)
+	LockedUntil(ctx context.Context, key string) (time.Time, error)
+	// Reset clears failures and any lock for key
+	Reset(ctx context.Context, key string) error
+}
+
+// MemoryStore is an in-process Store. State is lost on restart and not
+// shared between instances.
+type MemoryStore struct {
+	mu        sync.Mutex
+	counters  map[string]*counter
+	failures  map[string]*failure
+	lastSweep time.Time
+}
+
+type counter struct {
+	count   int
+	resetAt time.Time
+}
+
+type failure struct {
+	count       int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+// NewMemoryStore creates an empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		counters:  make(map[string]*counter),
+		failures:  make(map[string]*failure),
+		lastSweep: time.Now(),
+	}
+}
+
+func (s *MemoryStore) Incr(ctx context.Context, key string, window time.Duration) (int, time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.sweep(now)
+
+	c, ok := s.counters[key]
+	if !ok || !now.Before(c.resetAt) {
+		c = &counter{resetAt: now.Add(window)}
+		s.counters[key] = c
+	}
+	c.count++
+	return c.count, c.resetAt, nil
+}
+
+func (s *MemoryStore) RecordFailure(ctx context.Context, key string, decay time.Duration) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	f, ok := s.failures[key]
+	if !ok {
+		f = &failure{}
+		s.failures[key] = f
+	}
+	if now.Sub(f.lastFailure) >= decay {
+		f.count = 0
+	}
+	f.count++
+	f.lastFailure = now
+	return f.count, nil
+}
+
+func (s *MemoryStore) Lock(ctx context.Context, key string, until time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	f, ok := s.failures[key]
+	if !ok {
+		f = &failure{lastFailure: time.Now()}
+		s.failures[key] = f
+	}
+	f.lockedUntil = until
+	return nil
+}
+
+func (s *MemoryStore) LockedUntil(ctx context.Context, key string) (time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if f, ok := s.failures[key]; ok {
+		return f.lockedUntil, nil
+	}
+	return time.Time{}, nil
+}
+
+func (s *MemoryStore) Reset(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.failures, key)
+	return nil
+}
+
+// sweep drops expired entries at most once a minute so the maps don't grow
+// without bound. Callers must hold s.mu.
+func (s *MemoryStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < time.Minute {
+		return
+	}
+	s.lastSweep = now
+
+	for k, c := range s.counters {
+		if !now.Before(c.resetAt) {
+			delete(s.counters, k)
+		}
+	}
+	for k, f := range s.failures {
+		if now.After(f.lockedUntil) && now.Sub(f.lastFailure) > 24*time.Hour {
+			delete(s.failures, k)
+		}
+	}
+}
+
+// PostgresStore keeps limiter state in Postgres so it is shared by every
+// instance of the API. It uses the rate_limit_counters and auth_lockouts
+// tables from schema.sql.
+type PostgresStore struct {
+	db *sql.DB
+
+	mu        sync.Mutex
+	lastPurge time.Time
+}
+
+// NewPostgresStore creates a PostgresStore on top of db
+func NewPostgresStore(db *sql.DB) *PostgresStore {
+	return &PostgresStore{db: db, lastPurge: time.Now()}
+}
+
+func (s *PostgresStore) Incr(ctx context.Context, key string, window time.Duration) (int, time.Time, error) {
+	s.purge(ctx)
+
+	var count int
+	var windowStart time.Time
+	err := s.db.QueryRowContext(ctx, `
+		INSERT INTO rate_limit_counters (key, count, window_start)
+		VALUES ($1, 1, now())
+		ON CONFLICT (key) DO UPDATE SET
+			count = CASE WHEN rate_limit_counters.window_start + $2 * interval '1 millisecond' <= now()
+				THEN 1 ELSE rate_limit_counters.count + 1 END,
+			window_start = CASE WHEN rate_limit_counters.window_start + $2 * interval '1 millisecond' <= now()
+				THEN now() ELSE rate_limit_counters.window_start END
+		RETURNING count, window_start`, key, window.Milliseconds()).Scan(&count, &windowStart)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	return count, windowStart.Add(window), nil
+}
+
+func (s *PostgresStore) RecordFailure(ctx context.Context, key string, decay time.Duration) (int, error) {
+	var failures int
+	err := s.db.QueryRowContext(ctx, `
+		INSERT INTO auth_lockouts (key, failures, last_failure_at)
+		VALUES ($1, 1, now())
+		ON CONFLICT (key) DO UPDATE SET
+			failures = CASE WHEN auth_lockouts.last_failure_at + $2 * interval '1 millisecond' <= now()
+				THEN 1 ELSE auth_lockouts.failures + 1 END,
+			last_failure_at = now()
+		RETURNING failures`, key, decay.Milliseconds()).Scan(&failures)
+	return failures, err
+}
+
+func (s *PostgresStore) Lock(ctx context.Context, key string, until time.Time) error {
+	_, err := s.db.ExecContext(ctx, `
+		INSERT INTO auth_lockouts (key, failures, last_failure_at, locked_until)
+		VALUES ($1, 0, now(), $2)
+		ON CONFLICT (key) DO UPDATE SET locked_until = $2`, key, until)
+	return err
+}
+
+func (s *PostgresStore) LockedUntil(ctx context.Context, key string) (time.Time, error) {
+	var lockedUntil sql.NullTime
+	err := s.db.QueryRowContext(ctx, "SELECT locked_until FROM auth_lockouts WHERE key = $1", key).Scan(&lockedUntil)
+	if err == sql.ErrNoRows {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, err
+	}
+	return lockedUntil.Time, nil
+}
+
+func (s *PostgresStore) Reset(ctx context.Context, key string) error {
+	_, err := s.db.ExecContext(ctx, "DELETE FROM auth_lockouts WHERE key = $1", key)
+	return err
+}
+
+// purge deletes stale rows every ten minutes. Errors are ignored; the next
+// purge will try again.
+func (s *PostgresStore) purge(ctx context.Context) {
+	s.mu.Lock()
+	if time.Since(s.lastPurge) < 10*time.Minute {
+		s.mu.Unlock()
+		return
+	}
+	s.lastPurge = time.Now()
+	s.mu.Unlock()
+
+	s.db.ExecContext(ctx, "DELETE FROM rate_limit_counters WHERE window_start < now() - interval '1 day'")
+	s.db.ExecContext(ctx, `
+		DELETE FROM auth_lockouts
+		WHERE last_failure_at < now() - interval '1 day'
+		AND (locked_until IS NULL OR locked_until < now())`)
+}
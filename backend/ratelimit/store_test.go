@@ -0,0 +1,62 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryStoreIncr(t *testing.T) {
+	s := NewMemoryStore()
+	ctx := context.Background()
+	window := 50 * time.Millisecond
+
+	count, resetAt, _ := s.Incr(ctx, "a", window)
+	if count != 1 {
+		t.Fatalf("first count = %d, want 1", count)
+	}
+	if count, again, _ := s.Incr(ctx, "a", window); count != 2 || !again.Equal(resetAt) {
+		t.Errorf("second count = %d ending %v, want 2 ending %v", count, again, resetAt)
+	}
+	if count, _, _ := s.Incr(ctx, "b", window); count != 1 {
+		t.Errorf("other key count = %d, want 1", count)
+	}
+
+	time.Sleep(window)
+	if count, _, _ := s.Incr(ctx, "a", window); count != 1 {
+		t.Errorf("count in a new window = %d, want 1", count)
+	}
+}
+
+func TestMemoryStoreFailures(t *testing.T) {
+	s := NewMemoryStore()
+	ctx := context.Background()
+
+	for want := 1; want <= 3; want++ {
+		if got, _ := s.RecordFailure(ctx, "a", time.Hour); got != want {
+			t.Fatalf("failure count = %d, want %d", got, want)
+		}
+	}
+	// Failures further apart than decay don't add up
+	time.Sleep(20 * time.Millisecond)
+	if got, _ := s.RecordFailure(ctx, "a", 10*time.Millisecond); got != 1 {
+		t.Errorf("count after decay = %d, want 1", got)
+	}
+
+	until := time.Now().Add(time.Minute)
+	s.Lock(ctx, "a", until)
+	if got, _ := s.LockedUntil(ctx, "a"); !got.Equal(until) {
+		t.Errorf("LockedUntil = %v, want %v", got, until)
+	}
+	if got, _ := s.LockedUntil(ctx, "b"); !got.IsZero() {
+		t.Errorf("LockedUntil of an unknown key = %v, want zero", got)
+	}
+
+	s.Reset(ctx, "a")
+	if got, _ := s.LockedUntil(ctx, "a"); !got.IsZero() {
+		t.Errorf("LockedUntil after Reset = %v, want zero", got)
+	}
+	if got, _ := s.RecordFailure(ctx, "a", time.Hour); got != 1 {
+		t.Errorf("count after Reset = %d, want 1", got)
+	}
+}
@@ -0,0 +1,94 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.37.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"quiz-butterfly/backend/logging"
+)
+
+// ServiceName names the API in traces unless OTEL_SERVICE_NAME says
+// otherwise
+const ServiceName = "quiz-butterfly-backend"
+
+// Exporters
+const (
+	ExporterNone   = "none"
+	ExporterOTLP   = "otlp"
+	ExporterStdout = "stdout"
+)
+
+// Setup installs the global tracer provider and propagators. exporter
+// picks where spans go: "otlp" sends them over OTLP/HTTP, configured by the
+// standard OTEL_EXPORTER_OTLP_* variables; "stdout" prints them, for local
+// testing; "none" or "" turns tracing off. The returned function flushes
+// pending spans and must be called before exit.
+func Setup(ctx context.Context, exporter string) (func(context.Context) error, error) {
+	// Incoming traceparent headers are honoured even with tracing off, so
+	// the IDs still reach the logs
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(
+		propagation.TraceContext{}, propagation.Baggage{}))
+
+	var spanExporter sdktrace.SpanExporter
+	var err error
+	switch strings.ToLower(strings.TrimSpace(exporter)) {
+	case "", ExporterNone:
+		return func(context.Context) error { return nil }, nil
+	case ExporterOTLP:
+		spanExporter, err = otlptracehttp.New(ctx)
+	case ExporterStdout:
+		spanExporter, err = stdouttrace.New(stdouttrace.WithPrettyPrint())
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q (want %s, %s or %s)", exporter, ExporterOTLP, ExporterStdout, ExporterNone)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("create %s trace exporter: %w", exporter, err)
+	}
+
+	res, err := resource.New(ctx,
+		resource.WithAttributes(semconv.ServiceName(ServiceName)),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("build trace resource: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(spanExporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// Middleware starts a span for every request, named after its route.
+// Scrapes of /metrics are left out.
+func Middleware() gin.HandlerFunc {
+	return otelgin.Middleware(ServiceName, otelgin.WithGinFilter(func(c *gin.Context) bool {
+		return c.FullPath() != "/metrics"
+	}))
+}
+
+// LogTraceID adds the trace ID of the request's span to its logger, so log
+// lines and traces can be matched up. It must run inside Middleware.
+func LogTraceID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if sc := trace.SpanContextFromContext(c.Request.Context()); sc.HasTraceID() {
+			logging.With(c, "trace_id", sc.TraceID().String())
+		}
+		c.Next()
+	}
+}
@@ -1,10 +1,31 @@
 -- Quiz Butterfly Database Schema
+--
+-- For a new, empty database. To bring one set up from an older version of
+-- this file up to date, run schema_upgrade.sql instead.
+
+-- Trigram similarity, used to find duplicate questions
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+-- Question text reduced to lowercase words, so punctuation and spacing
+-- don't hide a duplicate
+CREATE OR REPLACE FUNCTION normalize_question_text(t TEXT)
+RETURNS TEXT AS $$
+    SELECT btrim(regexp_replace(lower(t), '[^[:alnum:]]+', ' ', 'g'))
+$$ LANGUAGE sql IMMUTABLE;
 
 -- Users table
 CREATE TABLE users (
     id SERIAL PRIMARY KEY,
     username VARCHAR(50) UNIQUE NOT NULL,
+    email VARCHAR(255) UNIQUE,
     password_hash VARCHAR(255) NOT NULL,
+    is_guest BOOLEAN NOT NULL DEFAULT FALSE,
+    role VARCHAR(20) NOT NULL DEFAULT 'student' CHECK (role IN ('student', 'teacher')),
+    -- Preferred question language; NULL follows Accept-Language
+    locale VARCHAR(5) CHECK (locale IN ('en', 'id')),
+    -- Set once the user has shown they receive mail at email; only then
+    -- may a login provider with the same email be linked automatically
+    email_verified_at TIMESTAMP WITH TIME ZONE,
     created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
     updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
 );
@@ -14,7 +35,7 @@ CREATE TABLE high_scores (
     id SERIAL PRIMARY KEY,
     user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
     difficulty VARCHAR(10) NOT NULL CHECK (difficulty IN ('easy', 'medium', 'advance')),
-    score INTEGER NOT NULL DEFAULT 0,
+    score NUMERIC(10, 2) NOT NULL DEFAULT 0,
     created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
     UNIQUE(user_id, difficulty)
 );
@@ -23,23 +44,85 @@ CREATE TABLE high_scores (
 CREATE TABLE questions (
     id SERIAL PRIMARY KEY,
     question_text TEXT NOT NULL,
-    options TEXT[] NOT NULL, -- Array of options
+    question_type VARCHAR(20) NOT NULL DEFAULT 'single_choice'
+        CHECK (question_type IN ('single_choice', 'multiple_select', 'true_false', 'numeric', 'short_text')),
+    options TEXT[] NOT NULL, -- Array of options (empty for numeric and short_text)
     correct_answer_index INTEGER NOT NULL,
+    correct_answer_indices INTEGER[], -- multiple_select
+    numeric_answer DOUBLE PRECISION, -- numeric
+    numeric_tolerance DOUBLE PRECISION, -- numeric
+    accepted_answers TEXT[], -- short_text
     reference TEXT,
+    explanation TEXT, -- markdown shown after answering
     difficulty VARCHAR(10) NOT NULL CHECK (difficulty IN ('easy', 'medium', 'advance')),
+    -- Language the question is written in; other languages live in question_translations
+    locale VARCHAR(5) NOT NULL DEFAULT 'en' CHECK (locale IN ('en', 'id')),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    -- Full-text search over question_text and options, kept by a trigger
+    search_vector TSVECTOR,
+    normalized_text TEXT GENERATED ALWAYS AS (normalize_question_text(question_text)) STORED
+);
+
+-- Question attachments table (images kept in the blob store under blob_key)
+CREATE TABLE question_attachments (
+    id SERIAL PRIMARY KEY,
+    question_id INTEGER NOT NULL REFERENCES questions(id) ON DELETE CASCADE,
+    blob_key VARCHAR(128) UNIQUE NOT NULL,
+    filename VARCHAR(255) NOT NULL,
+    content_type VARCHAR(100) NOT NULL,
+    size_bytes BIGINT NOT NULL,
     created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
 );
 
+-- Question translations table (options line up with questions.options by index)
+CREATE TABLE question_translations (
+    question_id INTEGER NOT NULL REFERENCES questions(id) ON DELETE CASCADE,
+    locale VARCHAR(5) NOT NULL CHECK (locale IN ('en', 'id')),
+    question_text TEXT NOT NULL,
+    options TEXT[], -- NULL shows the question's own options
+    accepted_answers TEXT[], -- extra short_text answers in this language
+    explanation TEXT,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    PRIMARY KEY (question_id, locale)
+);
+
 -- Quiz sessions table
 CREATE TABLE quiz_sessions (
     id SERIAL PRIMARY KEY,
     user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
-    difficulty VARCHAR(10) NOT NULL CHECK (difficulty IN ('easy', 'medium', 'advance')),
+    -- Daily challenges span every difficulty and are stored as 'mixed'
+    difficulty VARCHAR(10) NOT NULL CHECK (difficulty IN ('easy', 'medium', 'advance', 'mixed')),
+    mode VARCHAR(10) NOT NULL DEFAULT 'standard' CHECK (mode IN ('standard', 'daily')),
+    challenge_date DATE,
+    -- Fixed question order; NULL means every question of the difficulty by id
+    question_ids INTEGER[],
     current_question_index INTEGER DEFAULT 0,
-    score INTEGER DEFAULT 0,
-    status VARCHAR(20) DEFAULT 'playing' CHECK (status IN ('playing', 'finished')),
+    -- Sum of answer credit; partially correct answers add a fraction
+    score NUMERIC(10, 2) DEFAULT 0,
+    -- 'abandoned' sessions were left for a newer one and never count as played
+    status VARCHAR(20) DEFAULT 'playing' CHECK (status IN ('playing', 'finished', 'abandoned')),
     started_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
     finished_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    CHECK ((mode = 'daily') = (challenge_date IS NOT NULL))
+);
+
+-- Best score per user, difficulty and day, kept up to date as standard
+-- sessions finish; windowed leaderboards take the best row in range
+CREATE TABLE best_scores_by_day (
+    user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+    difficulty VARCHAR(10) NOT NULL CHECK (difficulty IN ('easy', 'medium', 'advance')),
+    day DATE NOT NULL,
+    score NUMERIC(10, 2) NOT NULL,
+    achieved_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    PRIMARY KEY (user_id, difficulty, day)
+);
+
+-- Daily challenges table (the question set chosen for each day)
+CREATE TABLE daily_challenges (
+    challenge_date DATE PRIMARY KEY,
+    question_ids INTEGER[] NOT NULL,
     created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
 );
 
@@ -52,16 +135,105 @@ CREATE TABLE user_answers (
     user_answer TEXT NOT NULL,
     correct_answer TEXT NOT NULL,
     is_correct BOOLEAN NOT NULL,
+    credit NUMERIC(3, 2) NOT NULL DEFAULT 0, -- 0 to 1, partial for multiple_select
     reference TEXT,
     answered_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
 );
 
+-- Password reset tokens table (only the SHA-256 hash of the token is stored)
+CREATE TABLE password_reset_tokens (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+    token_hash CHAR(64) UNIQUE NOT NULL,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    used_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- Achievements earned by users (rules are defined in backend/achievements)
+CREATE TABLE user_achievements (
+    user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+    code VARCHAR(50) NOT NULL,
+    awarded_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, code)
+);
+
+-- Classes created by teachers; students join with the join code
+CREATE TABLE classes (
+    id SERIAL PRIMARY KEY,
+    teacher_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+    name VARCHAR(100) NOT NULL,
+    join_code VARCHAR(16) UNIQUE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+-- Class membership
+CREATE TABLE class_members (
+    class_id INTEGER REFERENCES classes(id) ON DELETE CASCADE,
+    user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+    joined_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    PRIMARY KEY (class_id, user_id)
+);
+
+-- Work assigned to a class: a difficulty or a set of question IDs
+CREATE TABLE class_assignments (
+    id SERIAL PRIMARY KEY,
+    class_id INTEGER REFERENCES classes(id) ON DELETE CASCADE,
+    title VARCHAR(200) NOT NULL,
+    difficulty VARCHAR(10) CHECK (difficulty IN ('easy', 'medium', 'advance')),
+    question_ids INTEGER[],
+    due_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    CHECK ((difficulty IS NULL) <> (question_ids IS NULL))
+);
+
+-- External OIDC identities linked to users
+CREATE TABLE user_identities (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+    issuer TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    email VARCHAR(255),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    UNIQUE(issuer, subject)
+);
+
+-- Rate limit counters (fixed window per key)
+CREATE TABLE rate_limit_counters (
+    key VARCHAR(255) PRIMARY KEY,
+    count INTEGER NOT NULL DEFAULT 0,
+    window_start TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+-- Failed auth attempts and lockouts per IP / username
+CREATE TABLE auth_lockouts (
+    key VARCHAR(255) PRIMARY KEY,
+    failures INTEGER NOT NULL DEFAULT 0,
+    last_failure_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    locked_until TIMESTAMP WITH TIME ZONE
+);
+
 -- Indexes for better performance
 CREATE INDEX idx_high_scores_user_id ON high_scores(user_id);
+CREATE INDEX idx_users_guest_created_at ON users(created_at) WHERE is_guest;
 CREATE INDEX idx_questions_difficulty ON questions(difficulty);
 CREATE INDEX idx_quiz_sessions_user_id ON quiz_sessions(user_id);
 CREATE INDEX idx_quiz_sessions_status ON quiz_sessions(status);
 CREATE INDEX idx_user_answers_quiz_session_id ON user_answers(quiz_session_id);
+CREATE INDEX idx_password_reset_tokens_user_id ON password_reset_tokens(user_id);
+CREATE INDEX idx_user_identities_user_id ON user_identities(user_id);
+CREATE INDEX idx_classes_teacher_id ON classes(teacher_id);
+CREATE INDEX idx_class_members_user_id ON class_members(user_id);
+CREATE INDEX idx_class_assignments_class_id ON class_assignments(class_id);
+CREATE INDEX idx_quiz_sessions_user_difficulty_finished ON quiz_sessions(user_id, difficulty, finished_at);
+CREATE INDEX idx_question_attachments_question_id ON question_attachments(question_id);
+CREATE INDEX idx_questions_search_vector ON questions USING GIN (search_vector);
+CREATE INDEX idx_questions_normalized_text_trgm ON questions USING GIN (normalized_text gin_trgm_ops);
+CREATE INDEX idx_questions_created_at_id ON questions(created_at DESC, id DESC);
+CREATE INDEX idx_best_scores_by_day_difficulty_day ON best_scores_by_day(difficulty, day);
+-- One daily challenge attempt per user per day
+CREATE UNIQUE INDEX idx_quiz_sessions_user_daily ON quiz_sessions(user_id, challenge_date) WHERE mode = 'daily';
+CREATE INDEX idx_quiz_sessions_daily_leaderboard ON quiz_sessions(challenge_date, score DESC) WHERE mode = 'daily' AND status = 'finished';
 
 -- Function to update updated_at timestamp
 CREATE OR REPLACE FUNCTION update_updated_at_column()
@@ -73,4 +245,19 @@ END;
 $$ language 'plpgsql';
 
 -- Trigger to automatically update updated_at
-CREATE TRIGGER update_users_updated_at BEFORE UPDATE ON users FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
\ No newline at end of file
+CREATE TRIGGER update_users_updated_at BEFORE UPDATE ON users FOR EACH ROW EXECUTE FUNCTION update_updated_at_column();
+
+-- Function to build the question search vector ('simple' skips English
+-- stemming, since questions are written in more than one language)
+CREATE OR REPLACE FUNCTION questions_search_vector_update()
+RETURNS TRIGGER AS $$
+BEGIN
+    NEW.search_vector :=
+        setweight(to_tsvector('simple', COALESCE(NEW.question_text, '')), 'A') ||
+        setweight(to_tsvector('simple', COALESCE(array_to_string(NEW.options, ' '), '')), 'B');
+    RETURN NEW;
+END;
+$$ language 'plpgsql';
+
+-- Trigger to keep questions.search_vector current
+CREATE TRIGGER questions_search_vector BEFORE INSERT OR UPDATE OF question_text, options ON questions FOR EACH ROW EXECUTE FUNCTION questions_search_vector_update();
@@ -0,0 +1,212 @@
+-- Quiz Butterfly Database Upgrade
+--
+-- Brings a database set up from an older schema.sql to the current one.
+-- Every statement checks what is already there, so the script can be run
+-- on any earlier version, and again, safely. New installs use schema.sql
+-- instead.
+
+BEGIN;
+
+-- Trigram similarity, used to find duplicate questions
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+CREATE OR REPLACE FUNCTION normalize_question_text(t TEXT)
+RETURNS TEXT AS $$
+    SELECT btrim(regexp_replace(lower(t), '[^[:alnum:]]+', ' ', 'g'))
+$$ LANGUAGE sql IMMUTABLE;
+
+-- Users
+ALTER TABLE users
+    ADD COLUMN IF NOT EXISTS email VARCHAR(255) UNIQUE,
+    ADD COLUMN IF NOT EXISTS is_guest BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD COLUMN IF NOT EXISTS role VARCHAR(20) NOT NULL DEFAULT 'student' CHECK (role IN ('student', 'teacher')),
+    ADD COLUMN IF NOT EXISTS locale VARCHAR(5) CHECK (locale IN ('en', 'id')),
+    ADD COLUMN IF NOT EXISTS email_verified_at TIMESTAMP WITH TIME ZONE;
+
+-- High scores: partial credit makes scores fractional
+ALTER TABLE high_scores ALTER COLUMN score TYPE NUMERIC(10, 2);
+
+-- Questions
+ALTER TABLE questions
+    ADD COLUMN IF NOT EXISTS question_type VARCHAR(20) NOT NULL DEFAULT 'single_choice'
+        CHECK (question_type IN ('single_choice', 'multiple_select', 'true_false', 'numeric', 'short_text')),
+    ADD COLUMN IF NOT EXISTS correct_answer_indices INTEGER[],
+    ADD COLUMN IF NOT EXISTS numeric_answer DOUBLE PRECISION,
+    ADD COLUMN IF NOT EXISTS numeric_tolerance DOUBLE PRECISION,
+    ADD COLUMN IF NOT EXISTS accepted_answers TEXT[],
+    ADD COLUMN IF NOT EXISTS explanation TEXT,
+    ADD COLUMN IF NOT EXISTS locale VARCHAR(5) NOT NULL DEFAULT 'en' CHECK (locale IN ('en', 'id')),
+    ADD COLUMN IF NOT EXISTS updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    ADD COLUMN IF NOT EXISTS search_vector TSVECTOR,
+    ADD COLUMN IF NOT EXISTS normalized_text TEXT GENERATED ALWAYS AS (normalize_question_text(question_text)) STORED;
+
+-- Quiz sessions. The status and difficulty checks are replaced rather than
+-- added, since the older ones reject 'abandoned' and 'mixed'.
+ALTER TABLE quiz_sessions
+    ADD COLUMN IF NOT EXISTS mode VARCHAR(10) NOT NULL DEFAULT 'standard' CHECK (mode IN ('standard', 'daily')),
+    ADD COLUMN IF NOT EXISTS challenge_date DATE,
+    ADD COLUMN IF NOT EXISTS question_ids INTEGER[],
+    ALTER COLUMN score TYPE NUMERIC(10, 2),
+    DROP CONSTRAINT IF EXISTS quiz_sessions_difficulty_check,
+    ADD CONSTRAINT quiz_sessions_difficulty_check CHECK (difficulty IN ('easy', 'medium', 'advance', 'mixed')),
+    DROP CONSTRAINT IF EXISTS quiz_sessions_status_check,
+    ADD CONSTRAINT quiz_sessions_status_check CHECK (status IN ('playing', 'finished', 'abandoned')),
+    DROP CONSTRAINT IF EXISTS quiz_sessions_check,
+    ADD CONSTRAINT quiz_sessions_check CHECK ((mode = 'daily') = (challenge_date IS NOT NULL));
+
+-- User answers: answers recorded before partial credit were all or nothing
+ALTER TABLE user_answers
+    ADD COLUMN IF NOT EXISTS credit NUMERIC(3, 2) NOT NULL DEFAULT 0;
+UPDATE user_answers SET credit = 1 WHERE is_correct AND credit = 0;
+
+-- New tables, as in schema.sql
+CREATE TABLE IF NOT EXISTS question_attachments (
+    id SERIAL PRIMARY KEY,
+    question_id INTEGER NOT NULL REFERENCES questions(id) ON DELETE CASCADE,
+    blob_key VARCHAR(128) UNIQUE NOT NULL,
+    filename VARCHAR(255) NOT NULL,
+    content_type VARCHAR(100) NOT NULL,
+    size_bytes BIGINT NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS question_translations (
+    question_id INTEGER NOT NULL REFERENCES questions(id) ON DELETE CASCADE,
+    locale VARCHAR(5) NOT NULL CHECK (locale IN ('en', 'id')),
+    question_text TEXT NOT NULL,
+    options TEXT[],
+    accepted_answers TEXT[],
+    explanation TEXT,
+    updated_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    PRIMARY KEY (question_id, locale)
+);
+
+CREATE TABLE IF NOT EXISTS best_scores_by_day (
+    user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+    difficulty VARCHAR(10) NOT NULL CHECK (difficulty IN ('easy', 'medium', 'advance')),
+    day DATE NOT NULL,
+    score NUMERIC(10, 2) NOT NULL,
+    achieved_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    PRIMARY KEY (user_id, difficulty, day)
+);
+
+CREATE TABLE IF NOT EXISTS daily_challenges (
+    challenge_date DATE PRIMARY KEY,
+    question_ids INTEGER[] NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS password_reset_tokens (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+    token_hash CHAR(64) UNIQUE NOT NULL,
+    expires_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    used_at TIMESTAMP WITH TIME ZONE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS user_achievements (
+    user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+    code VARCHAR(50) NOT NULL,
+    awarded_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, code)
+);
+
+CREATE TABLE IF NOT EXISTS classes (
+    id SERIAL PRIMARY KEY,
+    teacher_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+    name VARCHAR(100) NOT NULL,
+    join_code VARCHAR(16) UNIQUE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS class_members (
+    class_id INTEGER REFERENCES classes(id) ON DELETE CASCADE,
+    user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+    joined_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    PRIMARY KEY (class_id, user_id)
+);
+
+CREATE TABLE IF NOT EXISTS class_assignments (
+    id SERIAL PRIMARY KEY,
+    class_id INTEGER REFERENCES classes(id) ON DELETE CASCADE,
+    title VARCHAR(200) NOT NULL,
+    difficulty VARCHAR(10) CHECK (difficulty IN ('easy', 'medium', 'advance')),
+    question_ids INTEGER[],
+    due_at TIMESTAMP WITH TIME ZONE NOT NULL,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    CHECK ((difficulty IS NULL) <> (question_ids IS NULL))
+);
+
+CREATE TABLE IF NOT EXISTS user_identities (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER REFERENCES users(id) ON DELETE CASCADE,
+    issuer TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    email VARCHAR(255),
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT NOW(),
+    UNIQUE(issuer, subject)
+);
+
+CREATE TABLE IF NOT EXISTS rate_limit_counters (
+    key VARCHAR(255) PRIMARY KEY,
+    count INTEGER NOT NULL DEFAULT 0,
+    window_start TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS auth_lockouts (
+    key VARCHAR(255) PRIMARY KEY,
+    failures INTEGER NOT NULL DEFAULT 0,
+    last_failure_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    locked_until TIMESTAMP WITH TIME ZONE
+);
+
+-- Indexes
+CREATE INDEX IF NOT EXISTS idx_users_guest_created_at ON users(created_at) WHERE is_guest;
+CREATE INDEX IF NOT EXISTS idx_password_reset_tokens_user_id ON password_reset_tokens(user_id);
+CREATE INDEX IF NOT EXISTS idx_user_identities_user_id ON user_identities(user_id);
+CREATE INDEX IF NOT EXISTS idx_classes_teacher_id ON classes(teacher_id);
+CREATE INDEX IF NOT EXISTS idx_class_members_user_id ON class_members(user_id);
+CREATE INDEX IF NOT EXISTS idx_class_assignments_class_id ON class_assignments(class_id);
+CREATE INDEX IF NOT EXISTS idx_quiz_sessions_user_difficulty_finished ON quiz_sessions(user_id, difficulty, finished_at);
+CREATE INDEX IF NOT EXISTS idx_question_attachments_question_id ON question_attachments(question_id);
+CREATE INDEX IF NOT EXISTS idx_questions_search_vector ON questions USING GIN (search_vector);
+CREATE INDEX IF NOT EXISTS idx_questions_normalized_text_trgm ON questions USING GIN (normalized_text gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS idx_questions_created_at_id ON questions(created_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS idx_best_scores_by_day_difficulty_day ON best_scores_by_day(difficulty, day);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_quiz_sessions_user_daily ON quiz_sessions(user_id, challenge_date) WHERE mode = 'daily';
+CREATE INDEX IF NOT EXISTS idx_quiz_sessions_daily_leaderboard ON quiz_sessions(challenge_date, score DESC) WHERE mode = 'daily' AND status = 'finished';
+
+-- Question search vector, kept by a trigger as in schema.sql
+CREATE OR REPLACE FUNCTION questions_search_vector_update()
+RETURNS TRIGGER AS $$
+BEGIN
+    NEW.search_vector :=
+        setweight(to_tsvector('simple', COALESCE(NEW.question_text, '')), 'A') ||
+        setweight(to_tsvector('simple', COALESCE(array_to_string(NEW.options, ' '), '')), 'B');
+    RETURN NEW;
+END;
+$$ language 'plpgsql';
+
+DROP TRIGGER IF EXISTS questions_search_vector ON questions;
+CREATE TRIGGER questions_search_vector BEFORE INSERT OR UPDATE OF question_text, options ON questions FOR EACH ROW EXECUTE FUNCTION questions_search_vector_update();
+
+-- Questions written before the trigger existed; setting question_text to
+-- itself fires it
+UPDATE questions SET question_text = question_text WHERE search_vector IS NULL;
+
+-- Fill best_scores_by_day from standard sessions that finished before the
+-- table existed. It keeps the better score where a day already has one.
+-- Days must fall where the server puts them: replace 'UTC' with
+-- DAILY_CHALLENGE_TZ if that is set.
+INSERT INTO best_scores_by_day (user_id, difficulty, day, score, achieved_at)
+SELECT DISTINCT ON (user_id, difficulty, (finished_at AT TIME ZONE 'UTC')::date)
+    user_id, difficulty, (finished_at AT TIME ZONE 'UTC')::date, score, finished_at
+FROM quiz_sessions
+WHERE status = 'finished' AND mode = 'standard' AND finished_at IS NOT NULL
+ORDER BY user_id, difficulty, (finished_at AT TIME ZONE 'UTC')::date, score DESC, finished_at
+ON CONFLICT (user_id, difficulty, day) DO UPDATE
+SET score = EXCLUDED.score, achieved_at = EXCLUDED.achieved_at
+WHERE best_scores_by_day.score < EXCLUDED.score;
+
+COMMIT;